@@ -0,0 +1,156 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+//Canonicalizer rewrites addresses so that the different ways the same article is
+// linked to compare equal.  Schemes become https, hosts are lower cased and lose
+// their www. prefix and default port, and trailing slashes, fragments and
+// tracking parameters are removed.
+type Canonicalizer struct {
+	//DropParams are query parameters removed from every address.  A trailing *
+	// matches any parameter with that prefix, e.g. utm_*.
+	DropParams []string `json:"drop_params"`
+
+	//Domains holds rules for individual hosts, keyed by the host without www.
+	// A rule for example.com also applies to its subdomains.
+	Domains map[string]DomainRule `json:"domains"`
+}
+
+//DomainRule changes how the addresses of one domain are canonicalized.
+type DomainRule struct {
+	//KeepParams, if set, are the only query parameters kept, e.g. v for the
+	// videos on youtube.com.
+	KeepParams []string `json:"keep_params"`
+
+	//DropParams are removed in addition to the Canonicalizer's DropParams.
+	DropParams []string `json:"drop_params"`
+
+	//KeepFragment keeps the #fragment, for sites that route on it.
+	KeepFragment bool `json:"keep_fragment"`
+
+	//KeepTrailingSlash keeps a trailing slash on the path.
+	KeepTrailingSlash bool `json:"keep_trailing_slash"`
+}
+
+//DefaultCanonicalizer is used by LinkTweetFrom and the reducer.  The
+// tweetharvest command replaces it with the rules given by -url-rules.
+var DefaultCanonicalizer = &Canonicalizer{
+	DropParams: []string{
+		"utm_*", "fbclid", "gclid", "dclid", "igshid", "mc_cid", "mc_eid",
+		"_hsenc", "_hsmi", "mkt_tok", "ref_src", "ref_url",
+	},
+	Domains: map[string]DomainRule{
+		"youtube.com": {KeepParams: []string{"v", "list", "t"}},
+		"twitter.com": {DropParams: []string{"s", "t"}},
+	},
+}
+
+//LoadCanonicalizer reads a Canonicalizer from a JSON file.
+func LoadCanonicalizer(path string) (*Canonicalizer, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	canon := &Canonicalizer{}
+	if err := json.Unmarshal(raw, canon); err != nil {
+		return nil, err
+	}
+	return canon, nil
+}
+
+//Canonical returns the canonical form of address.  Addresses that can't be
+// parsed as absolute http(s) URLs are returned unchanged.
+func (canon *Canonicalizer) Canonical(address string) string {
+	u, err := url.Parse(strings.TrimSpace(address))
+	if err != nil || u.Host == "" {
+		return address
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return address
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	port := u.Port()
+	if port == "80" || port == "443" {
+		port = ""
+	}
+	rule := canon.rule(host)
+
+	u.Scheme = "https"
+	u.User = nil
+	u.Host = host
+	if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	}
+	if port != "" {
+		u.Host = u.Host + ":" + port
+	}
+
+	if !rule.KeepTrailingSlash {
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = ""
+	}
+	if !rule.KeepFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	values := u.Query()
+	for name := range values {
+		if canon.drop(name, rule) {
+			values.Del(name)
+		}
+	}
+	//Encode sorts the parameters, so their order doesn't matter either.
+	u.RawQuery = values.Encode()
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+//rule returns the rule for host or the nearest parent domain that has one.
+func (canon *Canonicalizer) rule(host string) DomainRule {
+	for domain := host; domain != ""; {
+		if rule, ok := canon.Domains[domain]; ok {
+			return rule
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return DomainRule{}
+}
+
+//drop reports whether the query parameter name is removed under rule.
+func (canon *Canonicalizer) drop(name string, rule DomainRule) bool {
+	if len(rule.KeepParams) > 0 {
+		return !matchParam(name, rule.KeepParams)
+	}
+	return matchParam(name, canon.DropParams) || matchParam(name, rule.DropParams)
+}
+
+//matchParam reports whether name matches one of the patterns, where a trailing *
+// matches any suffix.
+func matchParam(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
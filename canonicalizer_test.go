@@ -0,0 +1,77 @@
+package tweetharvest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	canon := &Canonicalizer{
+		DropParams: []string{"utm_*", "fbclid"},
+		Domains: map[string]DomainRule{
+			"youtube.com": {KeepParams: []string{"v"}},
+			"example.org": {DropParams: []string{"ref"}, KeepFragment: true, KeepTrailingSlash: true},
+		},
+	}
+
+	same := []string{
+		"https://blog.golang.org/go1.5.1",
+		"http://blog.golang.org/go1.5.1",
+		"https://Blog.Golang.org/go1.5.1/",
+		"https://www.blog.golang.org:443/go1.5.1#comments",
+		"https://blog.golang.org/go1.5.1?utm_source=twitter&utm_medium=social",
+		"https://blog.golang.org/go1.5.1?fbclid=abc",
+	}
+	for _, address := range same {
+		if got := canon.Canonical(address); got != "https://blog.golang.org/go1.5.1" {
+			t.Errorf("Canonical(%v) = %v", address, got)
+		}
+	}
+
+	cases := map[string]string{
+		//Remaining parameters are kept, in sorted order.
+		"http://example.com/a?b=2&a=1&utm_campaign=x": "https://example.com/a?a=1&b=2",
+		"http://example.com/":                         "https://example.com",
+		"http://example.com:8080/a":                   "https://example.com:8080/a",
+		//Paths keep their case.
+		"http://example.com/Go/Spec": "https://example.com/Go/Spec",
+		//Domain rules apply to subdomains too.
+		"https://m.youtube.com/watch?v=abc&feature=share":      "https://m.youtube.com/watch?v=abc",
+		"https://example.org/app/?ref=home&page=2#/article/12": "https://example.org/app/?page=2#/article/12",
+		//Things that aren't web addresses are left alone.
+		"mailto:gopher@golang.org": "mailto:gopher@golang.org",
+		"not a url":                "not a url",
+	}
+	for address, expected := range cases {
+		if got := canon.Canonical(address); got != expected {
+			t.Errorf("Canonical(%v) = %v, expected %v", address, got, expected)
+		}
+	}
+}
+
+func TestLoadCanonicalizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "canonicalizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{
+		"drop_params": ["ref"],
+		"domains": {"example.com": {"keep_params": ["id"]}}
+	}`), 0644)
+
+	canon, err := LoadCanonicalizer(path)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if got := canon.Canonical("http://a.com/?ref=x&utm_source=y"); got != "https://a.com?utm_source=y" {
+		t.Errorf("Expected only the loaded parameters to be dropped, got %v", got)
+	}
+	if got := canon.Canonical("http://example.com/p?id=1&x=2"); got != "https://example.com/p?id=1" {
+		t.Errorf("Expected the domain rule to be loaded, got %v", got)
+	}
+}
@@ -0,0 +1,146 @@
+//Command tweetharvest runs TweetHarvest as a standalone server, outside of App
+// Engine.  It serves the same /map, /reduce and /consume endpoints, keeps its data
+// in SQLite and runs the harvest cycle from cron.yaml itself.
+//
+//Twitter credentials are read from the TWITTER_CONSUMER_KEY,
+// TWITTER_CONSUMER_SECRET, TWITTER_ACCESS_TOKEN and TWITTER_ACCESS_TOKEN_SECRET
+// environment variables.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	tweetharvest "github.com/AndyNortrup/TweetHarvest"
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve HTTP on")
+	db := flag.String("db", "tweetharvest.db", "path of the SQLite database")
+	queries := flag.String("queries", "golang",
+		"comma separated queries to create topics for, if they don't exist yet")
+	harvestEvery := flag.Duration("harvest-every", time.Hour,
+		"harvest interval of the topics created by -queries")
+	replay := flag.String("replay", "",
+		"comma separated search fixture files to harvest from instead of Twitter")
+	maxPages := flag.Int("max-pages", 10,
+		"most pages of search results fetched by each harvest")
+	stream := flag.Bool("stream", false,
+		"also harvest the enabled topics from Twitter's filter stream as tweets are posted")
+	urlRules := flag.String("url-rules", "",
+		"JSON file of the query parameters and per-domain rules used to canonicalize addresses")
+	flag.Parse()
+
+	logger := log.NewStdLogger(os.Stderr)
+	log.Default = logger
+	c, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *urlRules != "" {
+		canon, err := tweetharvest.LoadCanonicalizer(*urlRules)
+		if err != nil {
+			log.Errorf(c, "Failed to load URL rules: %v", err.Error())
+			os.Exit(1)
+		}
+		tweetharvest.DefaultCanonicalizer = canon
+	}
+
+	store, err := tweetharvest.NewSQLiteStore(*db)
+	if err != nil {
+		log.Errorf(c, "Failed to open database %v: %v", *db, err.Error())
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	credentials := tweetharvest.TwitterSource{
+		ConsumerKey:       os.Getenv("TWITTER_CONSUMER_KEY"),
+		ConsumerSecret:    os.Getenv("TWITTER_CONSUMER_SECRET"),
+		AccessToken:       os.Getenv("TWITTER_ACCESS_TOKEN"),
+		AccessTokenSecret: os.Getenv("TWITTER_ACCESS_TOKEN_SECRET"),
+	}
+	var source tweetharvest.TweetSource = credentials
+	if *replay != "" {
+		source, err = tweetharvest.NewReplaySource(strings.Split(*replay, ",")...)
+		if err != nil {
+			log.Errorf(c, "Failed to load replay files: %v", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	resolver := tweetharvest.NewResolver()
+	router := tweetharvest.NewRouter(tweetharvest.Services{
+		Store:      store,
+		Source:     source,
+		NewContext: tweetharvest.StandaloneContext(logger, &http.Client{Timeout: time.Minute}),
+		Resolver:   resolver,
+		MaxPages:   *maxPages,
+	})
+
+	for _, query := range strings.Split(*queries, ",") {
+		if query = strings.TrimSpace(query); query != "" {
+			addTopic(c, store, query, *harvestEvery)
+		}
+	}
+
+	var scheduler tweetharvest.Scheduler
+	scheduler.Add(tweetharvest.Job{
+		Description: "Harvest of tweets for each enabled topic that is due, then reduce",
+		Every:       time.Minute,
+		Run:         tweetharvest.RequestJob(router, "/cycle"),
+	})
+	go scheduler.Run(c)
+
+	if *stream {
+		harvester := &tweetharvest.StreamHarvester{
+			Store: store,
+			Source: tweetharvest.NewTwitterStreamSource(credentials.ConsumerKey, credentials.ConsumerSecret,
+				credentials.AccessToken, credentials.AccessTokenSecret),
+			Resolver: resolver,
+		}
+		go harvester.Run(tweetharvest.WithHTTPClient(c, &http.Client{Timeout: time.Minute}))
+	}
+
+	server := &http.Server{Addr: *addr, Handler: router}
+	go func() {
+		<-c.Done()
+		shutdown, done := context.WithTimeout(context.Background(), 30*time.Second)
+		defer done()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Infof(c, "Serving on %v", *addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf(c, "Server failed: %v", err.Error())
+		os.Exit(1)
+	}
+}
+
+//addTopic creates an enabled topic for query unless one already exists, so that
+// topics edited through /topics are left alone on restart.
+func addTopic(c context.Context, store tweetharvest.Store, query string, every time.Duration) {
+	existing, err := store.GetTopic(c, query)
+	if err != nil {
+		log.Errorf(c, "Failed to read topic %v: %v", query, err.Error())
+		return
+	}
+	if existing != nil {
+		return
+	}
+
+	topic := &tweetharvest.Topic{
+		Query:    query,
+		Interval: every,
+		Enabled:  true,
+		Created:  time.Now(),
+	}
+	if err := store.PutTopic(c, topic); err != nil {
+		log.Errorf(c, "Failed to create topic %v: %v", query, err.Error())
+	}
+}
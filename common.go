@@ -1,14 +1,16 @@
 package tweetharvest
 
 import (
+	"context"
 	"errors"
 	"net/http"
 
-	"golang.org/x/net/context"
-	"google.golang.org/appengine/log"
+	"github.com/AndyNortrup/TweetHarvest/log"
 )
 
 const queryParam string = "q"
+const sortParam string = "sort"
+const formatParam string = "format"
 
 //getQuery pulls the query from the q paramenter of the query string
 func getQuery(request *http.Request, c context.Context) (string, error) {
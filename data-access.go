@@ -1,55 +1,181 @@
 package tweetharvest
 
 import (
+	"context"
 	"time"
-
-	"golang.org/x/net/context"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
-const tweetKey string = "Tweets"
-const tweetKeyID string = "default_tweetstore"
-
-//GetAllNewTweets queries the datastore and gets all tweets created since the last
-// time given
-func GetAllNewTweets(since time.Time, c context.Context) LinkTweets {
-	log.Infof(c, "Getting all tweets newer than: %v", since)
-	q := datastore.NewQuery(linkTweetKind).Ancestor(getTweetKey(c)).Filter("CreatedTime >", since)
-	out := make(LinkTweets, 0, 15)
-	q.GetAll(c, &out)
-	return out
+//Store is the persistence layer shared by the map, reduce and consume handlers.
+// DatastoreStore is used on App Engine, SQLiteStore for self-hosted deployments
+// and MemoryStore in tests.
+type Store interface {
+	TweetStore
+	HarvestStore
+	ScoreStore
+	TopicStore
+	DomainStore
+	JobStore
 }
 
-func getNewestTweet(c context.Context) time.Time {
-	var latest LinkTweet
+//TweetStore holds the LinkTweets written by the map stage.
+type TweetStore interface {
+	//PutLinkTweets writes a batch of harvested LinkTweets to the store, giving each
+	// a Sequence greater than that of any tweet written before it.
+	PutLinkTweets(c context.Context, tweets LinkTweets) error
+
+	//GetTweetsAfter returns up to limit of the LinkTweets for a query with a
+	// Sequence greater than the given one, in Sequence order.
+	GetTweetsAfter(c context.Context, query string, sequence int64, limit int) (LinkTweets, error)
+
+	//TweetQueries returns each query that has LinkTweets, in order.
+	TweetQueries(c context.Context) ([]string, error)
+
+	//GetAllNewTweets returns all of the LinkTweets created after the given time.
+	GetAllNewTweets(c context.Context, since time.Time) (LinkTweets, error)
+
+	//NewestTweet returns the creation time of the newest LinkTweet in the store,
+	// or the zero time if the store is empty.
+	NewestTweet(c context.Context) (time.Time, error)
 
-	//Get just the key for the newest tweet
-	q := datastore.NewQuery(linkTweetKind).Order("-CreatedTime").Project("CreatedTime").Limit(1)
-	q.GetAll(c, latest)
-	i := q.Run(c)
-	i.Next(&latest)
-	time, _ := latest.CreatedAtTime()
-	return time
+	//LinkTweet returns the LinkTweet that has the given TweetID, or nil if there is
+	// no such tweet.
+	LinkTweet(c context.Context, tweetID int64) (*LinkTweet, error)
+
+	//QueryLinkTweet returns the LinkTweet that has the given TweetID under a query,
+	// or nil if the tweet hasn't been stored for that query.
+	QueryLinkTweet(c context.Context, query string, tweetID int64) (*LinkTweet, error)
 }
 
-// guestbookKey returns the key used for all guestbook entries.
-func getTweetKey(c context.Context) *datastore.Key {
-	// The string "default_guestbook" here could be varied to have multiple guestbooks.
-	return datastore.NewKey(c, tweetKey, tweetKeyID, 0, nil)
+//HarvestStore holds the HarvestCheckpoint of each query and the RateLimit of each
+// endpoint harvested from.
+type HarvestStore interface {
+	//GetHarvestCheckpoint returns the HarvestCheckpoint for a query, or nil if it
+	// has never been harvested.
+	GetHarvestCheckpoint(c context.Context, query string) (*HarvestCheckpoint, error)
+
+	//PutHarvestCheckpoint creates or replaces the HarvestCheckpoint for its Query.
+	PutHarvestCheckpoint(c context.Context, checkpoint *HarvestCheckpoint) error
+
+	//GetRateLimit returns the RateLimit of an endpoint, or nil if it has never been
+	// called.
+	GetRateLimit(c context.Context, endpoint string) (*RateLimit, error)
+
+	//GetRateLimits returns the RateLimits of every endpoint, ordered by endpoint.
+	GetRateLimits(c context.Context) ([]*RateLimit, error)
+
+	//PutRateLimit creates or replaces the RateLimit of its Endpoint.
+	PutRateLimit(c context.Context, limit *RateLimit) error
 }
 
-//LinkTweetFromDatastore returns a LinkTweet from the DataStore that has the given TweetID
-func LinkTweetFromDatastore(tweetID int64, c context.Context) *LinkTweet {
-	q := datastore.NewQuery(linkTweetKind).
-		Filter("TweetID =", tweetID).
-		Limit(1)
-
-	iterator := q.Run(c)
-	linkTweet := &LinkTweet{}
-	_, err := iterator.Next(linkTweet)
-	if err != nil {
-		return nil
-	}
-	return linkTweet
+//ScoreStore holds the TweetScores written by the reduce stage, and the
+// ReduceCheckpoint of each query reduced.
+type ScoreStore interface {
+	//UpdateScore loads the TweetScore for an address under a query, hands it to
+	// update and saves the result as a single transaction.  Each query has its own
+	// score for an address.  exists reports whether a score was already stored; if
+	// update returns an error nothing is written.
+	UpdateScore(c context.Context, query string, address string,
+		update func(score *TweetScore, exists bool) error) error
+
+	//UpdateScores loads the TweetScore for each of the addresses under the
+	// checkpoint's Query and hands it to update, as UpdateScore does, then saves
+	// the results and the checkpoint as a single transaction.  from is the
+	// Sequence the stored checkpoint must still have, 0 if there is none; if
+	// another reduce has moved it, errCheckpointMoved is returned.  If any update
+	// returns an error nothing is written.
+	UpdateScores(c context.Context, addresses []string, from int64, checkpoint *ReduceCheckpoint,
+		update func(score *TweetScore, exists bool) error) error
+
+	//AddressScores returns the TweetScores of an address under every query it has
+	// been scored for, ordered by Query.
+	AddressScores(c context.Context, address string) ([]*TweetScore, error)
+
+	//GetReduceCheckpoint returns the ReduceCheckpoint for a query, or nil if it has
+	// never been reduced.
+	GetReduceCheckpoint(c context.Context, query string) (*ReduceCheckpoint, error)
+
+	//RecentScores returns the TweetScores for a query that have been active since
+	// the given time, most recently active first.
+	RecentScores(c context.Context, query string, since time.Time) ([]*TweetScore, error)
+
+	//LastScoreActivity returns the newest LastActive time of any TweetScore, or the
+	// zero time if there are no scores.
+	LastScoreActivity(c context.Context) (time.Time, error)
+
+	//LastQueryActivity returns the newest LastActive time of the TweetScores for a
+	// query, or the zero time if it has no scores.
+	LastQueryActivity(c context.Context, query string) (time.Time, error)
+
+	//LastQueryUpdate returns the newest Updated time of the TweetScores for a
+	// query, or the zero time if it has no scores.
+	LastQueryUpdate(c context.Context, query string) (time.Time, error)
+}
+
+//TopicStore holds the Topics that are harvested on a schedule.
+type TopicStore interface {
+	//PutTopic creates or replaces the Topic with the same Query.
+	PutTopic(c context.Context, topic *Topic) error
+
+	//UpdateTopic loads the Topic for a query, hands it to update and saves the
+	// result as a single transaction.  exists reports whether the topic was
+	// already stored; if update returns an error nothing is written.
+	UpdateTopic(c context.Context, query string, update func(topic *Topic, exists bool) error) error
+
+	//GetTopic returns the Topic for a query, or nil if there is no such topic.
+	GetTopic(c context.Context, query string) (*Topic, error)
+
+	//GetTopics returns all of the Topics, ordered by Query.
+	GetTopics(c context.Context) ([]*Topic, error)
+
+	//DeleteTopic removes the Topic for a query.  Deleting a missing topic is not
+	// an error.
+	DeleteTopic(c context.Context, query string) error
+}
+
+//DomainStore holds the Domains the reduce stage aggregates scores into, and the
+// DomainPolicies set on them.
+type DomainStore interface {
+	//UpdateDomain loads the Domain with the given name for a query, hands it to
+	// update and saves the result as a single transaction.  exists reports whether
+	// the domain was already stored; if update returns an error nothing is written.
+	UpdateDomain(c context.Context, query string, name string,
+		update func(domain *Domain, exists bool) error) error
+
+	//TopDomains returns up to limit of the Domains for a query, highest Score
+	// first.
+	TopDomains(c context.Context, query string, limit int) ([]*Domain, error)
+
+	//PutDomainPolicy creates or replaces the DomainPolicy with the same Name.
+	PutDomainPolicy(c context.Context, policy *DomainPolicy) error
+
+	//GetDomainPolicy returns the DomainPolicy for a domain, or nil if there is no
+	// such policy.
+	GetDomainPolicy(c context.Context, name string) (*DomainPolicy, error)
+
+	//GetDomainPolicies returns all of the DomainPolicies, ordered by Name.
+	GetDomainPolicies(c context.Context) (DomainPolicies, error)
+
+	//DeleteDomainPolicy removes the DomainPolicy for a domain.  Deleting a missing
+	// policy is not an error.
+	DeleteDomainPolicy(c context.Context, name string) error
+}
+
+//JobStore holds the JobRuns recorded by the JobOrchestrator.
+type JobStore interface {
+	//PutJobRun creates the JobRun and sets its ID if the ID is 0, and otherwise
+	// replaces the JobRun with the same ID.
+	PutJobRun(c context.Context, run *JobRun) error
+
+	//GetJobRun returns the JobRun with the given ID, or nil if there is no such
+	// run.
+	GetJobRun(c context.Context, id int64) (*JobRun, error)
+
+	//GetJobRuns returns up to limit JobRuns with the given status, or with any
+	// status if it is empty, newest first.
+	GetJobRuns(c context.Context, status string, limit int) ([]*JobRun, error)
+
+	//UpdateJobRun finds the JobRun with the given ID inside a transaction, lets
+	// update modify it and writes it back.  Nothing is written if there is no such
+	// run or update returns an error, which UpdateJobRun returns.
+	UpdateJobRun(c context.Context, id int64, update func(run *JobRun, exists bool) error) error
 }
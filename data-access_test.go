@@ -0,0 +1,575 @@
+package tweetharvest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//testLinkTweet builds a LinkTweet for tests with the given ID, address, query and
+// creation time.
+func testLinkTweet(id int64, address string, query string, created time.Time) *LinkTweet {
+	return &LinkTweet{
+		Address: address,
+		Query:   query,
+		Tweet: anaconda.Tweet{
+			Id:        id,
+			CreatedAt: created.Format(time.RubyDate),
+		},
+	}
+}
+
+//testStoreTweets exercises the LinkTweet half of a Store.  store must be empty.
+func testStoreTweets(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	newest, err := store.NewestTweet(c)
+	if err != nil || !newest.IsZero() {
+		t.Fatalf("Expected zero time from an empty store, got %v, %v", newest, err)
+	}
+
+	original := testLinkTweet(2, "http://b.com", "golang", now.Add(-time.Hour))
+	original.OriginalAddress = "http://www.b.com/?utm_source=twitter"
+	original.ShortAddress = "http://bit.ly/b"
+	err = store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, "http://a.com", "golang", now.Add(-2*time.Hour)),
+		original,
+		testLinkTweet(3, "http://a.com", "golang", now),
+	})
+	if err != nil {
+		t.Fatalf("Failed to put tweets: %v", err)
+	}
+
+	newest, _ = store.NewestTweet(c)
+	if !newest.Equal(now) {
+		t.Errorf("Expected newest tweet at %v, got %v", now, newest)
+	}
+
+	tweets, _ := store.GetAllNewTweets(c, now.Add(-90*time.Minute))
+	if len(tweets) != 2 {
+		t.Errorf("Expected 2 new tweets, got %v", len(tweets))
+	}
+
+	tweet, _ := store.LinkTweet(c, 2)
+	if tweet == nil || tweet.Address != "http://b.com" {
+		t.Errorf("Failed to find tweet 2, got %v", tweet)
+	}
+	if tweet != nil && (tweet.OriginalAddress != original.OriginalAddress ||
+		tweet.ShortAddress != original.ShortAddress) {
+		t.Errorf("Expected the original and short addresses to be stored, got %v and %v",
+			tweet.OriginalAddress, tweet.ShortAddress)
+	}
+
+	tweet, _ = store.LinkTweet(c, 4)
+	if tweet != nil {
+		t.Errorf("Expected no tweet for an unknown ID, got %v", tweet)
+	}
+
+	if tweet, _ = store.QueryLinkTweet(c, "golang", 2); tweet == nil || tweet.Query != "golang" {
+		t.Errorf("Failed to find tweet 2 under golang, got %v", tweet)
+	}
+	if tweet, _ = store.QueryLinkTweet(c, "rust", 2); tweet != nil {
+		t.Errorf("Expected no tweet 2 under rust, got %v", tweet)
+	}
+
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(4, "http://c.com", "rust", now)})
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(5, "http://c.com", "golang", now)})
+
+	tweets, _ = store.GetTweetsAfter(c, "golang", 0, 10)
+	if len(tweets) != 4 {
+		t.Fatalf("Expected 4 golang tweets, got %v", len(tweets))
+	}
+	for i := 1; i < len(tweets); i++ {
+		if tweets[i].Sequence <= tweets[i-1].Sequence {
+			t.Errorf("Expected tweets in increasing Sequence order, got %v after %v",
+				tweets[i].Sequence, tweets[i-1].Sequence)
+		}
+	}
+	if tweets[3].Id != 5 {
+		t.Errorf("Expected the tweet written last to be last, got %v", tweets[3].Id)
+	}
+
+	after, _ := store.GetTweetsAfter(c, "golang", tweets[1].Sequence, 1)
+	if len(after) != 1 || after[0].Id != tweets[2].Id {
+		t.Errorf("Expected only tweet %v after the checkpoint, got %v", tweets[2].Id, after)
+	}
+
+	queries, _ := store.TweetQueries(c)
+	if len(queries) != 2 || queries[0] != "golang" || queries[1] != "rust" {
+		t.Errorf("Expected the queries golang and rust, got %v", queries)
+	}
+}
+
+//testStoreScores exercises the TweetScore half of a Store.  store must be empty.
+func testStoreScores(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now()
+
+	err := store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+		if exists {
+			t.Errorf("Score should not exist before it is written")
+		}
+		score.Score = 2
+		score.LastActive = now.Add(-time.Hour)
+		score.FirstSeen = now.Add(-2 * time.Hour)
+		score.OriginalAddress = "http://www.a.com/"
+		score.Description = "About a"
+		score.Published = now.Add(-24 * time.Hour)
+		score.TweetIDs = []int64{1}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update score: %v", err)
+	}
+
+	err = store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+		if !exists || score.Score != 2 {
+			t.Errorf("Expected the stored score, got %v, %v", score, exists)
+		}
+		score.Score = 100
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Errorf("Expected the update error to be returned")
+	}
+
+	store.UpdateScore(c, "golang", "http://b.com", func(score *TweetScore, exists bool) error {
+		score.LastActive = now
+		return nil
+	})
+	store.UpdateScore(c, "rust", "http://c.com", func(score *TweetScore, exists bool) error {
+		score.LastActive = now
+		return nil
+	})
+
+	scores, _ := store.RecentScores(c, "golang", now.Add(-2*time.Hour))
+	if len(scores) != 2 {
+		t.Fatalf("Expected 2 recent scores, got %v", len(scores))
+	}
+	if scores[0].Address != "http://b.com" {
+		t.Errorf("Expected scores ordered by LastActive, got %v first", scores[0].Address)
+	}
+	if scores[1].Score != 2 {
+		t.Errorf("Failed update should not have been saved, score is %v", scores[1].Score)
+	}
+	if !scores[1].FirstSeen.Equal(now.Add(-2*time.Hour)) || !scores[0].FirstSeen.IsZero() {
+		t.Errorf("Expected FirstSeen to be kept, got %v and %v", scores[1].FirstSeen, scores[0].FirstSeen)
+	}
+	if scores[1].Description != "About a" || !scores[1].Published.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Expected the page metadata to be kept, got %q, %v", scores[1].Description, scores[1].Published)
+	}
+	if scores[1].OriginalAddress != "http://www.a.com/" {
+		t.Errorf("Expected the original address to be kept, got %v", scores[1].OriginalAddress)
+	}
+
+	last, _ := store.LastScoreActivity(c)
+	if !last.Equal(now) {
+		t.Errorf("Expected last activity %v, got %v", now, last)
+	}
+
+	last, _ = store.LastQueryActivity(c, "golang")
+	if !last.Equal(now) {
+		t.Errorf("Expected last golang activity %v, got %v", now, last)
+	}
+	last, _ = store.LastQueryActivity(c, "haskell")
+	if !last.IsZero() {
+		t.Errorf("Expected no activity for a query without scores, got %v", last)
+	}
+
+	//Every write to a score moves the query's last update, LastActive or not.
+	before, _ := store.LastQueryUpdate(c, "golang")
+	store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+		score.Title = "Renamed"
+		return nil
+	})
+	if after, _ := store.LastQueryUpdate(c, "golang"); before.IsZero() || !after.After(before) {
+		t.Errorf("Expected the last golang update to move on from %v, got %v", before, after)
+	}
+	if last, _ = store.LastQueryUpdate(c, "haskell"); !last.IsZero() {
+		t.Errorf("Expected no updates for a query without scores, got %v", last)
+	}
+
+	checkpoint, err := store.GetReduceCheckpoint(c, "golang")
+	if err != nil || checkpoint != nil {
+		t.Fatalf("Expected no checkpoint before one is written, got %v, %v", checkpoint, err)
+	}
+
+	addresses := []string{"http://a.com", "http://d.com"}
+	err = store.UpdateScores(c, addresses, 0, &ReduceCheckpoint{Query: "golang", Sequence: 7, Updated: now},
+		func(score *TweetScore, exists bool) error {
+			if score.Address == "http://d.com" {
+				return errors.New("abort")
+			}
+			score.Score++
+			return nil
+		})
+	if err == nil {
+		t.Errorf("Expected the update error to be returned")
+	}
+	if checkpoint, _ = store.GetReduceCheckpoint(c, "golang"); checkpoint != nil {
+		t.Errorf("Failed updates should not have written the checkpoint, got %v", checkpoint)
+	}
+
+	err = store.UpdateScores(c, addresses, 0, &ReduceCheckpoint{Query: "golang", Sequence: 7, Updated: now},
+		func(score *TweetScore, exists bool) error {
+			if exists != (score.Address == "http://a.com") || score.Query != "golang" {
+				t.Errorf("Unexpected exists %v for %v under %q", exists, score.Address, score.Query)
+			}
+			score.Score++
+			score.LastActive = now
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Failed to update scores: %v", err)
+	}
+
+	scores, _ = store.RecentScores(c, "golang", now.Add(-time.Minute))
+	if len(scores) != 3 {
+		t.Fatalf("Expected 3 recent scores, got %v", len(scores))
+	}
+	for _, score := range scores {
+		if score.Address == "http://a.com" && score.Score != 3 {
+			t.Errorf("Expected the score for a.com to be updated once, got %v", score.Score)
+		}
+	}
+	checkpoint, _ = store.GetReduceCheckpoint(c, "golang")
+	if checkpoint == nil || checkpoint.Sequence != 7 || !checkpoint.Updated.Equal(now) {
+		t.Errorf("Expected the checkpoint to be written with the scores, got %v", checkpoint)
+	}
+
+	//A batch read before another reduce moved the checkpoint isn't written.
+	err = store.UpdateScores(c, addresses, 0, &ReduceCheckpoint{Query: "golang", Sequence: 5, Updated: now},
+		func(score *TweetScore, exists bool) error {
+			score.Score++
+			return nil
+		})
+	if err != errCheckpointMoved {
+		t.Errorf("Expected errCheckpointMoved, got %v", err)
+	}
+	if checkpoint, _ = store.GetReduceCheckpoint(c, "golang"); checkpoint == nil || checkpoint.Sequence != 7 {
+		t.Errorf("Expected the checkpoint to be left at 7, got %v", checkpoint)
+	}
+
+	//An address shared under another query has a score of its own there.
+	err = store.UpdateScore(c, "rust", "http://a.com", func(score *TweetScore, exists bool) error {
+		if exists {
+			t.Errorf("Expected no rust score for a.com, got %v", score)
+		}
+		score.Score = 1
+		score.LastActive = now
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update score: %v", err)
+	}
+
+	shared, _ := store.AddressScores(c, "http://a.com")
+	if len(shared) != 2 || shared[0].Query != "golang" || shared[0].Score != 3 ||
+		shared[1].Query != "rust" || shared[1].Score != 1 {
+		t.Errorf("Expected a.com scored separately under golang and rust, got %v", shared)
+	}
+	if shared, _ = store.AddressScores(c, "http://e.com"); len(shared) != 0 {
+		t.Errorf("Expected no scores for an unknown address, got %v", shared)
+	}
+}
+
+//testStoreTopics exercises the Topic half of a Store.  store must be empty.
+func testStoreTopics(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	topic, err := store.GetTopic(c, "golang")
+	if err != nil || topic != nil {
+		t.Fatalf("Expected no topic in an empty store, got %v, %v", topic, err)
+	}
+
+	store.PutTopic(c, &Topic{Query: "rust", Enabled: true})
+	err = store.PutTopic(c, &Topic{
+		Query:    "golang",
+		Title:    "Go articles",
+		Interval: 30 * time.Minute,
+		Created:  now,
+		Embed:    embedTemplate,
+		Filters:  &FilterConfig{Languages: []string{"en"}, Not: &FilterConfig{VerifiedOnly: true}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put topic: %v", err)
+	}
+
+	topic, _ = store.GetTopic(c, "golang")
+	if topic == nil || topic.Title != "Go articles" || topic.Interval != 30*time.Minute ||
+		!topic.Created.Equal(now) || !topic.LastHarvest.IsZero() || topic.Embed != embedTemplate ||
+		topic.Filters == nil || len(topic.Filters.Languages) != 1 || topic.Filters.Not == nil ||
+		!topic.Filters.Not.VerifiedOnly {
+		t.Errorf("Topic did not round trip, got %+v", topic)
+	}
+
+	topic.Enabled = true
+	topic.LastHarvest = now
+	store.PutTopic(c, topic)
+
+	err = store.UpdateTopic(c, "golang", func(stored *Topic, exists bool) error {
+		if !exists || stored.Title != "Go articles" || stored.Filters == nil {
+			t.Errorf("Expected the stored topic, got %+v, %v", stored, exists)
+		}
+		stored.Failures = 2
+		stored.LastFailure = now
+		return nil
+	})
+	topic, _ = store.GetTopic(c, "golang")
+	if err != nil || topic.Failures != 2 || !topic.LastFailure.Equal(now) || !topic.LastHarvest.Equal(now) ||
+		topic.Title != "Go articles" {
+		t.Errorf("Expected the topic to be updated, got %+v, %v", topic, err)
+	}
+	err = store.UpdateTopic(c, "golang", func(stored *Topic, exists bool) error {
+		stored.Title = "Lost"
+		return errors.New("abort")
+	})
+	if topic, _ = store.GetTopic(c, "golang"); err == nil || topic.Title != "Go articles" {
+		t.Errorf("Expected a failed update not to be saved, got %+v, %v", topic, err)
+	}
+
+	topics, _ := store.GetTopics(c)
+	if len(topics) != 2 || topics[0].Query != "golang" || !topics[0].Enabled {
+		t.Fatalf("Expected 2 topics ordered by query, got %+v", topics)
+	}
+
+	store.DeleteTopic(c, "golang")
+	if err := store.DeleteTopic(c, "golang"); err != nil {
+		t.Errorf("Deleting a missing topic should not fail, got %v", err)
+	}
+	topics, _ = store.GetTopics(c)
+	if len(topics) != 1 {
+		t.Errorf("Expected 1 topic after delete, got %v", len(topics))
+	}
+}
+
+//testStoreDomains exercises the Domain half of a Store.  store must be empty.
+func testStoreDomains(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	add := func(query string, name string, score int) {
+		err := store.UpdateDomain(c, query, name, func(domain *Domain, exists bool) error {
+			domain.addScore(&TweetScore{Score: score, FirstSeen: now, LastActive: now}, score, !exists)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update domain %v: %v", name, err)
+		}
+	}
+	add("golang", "golang.org", 3)
+	add("golang", "golang.org", 4)
+	add("golang", "example.com", 5)
+	add("golang", "dev.to", 1)
+	add("rust", "golang.org", 100)
+
+	domains, err := store.TopDomains(c, "golang", 2)
+	if err != nil || len(domains) != 2 {
+		t.Fatalf("Expected the top 2 domains, got %v, %v", domains, err)
+	}
+	top := domains[0]
+	if top.Name != "golang.org" || top.Query != "golang" || top.Score != 7 || top.Links != 1 ||
+		!top.FirstSeen.Equal(now) || !top.LastSeen.Equal(now) || domains[1].Name != "example.com" {
+		t.Errorf("Unexpected top domains %+v, %+v", top, domains[1])
+	}
+
+	policy, err := store.GetDomainPolicy(c, "example.com")
+	if err != nil || policy != nil {
+		t.Fatalf("Expected no policy in an empty store, got %v, %v", policy, err)
+	}
+	store.PutDomainPolicy(c, &DomainPolicy{Name: "example.com", Blocked: true})
+	store.PutDomainPolicy(c, &DomainPolicy{Name: "blog.golang.org", Boost: 1.5})
+
+	policy, _ = store.GetDomainPolicy(c, "blog.golang.org")
+	if policy == nil || policy.Boost != 1.5 || policy.Blocked {
+		t.Errorf("Policy did not round trip, got %+v", policy)
+	}
+	policies, _ := store.GetDomainPolicies(c)
+	if len(policies) != 2 || policies[0].Name != "blog.golang.org" || !policies[1].Blocked {
+		t.Errorf("Expected 2 policies ordered by name, got %+v", policies)
+	}
+
+	store.DeleteDomainPolicy(c, "example.com")
+	if err := store.DeleteDomainPolicy(c, "example.com"); err != nil {
+		t.Errorf("Deleting a missing policy should not fail, got %v", err)
+	}
+	if policies, _ = store.GetDomainPolicies(c); len(policies) != 1 {
+		t.Errorf("Expected 1 policy after delete, got %v", len(policies))
+	}
+}
+
+//testStoreHarvests exercises the HarvestStore half of a Store.  store must be
+// empty.
+func testStoreHarvests(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	checkpoint, err := store.GetHarvestCheckpoint(c, "golang")
+	if err != nil || checkpoint != nil {
+		t.Fatalf("Expected no checkpoint in an empty store, got %v, %v", checkpoint, err)
+	}
+
+	store.PutHarvestCheckpoint(c, &HarvestCheckpoint{Query: "golang", MaxID: 1, Updated: now})
+	store.PutHarvestCheckpoint(c, &HarvestCheckpoint{Query: "golang", MaxID: 665756769528999936,
+		ResumeID: 665719999999999999, NewestID: 665800000000000000, Updated: now})
+	store.PutHarvestCheckpoint(c, &HarvestCheckpoint{Query: "rust", MaxID: 2, Updated: now})
+
+	checkpoint, _ = store.GetHarvestCheckpoint(c, "golang")
+	if checkpoint == nil || checkpoint.MaxID != 665756769528999936 || checkpoint.ResumeID != 665719999999999999 ||
+		checkpoint.NewestID != 665800000000000000 || !checkpoint.Updated.Equal(now) {
+		t.Errorf("Checkpoint did not round trip, got %+v", checkpoint)
+	}
+
+	limit, err := store.GetRateLimit(c, searchEndpoint)
+	if err != nil || limit != nil {
+		t.Fatalf("Expected no rate limit in an empty store, got %v, %v", limit, err)
+	}
+	store.PutRateLimit(c, &RateLimit{Endpoint: "users/show", Limit: 900, Remaining: 900, Updated: now})
+	store.PutRateLimit(c, &RateLimit{Endpoint: searchEndpoint, Limit: 180, Remaining: 10,
+		Reset: now.Add(time.Minute), Updated: now})
+	store.PutRateLimit(c, &RateLimit{Endpoint: searchEndpoint, Limit: 180, Remaining: 0,
+		Reset: now.Add(time.Minute), NextAllowed: now.Add(2 * time.Minute), Backoffs: 2, Updated: now})
+
+	limit, _ = store.GetRateLimit(c, searchEndpoint)
+	if limit == nil || limit.Remaining != 0 || limit.Backoffs != 2 ||
+		!limit.Reset.Equal(now.Add(time.Minute)) || !limit.NextAllowed.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("Rate limit did not round trip, got %+v", limit)
+	}
+	limits, _ := store.GetRateLimits(c)
+	if len(limits) != 2 || limits[0].Endpoint != searchEndpoint || limits[1].Endpoint != "users/show" {
+		t.Errorf("Expected the rate limits ordered by endpoint, got %+v", limits)
+	}
+}
+
+//testStoreJobs exercises the JobRun half of a Store.  store must be empty.
+func testStoreJobs(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	if run, err := store.GetJobRun(c, 1); err != nil || run != nil {
+		t.Fatalf("Expected no job run in an empty store, got %v, %v", run, err)
+	}
+
+	first := &JobRun{Status: jobRunning, Started: now.Add(-time.Hour)}
+	second := &JobRun{Status: jobSucceeded, Started: now}
+	store.PutJobRun(c, first)
+	store.PutJobRun(c, second)
+	if first.ID == 0 || second.ID == 0 || first.ID == second.ID {
+		t.Fatalf("Expected distinct IDs for new runs, got %v and %v", first.ID, second.ID)
+	}
+
+	first.Status = jobFailed
+	first.Harvests = []TopicHarvest{{Query: "golang", Status: harvestFailed, Error: "search unavailable"}}
+	first.Map.Errors = []string{"golang: search unavailable"}
+	store.PutJobRun(c, first)
+
+	run, _ := store.GetJobRun(c, first.ID)
+	if run == nil || run.ID != first.ID || run.Status != jobFailed || !run.Started.Equal(first.Started) ||
+		len(run.Harvests) != 1 || run.Harvests[0].Error != "search unavailable" || len(run.Map.Errors) != 1 {
+		t.Errorf("Job run did not round trip, got %+v", run)
+	}
+
+	runs, _ := store.GetJobRuns(c, "", 10)
+	if len(runs) != 2 || runs[0].ID != second.ID || runs[1].ID != first.ID {
+		t.Errorf("Expected the runs newest first, got %+v", runs)
+	}
+	runs, _ = store.GetJobRuns(c, jobFailed, 10)
+	if len(runs) != 1 || runs[0].ID != first.ID {
+		t.Errorf("Expected only the failed run, got %+v", runs)
+	}
+	if runs, _ = store.GetJobRuns(c, "", 1); len(runs) != 1 {
+		t.Errorf("Expected the limit to be applied, got %v runs", len(runs))
+	}
+
+	err := store.UpdateJobRun(c, first.ID, func(run *JobRun, exists bool) error {
+		if !exists || run.Status != jobFailed || len(run.Harvests) != 1 {
+			t.Errorf("Expected the stored run, got %+v, %v", run, exists)
+		}
+		run.Rerun = true
+		return nil
+	})
+	if run, _ = store.GetJobRun(c, first.ID); err != nil || run == nil || !run.Rerun || run.Status != jobFailed {
+		t.Errorf("Expected the run to be updated, got %+v, %v", run, err)
+	}
+	err = store.UpdateJobRun(c, second.ID, func(run *JobRun, exists bool) error {
+		run.Status = jobFailed
+		return errors.New("abort")
+	})
+	if run, _ = store.GetJobRun(c, second.ID); err == nil || run.Status != jobSucceeded {
+		t.Errorf("Expected a failed update not to be saved, got %+v, %v", run, err)
+	}
+	store.UpdateJobRun(c, 999, func(run *JobRun, exists bool) error {
+		if exists {
+			t.Errorf("Expected no run 999, got %+v", run)
+		}
+		return nil
+	})
+	if run, _ = store.GetJobRun(c, 999); run != nil {
+		t.Errorf("Expected no run to be created for a missing ID, got %+v", run)
+	}
+}
+
+//overlappingStore is a Store that runs overlap, once, after the first batch of
+// tweets is read, as a second reduce of the same tweets would.
+type overlappingStore struct {
+	Store
+	overlap func()
+}
+
+func (store *overlappingStore) GetTweetsAfter(c context.Context, query string, sequence int64,
+	limit int) (LinkTweets, error) {
+
+	tweets, err := store.Store.GetTweetsAfter(c, query, sequence, limit)
+	if overlap := store.overlap; overlap != nil {
+		store.overlap = nil
+		overlap()
+	}
+	return tweets, err
+}
+
+//testStoreOverlappingReduces runs a reduce while another has read the same batch
+// but not yet written it.  store must be empty.
+func testStoreOverlappingReduces(t *testing.T, store Store) {
+	c := context.Background()
+	now := time.Now().Truncate(time.Second)
+	address := "http://127.0.0.1:1/a"
+
+	another := testLinkTweet(2, address, "golang", now)
+	another.User.Id = 42
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(1, address, "golang", now), another})
+
+	overlapping := &overlappingStore{Store: store}
+	first := Reducer{c: c, store: overlapping, scorer: DefaultScorer, cache: NewFeedCache()}
+	second := Reducer{c: c, store: store, scorer: DefaultScorer, cache: NewFeedCache()}
+	var overlapped ReduceSummary
+	overlapping.overlap = func() {
+		var err error
+		if overlapped, err = second.reduce(); err != nil {
+			t.Errorf("Overlapping reduce failed: %v", err)
+		}
+	}
+
+	summary, err := first.reduce()
+	if err != nil || summary != (ReduceSummary{}) || overlapped.Tweets != 2 {
+		t.Errorf("Expected only the overlapping reduce to count the tweets, got %+v and %+v, %v",
+			summary, overlapped, err)
+	}
+
+	canonical := DefaultCanonicalizer.Canonical(address)
+	scores, _ := store.AddressScores(c, canonical)
+	if len(scores) != 1 || scores[0].Score != 4 {
+		t.Errorf("Expected the tweets scored once, got %+v", scores)
+	}
+	domains, _ := store.TopDomains(c, "golang", 10)
+	if len(domains) != 1 || domains[0].Score != 4 || domains[0].Links != 1 {
+		t.Errorf("Expected the domain to count the link once, got %+v", domains)
+	}
+	if checkpoint, _ := store.GetReduceCheckpoint(c, "golang"); checkpoint == nil || checkpoint.Sequence == 0 {
+		t.Errorf("Expected the checkpoint to be past the tweets, got %+v", checkpoint)
+	}
+}
@@ -0,0 +1,845 @@
+package tweetharvest
+
+import (
+	"context"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"google.golang.org/appengine/datastore"
+)
+
+//Constants are used to standardize strings used for data access from the Datastore.
+const tweetKey string = "Tweets"
+const tweetKeyID string = "default_tweetstore"
+const tweetScoreKind string = "TweetScore"
+const scoreKey string = "Scores"
+const scoreKeyID string = "default_scorestore"
+const topicKey string = "Topics"
+const topicKeyID string = "default_topicstore"
+const harvestKey string = "Harvests"
+const harvestKeyID string = "default_harveststore"
+const domainKey string = "Domains"
+const domainKeyID string = "default_domainstore"
+const jobKey string = "Jobs"
+const jobKeyID string = "default_jobstore"
+
+//maxBatchSize is the largest number of entities the datastore accepts in a single
+// PutMulti call.
+const maxBatchSize = 500
+
+//tweetSequenceKind is the kind of the entity that holds the Sequence of the last
+// LinkTweet written.
+const tweetSequenceKind string = "TweetSequence"
+
+//tweetSequence is the counter PutLinkTweets numbers LinkTweets from.  Legacy is
+// set once the LinkTweets written before tweets were numbered have been numbered.
+type tweetSequence struct {
+	Last   int64
+	Legacy bool
+}
+
+//DatastoreStore is a Store backed by the App Engine datastore.  All LinkTweets
+// share one ancestor and all TweetScores share another so that queries on them
+// are strongly consistent.
+type DatastoreStore struct{}
+
+//PutLinkTweets writes the tweets to the datastore in batches, each batch inside
+// a transaction.  Each transaction also advances the tweetSequence the batch is
+// numbered from; as it shares the LinkTweets' entity group, the tweets become
+// visible in Sequence order.
+func (DatastoreStore) PutLinkTweets(c context.Context, tweets LinkTweets) error {
+	if err := numberLegacyTweets(c); err != nil {
+		log.Errorf(c, "Failed to number LinkTweets written before tweets were numbered. %v", err.Error())
+		return err
+	}
+
+	keys := make([]*datastore.Key, len(tweets))
+	for i := range tweets {
+		keys[i] = datastore.NewIncompleteKey(c, linkTweetKind, getTweetKey(c))
+	}
+	if err := putNumberedTweets(c, keys, tweets); err != nil {
+		log.Errorf(c, "Failed to write LinkTweets to datastore. %v", err.Error())
+		return err
+	}
+	return nil
+}
+
+//putNumberedTweets gives each tweet the next Sequence and writes it under its key,
+// in batches that each advance the tweetSequence in the same transaction.
+func putNumberedTweets(c context.Context, keys []*datastore.Key, tweets LinkTweets) error {
+	//The counter is written with each batch, so it takes one of the batch's places.
+	batchSize := maxBatchSize - 1
+	for start := 0; start < len(tweets); start += batchSize {
+		end := start + batchSize
+		if end > len(tweets) {
+			end = len(tweets)
+		}
+		batch := tweets[start:end]
+
+		err := datastore.RunInTransaction(c, func(c context.Context) error {
+			counterKey := getTweetSequenceKey(c)
+			counter := &tweetSequence{}
+			if err := datastore.Get(c, counterKey, counter); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+
+			for i := range batch {
+				counter.Last++
+				batch[i].Sequence = counter.Last
+			}
+			if _, err := datastore.PutMulti(c, keys[start:end], batch); err != nil {
+				return err
+			}
+			_, err := datastore.Put(c, counterKey, counter)
+			return err
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//GetTweetsAfter gets up to limit of the query's tweets numbered after sequence.
+// Reading a query from the start first numbers the tweets written before tweets
+// were numbered, if no tweet has been written since, as they have no Sequence
+// for the filter to find.
+func (DatastoreStore) GetTweetsAfter(c context.Context, query string, sequence int64, limit int) (LinkTweets, error) {
+	if sequence == 0 {
+		if err := numberLegacyTweets(c); err != nil {
+			return nil, err
+		}
+	}
+
+	q := datastore.NewQuery(linkTweetKind).
+		Ancestor(getTweetKey(c)).
+		Filter("Query =", query).
+		Filter("Sequence >", sequence).
+		Order("Sequence").
+		Limit(limit)
+
+	var out LinkTweets
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//numberLegacyTweets gives each LinkTweet written before tweets were numbered a
+// Sequence, oldest first, then marks the tweetSequence so that it only does so
+// once.  It runs before PutLinkTweets numbers any new tweet, so that the old
+// tweets come first.  The tweets are read a page at a time outside of any
+// transaction, and each page is numbered in a transaction of its own.
+func numberLegacyTweets(c context.Context) error {
+	counter := &tweetSequence{}
+	err := datastore.Get(c, getTweetSequenceKey(c), counter)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	if counter.Legacy {
+		return nil
+	}
+
+	//The counter is written with each page, so it takes one of the page's places.
+	pageSize := maxBatchSize - 1
+	q := datastore.NewQuery(linkTweetKind).
+		Ancestor(getTweetKey(c)).
+		Order("CreatedTime").
+		Limit(pageSize)
+
+	numbered := 0
+	for {
+		var legacy []*datastore.Key
+		read := 0
+		it := q.Run(c)
+		for {
+			tweet := &LinkTweet{}
+			key, err := it.Next(tweet)
+			if err == datastore.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			read++
+			if tweet.Sequence == 0 {
+				legacy = append(legacy, key)
+			}
+		}
+
+		count, err := numberLegacyPage(c, legacy)
+		if err != nil {
+			return err
+		}
+		numbered += count
+		if read < pageSize {
+			break
+		}
+		cursor, err := it.Cursor()
+		if err != nil {
+			return err
+		}
+		q = q.Start(cursor)
+	}
+	log.Infof(c, "Numbered %v LinkTweets written before tweets were numbered.", numbered)
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		counter := &tweetSequence{}
+		err := datastore.Get(c, getTweetSequenceKey(c), counter)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		counter.Legacy = true
+		_, err = datastore.Put(c, getTweetSequenceKey(c), counter)
+		return err
+	}, nil)
+}
+
+//numberLegacyPage gives the tweets under keys that still have no Sequence one
+// each, in a transaction that also advances the tweetSequence, and returns how
+// many it numbered.  Checking the tweets again inside the transaction stops two
+// runs numbering the same tweet twice.
+func numberLegacyPage(c context.Context, keys []*datastore.Key) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	numbered := 0
+	err := datastore.RunInTransaction(c, func(c context.Context) error {
+		tweets := make(LinkTweets, len(keys))
+		for i := range tweets {
+			tweets[i] = &LinkTweet{}
+		}
+		if err := datastore.GetMulti(c, keys, tweets); err != nil {
+			return err
+		}
+		counterKey := getTweetSequenceKey(c)
+		counter := &tweetSequence{}
+		if err := datastore.Get(c, counterKey, counter); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		var legacyKeys []*datastore.Key
+		var legacy LinkTweets
+		for i, tweet := range tweets {
+			if tweet.Sequence != 0 {
+				continue
+			}
+			counter.Last++
+			tweet.Sequence = counter.Last
+			legacyKeys = append(legacyKeys, keys[i])
+			legacy = append(legacy, tweet)
+		}
+		numbered = len(legacy)
+		if numbered == 0 {
+			return nil
+		}
+		if _, err := datastore.PutMulti(c, legacyKeys, legacy); err != nil {
+			return err
+		}
+		_, err := datastore.Put(c, counterKey, counter)
+		return err
+	}, nil)
+	return numbered, err
+}
+
+//TweetQueries gets the distinct queries of the tweets with a projection query.
+func (DatastoreStore) TweetQueries(c context.Context) ([]string, error) {
+	q := datastore.NewQuery(linkTweetKind).
+		Ancestor(getTweetKey(c)).
+		Project("Query").
+		Distinct().
+		Order("Query")
+
+	var tweets LinkTweets
+	if _, err := q.GetAll(c, &tweets); err != nil {
+		return nil, err
+	}
+	out := make([]string, len(tweets))
+	for i, tweet := range tweets {
+		out[i] = tweet.Query
+	}
+	return out, nil
+}
+
+//GetAllNewTweets queries the datastore and gets all tweets created since the last
+// time given
+func (DatastoreStore) GetAllNewTweets(c context.Context, since time.Time) (LinkTweets, error) {
+	log.Infof(c, "Getting all tweets newer than: %v", since)
+	q := datastore.NewQuery(linkTweetKind).Ancestor(getTweetKey(c)).Filter("CreatedTime >", since)
+	out := make(LinkTweets, 0, 15)
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//NewestTweet returns the CreatedTime of the newest LinkTweet in the datastore.
+func (DatastoreStore) NewestTweet(c context.Context) (time.Time, error) {
+	var latest struct {
+		CreatedTime time.Time
+	}
+
+	//Get just the creation time for the newest tweet
+	q := datastore.NewQuery(linkTweetKind).Order("-CreatedTime").Project("CreatedTime").Limit(1)
+	_, err := q.Run(c).Next(&latest)
+	if err == datastore.Done {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return latest.CreatedTime, nil
+}
+
+//LinkTweet returns a LinkTweet from the DataStore that has the given TweetID
+func (DatastoreStore) LinkTweet(c context.Context, tweetID int64) (*LinkTweet, error) {
+	q := datastore.NewQuery(linkTweetKind).
+		Filter("TweetID =", tweetID).
+		Limit(1)
+
+	linkTweet := &LinkTweet{}
+	_, err := q.Run(c).Next(linkTweet)
+	if err == datastore.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return linkTweet, nil
+}
+
+//QueryLinkTweet returns the LinkTweet stored under the query that has the given
+// TweetID.
+func (DatastoreStore) QueryLinkTweet(c context.Context, query string, tweetID int64) (*LinkTweet, error) {
+	q := datastore.NewQuery(linkTweetKind).
+		Ancestor(getTweetKey(c)).
+		Filter("Query =", query).
+		Filter("TweetID =", tweetID).
+		Limit(1)
+
+	linkTweet := &LinkTweet{}
+	_, err := q.Run(c).Next(linkTweet)
+	if err == datastore.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return linkTweet, nil
+}
+
+//GetHarvestCheckpoint gets the checkpoint for the query.
+func (DatastoreStore) GetHarvestCheckpoint(c context.Context, query string) (*HarvestCheckpoint, error) {
+	checkpoint := &HarvestCheckpoint{}
+	err := datastore.Get(c, getHarvestCheckpointKey(c, query), checkpoint)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+//PutHarvestCheckpoint writes the checkpoint under a key named after its query.
+func (DatastoreStore) PutHarvestCheckpoint(c context.Context, checkpoint *HarvestCheckpoint) error {
+	_, err := datastore.Put(c, getHarvestCheckpointKey(c, checkpoint.Query), checkpoint)
+	return err
+}
+
+//GetRateLimit gets the rate limit of the endpoint.
+func (DatastoreStore) GetRateLimit(c context.Context, endpoint string) (*RateLimit, error) {
+	limit := &RateLimit{}
+	err := datastore.Get(c, getRateLimitKey(c, endpoint), limit)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+//GetRateLimits gets the rate limits of every endpoint.
+func (DatastoreStore) GetRateLimits(c context.Context) ([]*RateLimit, error) {
+	q := datastore.NewQuery(rateLimitKind).Ancestor(getHarvestKey(c)).Order("Endpoint")
+
+	var out []*RateLimit
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//PutRateLimit writes the rate limit under a key named after its endpoint.
+func (DatastoreStore) PutRateLimit(c context.Context, limit *RateLimit) error {
+	_, err := datastore.Put(c, getRateLimitKey(c, limit.Endpoint), limit)
+	return err
+}
+
+//UpdateScore finds the query's TweetScore for the address inside a transaction,
+// lets update modify it and writes it back.
+func (DatastoreStore) UpdateScore(c context.Context, query string, address string,
+	update func(score *TweetScore, exists bool) error) error {
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		key, score, err := updateScoreEntity(c, query, address, update)
+		if err != nil {
+			return err
+		}
+		_, err = datastore.Put(c, key, score)
+		return err
+	}, nil)
+}
+
+//UpdateScores checks the stored checkpoint is still at from, then updates the
+// checkpoint query's score for each address and writes them with the checkpoint
+// in one transaction.  The checkpoint shares the scores' ancestor so that the
+// transaction spans a single entity group.
+func (DatastoreStore) UpdateScores(c context.Context, addresses []string, from int64, checkpoint *ReduceCheckpoint,
+	update func(score *TweetScore, exists bool) error) error {
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		stored := &ReduceCheckpoint{}
+		err := datastore.Get(c, getReduceCheckpointKey(c, checkpoint.Query), stored)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if stored.Sequence != from {
+			return errCheckpointMoved
+		}
+
+		keys := make([]*datastore.Key, len(addresses))
+		scores := make([]*TweetScore, len(addresses))
+		for i, address := range addresses {
+			var err error
+			keys[i], scores[i], err = updateScoreEntity(c, checkpoint.Query, address, update)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := datastore.PutMulti(c, keys, scores); err != nil {
+			return err
+		}
+		_, err = datastore.Put(c, getReduceCheckpointKey(c, checkpoint.Query), checkpoint)
+		return err
+	}, nil)
+}
+
+//updateScoreEntity loads the query's score for the address and hands it to
+// update, returning the key to write the result under.  It must run in a
+// transaction.
+func updateScoreEntity(c context.Context, query string, address string,
+	update func(score *TweetScore, exists bool) error) (*datastore.Key, *TweetScore, error) {
+
+	q := datastore.NewQuery(tweetScoreKind).
+		Ancestor(getTweetScoreKey(c)).
+		Filter("Query =", query).
+		Filter("Address =", address).
+		Limit(1)
+
+	score := &TweetScore{}
+	key, err := q.Run(c).Next(score)
+	exists := true
+	if err == datastore.Done {
+		exists = false
+		score = &TweetScore{Query: query, Address: address}
+		key = datastore.NewIncompleteKey(c, tweetScoreKind, getTweetScoreKey(c))
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if err := update(score, exists); err != nil {
+		return nil, nil, err
+	}
+	score.Query = query
+	score.Address = address
+	score.Updated = time.Now()
+	return key, score, nil
+}
+
+//AddressScores gets the address's scores under every query, ordered by query.
+func (DatastoreStore) AddressScores(c context.Context, address string) ([]*TweetScore, error) {
+	q := datastore.NewQuery(tweetScoreKind).
+		Ancestor(getTweetScoreKey(c)).
+		Filter("Address =", address).
+		Order("Query")
+
+	var out []*TweetScore
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//GetReduceCheckpoint gets the reduce checkpoint for the query.
+func (DatastoreStore) GetReduceCheckpoint(c context.Context, query string) (*ReduceCheckpoint, error) {
+	checkpoint := &ReduceCheckpoint{}
+	err := datastore.Get(c, getReduceCheckpointKey(c, query), checkpoint)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+//RecentScores returns the scores for the query that have been active since the
+// given time.
+func (DatastoreStore) RecentScores(c context.Context, query string, since time.Time) ([]*TweetScore, error) {
+	q := datastore.NewQuery(tweetScoreKind).
+		Ancestor(getTweetScoreKey(c)).
+		Filter("Query =", query).
+		Filter("LastActive >=", since).
+		Order("-LastActive")
+
+	var out []*TweetScore
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//LastScoreActivity gets the last date that any score was active.
+func (DatastoreStore) LastScoreActivity(c context.Context) (time.Time, error) {
+	//Get a single value from the datastore with the newest date
+	q := datastore.NewQuery(tweetScoreKind).
+		Project("LastActive").
+		Order("-LastActive").Limit(1)
+
+	score := &TweetScore{}
+	_, err := q.Run(c).Next(score)
+	if err == datastore.Done {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return score.LastActive, nil
+}
+
+//LastQueryActivity gets the last date that any of the query's scores was active.
+func (DatastoreStore) LastQueryActivity(c context.Context, query string) (time.Time, error) {
+	q := datastore.NewQuery(tweetScoreKind).
+		Ancestor(getTweetScoreKey(c)).
+		Filter("Query =", query).
+		Project("LastActive").
+		Order("-LastActive").Limit(1)
+
+	score := &TweetScore{}
+	_, err := q.Run(c).Next(score)
+	if err == datastore.Done {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return score.LastActive, nil
+}
+
+//LastQueryUpdate gets the last time that any of the query's scores was written.
+func (DatastoreStore) LastQueryUpdate(c context.Context, query string) (time.Time, error) {
+	q := datastore.NewQuery(tweetScoreKind).
+		Ancestor(getTweetScoreKey(c)).
+		Filter("Query =", query).
+		Project("Updated").
+		Order("-Updated").Limit(1)
+
+	score := &TweetScore{}
+	_, err := q.Run(c).Next(score)
+	if err == datastore.Done {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return score.Updated, nil
+}
+
+//PutTopic writes the topic under a key named after its query.
+func (DatastoreStore) PutTopic(c context.Context, topic *Topic) error {
+	_, err := datastore.Put(c, getTopicEntityKey(c, topic.Query), topic)
+	return err
+}
+
+//UpdateTopic gets the topic for the query inside a transaction, lets update
+// modify it and writes it back.
+func (DatastoreStore) UpdateTopic(c context.Context, query string,
+	update func(topic *Topic, exists bool) error) error {
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		key := getTopicEntityKey(c, query)
+		topic := &Topic{}
+		err := datastore.Get(c, key, topic)
+		exists := err == nil
+		if err == datastore.ErrNoSuchEntity {
+			topic = &Topic{Query: query}
+		} else if err != nil {
+			return err
+		}
+
+		if err := update(topic, exists); err != nil {
+			return err
+		}
+		topic.Query = query
+		_, err = datastore.Put(c, key, topic)
+		return err
+	}, nil)
+}
+
+//GetTopic gets the topic for the query.
+func (DatastoreStore) GetTopic(c context.Context, query string) (*Topic, error) {
+	topic := &Topic{}
+	err := datastore.Get(c, getTopicEntityKey(c, query), topic)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+//GetTopics gets all of the topics.
+func (DatastoreStore) GetTopics(c context.Context) ([]*Topic, error) {
+	q := datastore.NewQuery(topicKind).Ancestor(getTopicKey(c)).Order("Query")
+
+	var out []*Topic
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//DeleteTopic deletes the topic for the query.
+func (DatastoreStore) DeleteTopic(c context.Context, query string) error {
+	err := datastore.Delete(c, getTopicEntityKey(c, query))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+//UpdateDomain gets the domain inside a transaction, lets update modify it and
+// writes it back.
+func (DatastoreStore) UpdateDomain(c context.Context, query string, name string,
+	update func(domain *Domain, exists bool) error) error {
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		key := getDomainEntityKey(c, query, name)
+		domain := &Domain{}
+		err := datastore.Get(c, key, domain)
+		exists := err == nil
+		if err == datastore.ErrNoSuchEntity {
+			domain = &Domain{Name: name, Query: query}
+		} else if err != nil {
+			return err
+		}
+
+		if err := update(domain, exists); err != nil {
+			return err
+		}
+		domain.Name, domain.Query = name, query
+		_, err = datastore.Put(c, key, domain)
+		return err
+	}, nil)
+}
+
+//TopDomains gets the query's highest scoring domains.
+func (DatastoreStore) TopDomains(c context.Context, query string, limit int) ([]*Domain, error) {
+	q := datastore.NewQuery(domainKind).
+		Ancestor(getDomainKey(c)).
+		Filter("Query =", query).
+		Order("-Score").
+		Limit(limit)
+
+	var out []*Domain
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//PutDomainPolicy writes the policy under a key named after its domain.
+func (DatastoreStore) PutDomainPolicy(c context.Context, policy *DomainPolicy) error {
+	_, err := datastore.Put(c, getDomainPolicyKey(c, policy.Name), policy)
+	return err
+}
+
+//GetDomainPolicy gets the policy for the domain.
+func (DatastoreStore) GetDomainPolicy(c context.Context, name string) (*DomainPolicy, error) {
+	policy := &DomainPolicy{}
+	err := datastore.Get(c, getDomainPolicyKey(c, name), policy)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+//GetDomainPolicies gets all of the policies.
+func (DatastoreStore) GetDomainPolicies(c context.Context) (DomainPolicies, error) {
+	q := datastore.NewQuery(domainPolicyKind).Ancestor(getDomainKey(c)).Order("Name")
+
+	var out DomainPolicies
+	if _, err := q.GetAll(c, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+//DeleteDomainPolicy deletes the policy for the domain.
+func (DatastoreStore) DeleteDomainPolicy(c context.Context, name string) error {
+	err := datastore.Delete(c, getDomainPolicyKey(c, name))
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+//PutJobRun writes the run, letting the datastore choose the ID of a new one.
+func (DatastoreStore) PutJobRun(c context.Context, run *JobRun) error {
+	key := datastore.NewIncompleteKey(c, jobRunKind, getJobKey(c))
+	if run.ID != 0 {
+		key = datastore.NewKey(c, jobRunKind, "", run.ID, getJobKey(c))
+	}
+	key, err := datastore.Put(c, key, run)
+	if err != nil {
+		return err
+	}
+	run.ID = key.IntID()
+	return nil
+}
+
+//GetJobRun gets the run with the ID.
+func (DatastoreStore) GetJobRun(c context.Context, id int64) (*JobRun, error) {
+	run := &JobRun{}
+	err := datastore.Get(c, datastore.NewKey(c, jobRunKind, "", id, getJobKey(c)), run)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.ID = id
+	return run, nil
+}
+
+//UpdateJobRun gets the run with the ID inside a transaction, lets update modify it
+// and writes it back.
+func (DatastoreStore) UpdateJobRun(c context.Context, id int64,
+	update func(run *JobRun, exists bool) error) error {
+
+	return datastore.RunInTransaction(c, func(c context.Context) error {
+		key := datastore.NewKey(c, jobRunKind, "", id, getJobKey(c))
+		run := &JobRun{}
+		err := datastore.Get(c, key, run)
+		exists := err == nil
+		if err == datastore.ErrNoSuchEntity {
+			run = &JobRun{}
+		} else if err != nil {
+			return err
+		}
+		run.ID = id
+
+		if err := update(run, exists); err != nil || !exists {
+			return err
+		}
+		run.ID = id
+		_, err = datastore.Put(c, key, run)
+		return err
+	}, nil)
+}
+
+//GetJobRuns gets up to limit runs with the status, newest first.
+func (DatastoreStore) GetJobRuns(c context.Context, status string, limit int) ([]*JobRun, error) {
+	q := datastore.NewQuery(jobRunKind).Ancestor(getJobKey(c))
+	if status != "" {
+		q = q.Filter("Status =", status)
+	}
+	q = q.Order("-Started").Limit(limit)
+
+	var out []*JobRun
+	keys, err := q.GetAll(c, &out)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		out[i].ID = key.IntID()
+	}
+	return out, nil
+}
+
+//getTweetKey returns the key used as the ancestor of all LinkTweet entities.
+func getTweetKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, tweetKey, tweetKeyID, 0, nil)
+}
+
+//getTweetSequenceKey returns the key of the tweetSequence.
+func getTweetSequenceKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, tweetSequenceKind, tweetKeyID, 0, getTweetKey(c))
+}
+
+//getTweetScoreKey returns the same key every time so that all TweetScore entites have
+// a common Ancestor
+func getTweetScoreKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, scoreKey, scoreKeyID, 0, nil)
+}
+
+//getReduceCheckpointKey returns the key of the ReduceCheckpoint for a query, under
+// the TweetScores' ancestor.
+func getReduceCheckpointKey(c context.Context, query string) *datastore.Key {
+	return datastore.NewKey(c, reduceCheckpointKind, query, 0, getTweetScoreKey(c))
+}
+
+//getTopicKey returns the key used as the ancestor of all Topic entities.
+func getTopicKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, topicKey, topicKeyID, 0, nil)
+}
+
+//getTopicEntityKey returns the key of the Topic for a query.
+func getTopicEntityKey(c context.Context, query string) *datastore.Key {
+	return datastore.NewKey(c, topicKind, query, 0, getTopicKey(c))
+}
+
+//getDomainKey returns the key used as the ancestor of all Domain and
+// DomainPolicy entities.
+func getDomainKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, domainKey, domainKeyID, 0, nil)
+}
+
+//getDomainEntityKey returns the key of the Domain of a query.  Domain names can't
+// contain a |, so the last one in the key separates the query from the name.
+func getDomainEntityKey(c context.Context, query string, name string) *datastore.Key {
+	return datastore.NewKey(c, domainKind, query+"|"+name, 0, getDomainKey(c))
+}
+
+//getDomainPolicyKey returns the key of the DomainPolicy for a domain.
+func getDomainPolicyKey(c context.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, domainPolicyKind, name, 0, getDomainKey(c))
+}
+
+//getHarvestKey returns the key used as the ancestor of HarvestCheckpoint and
+// RateLimit entities.
+func getHarvestKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, harvestKey, harvestKeyID, 0, nil)
+}
+
+//getHarvestCheckpointKey returns the key of the HarvestCheckpoint for a query.
+func getHarvestCheckpointKey(c context.Context, query string) *datastore.Key {
+	return datastore.NewKey(c, harvestCheckpointKind, query, 0, getHarvestKey(c))
+}
+
+//getRateLimitKey returns the key of the RateLimit of an endpoint.
+func getRateLimitKey(c context.Context, endpoint string) *datastore.Key {
+	return datastore.NewKey(c, rateLimitKind, endpoint, 0, getHarvestKey(c))
+}
+
+//getJobKey returns the key used as the ancestor of all JobRun entities.
+func getJobKey(c context.Context) *datastore.Key {
+	return datastore.NewKey(c, jobKey, jobKeyID, 0, nil)
+}
@@ -0,0 +1,157 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"github.com/gorilla/mux"
+)
+
+//DomainHandler serves the /domains endpoints used to see which domains are shared
+// and to manage their DomainPolicies:
+//
+//	GET    /domains?q=golang  lists the query's top domains, with their policies
+//	GET    /domains           lists all domain policies
+//	GET    /domains/{domain}  returns the policy for a domain
+//	PUT    /domains/{domain}  sets the policy for a domain
+//	DELETE /domains/{domain}  removes the policy for a domain
+//
+//The number of top domains is set by the limit parameter, defaultDomainLimit if
+// it is left out.
+type DomainHandler struct {
+	c          context.Context
+	store      Store
+	newContext ContextFunc
+}
+
+//defaultDomainLimit and maxDomainLimit bound the number of top domains listed.
+const (
+	defaultDomainLimit = 25
+	maxDomainLimit     = 500
+)
+
+//domainReport is a Domain listed with the policy that applies to it.
+type domainReport struct {
+	*Domain
+	Blocked bool    `json:"blocked"`
+	Boost   float64 `json:"boost"`
+}
+
+//ServeHTTP dispatches on the method and whether a domain is in the path.
+func (dh DomainHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	dh.c = dh.newContext(request)
+	name, one := mux.Vars(request)["domain"]
+	name = strings.TrimPrefix(strings.ToLower(name), "www.")
+
+	switch {
+	case !one && request.Method == "GET" && request.URL.Query().Get(queryParam) != "":
+		dh.top(writer, request)
+	case !one && request.Method == "GET":
+		dh.policies(writer)
+	case one && request.Method == "GET":
+		dh.get(writer, name)
+	case one && request.Method == "PUT":
+		dh.put(writer, request, name)
+	case one && request.Method == "DELETE":
+		dh.delete(writer, name)
+	default:
+		http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (dh DomainHandler) top(writer http.ResponseWriter, request *http.Request) {
+	limit := defaultDomainLimit
+	if raw := request.URL.Query().Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxDomainLimit {
+			http.Error(writer, "limit must be a number from 1 to 500.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	domains, err := dh.store.TopDomains(dh.c, request.URL.Query().Get(queryParam), limit)
+	if err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+	policies, err := dh.store.GetDomainPolicies(dh.c)
+	if err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+
+	reports := []domainReport{}
+	for _, domain := range domains {
+		policy := policies.forDomain(domain.Name)
+		reports = append(reports, domainReport{
+			Domain:  domain,
+			Blocked: policy != nil && policy.Blocked,
+			Boost:   policy.boost(),
+		})
+	}
+	writeJSON(writer, http.StatusOK, reports)
+}
+
+func (dh DomainHandler) policies(writer http.ResponseWriter) {
+	policies, err := dh.store.GetDomainPolicies(dh.c)
+	if err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+	if policies == nil {
+		policies = DomainPolicies{}
+	}
+	writeJSON(writer, http.StatusOK, policies)
+}
+
+func (dh DomainHandler) get(writer http.ResponseWriter, name string) {
+	policy, err := dh.store.GetDomainPolicy(dh.c, name)
+	if err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+	if policy == nil {
+		http.Error(writer, "No policy for domain.", http.StatusNotFound)
+		return
+	}
+	writeJSON(writer, http.StatusOK, policy)
+}
+
+func (dh DomainHandler) put(writer http.ResponseWriter, request *http.Request, name string) {
+	policy := &DomainPolicy{}
+	if err := json.NewDecoder(request.Body).Decode(policy); err != nil {
+		http.Error(writer, "Invalid domain policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.Name = name
+	if err := policy.validate(); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := dh.store.PutDomainPolicy(dh.c, policy); err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+	log.Infof(dh.c, "Set policy for %v: blocked %v, boost %v", name, policy.Blocked, policy.Boost)
+	writeJSON(writer, http.StatusOK, policy)
+}
+
+func (dh DomainHandler) delete(writer http.ResponseWriter, name string) {
+	if err := dh.store.DeleteDomainPolicy(dh.c, name); err != nil {
+		dh.serverError(writer, err)
+		return
+	}
+	log.Infof(dh.c, "Deleted policy for %v", name)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (dh DomainHandler) serverError(writer http.ResponseWriter, err error) {
+	log.Errorf(dh.c, "Domain store error. %v", err.Error())
+	http.Error(writer, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,84 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDomainHandler(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	router := NewRouter(testServices(store, nil))
+
+	send := func(method string, target string, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return response
+	}
+
+	response := send("PUT", "/domains/WWW.Spam.example.com", `{"blocked": true}`)
+	var policy DomainPolicy
+	json.NewDecoder(response.Body).Decode(&policy)
+	if response.Code != http.StatusOK || policy.Name != "spam.example.com" || !policy.Blocked {
+		t.Fatalf("Expected the policy for the bare domain, got %v: %+v", response.Code, policy)
+	}
+	if response = send("PUT", "/domains/golang.org", `{"boost": 20}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a boost above the maximum, got %v", response.Code)
+	}
+	send("PUT", "/domains/golang.org", `{"boost": 2}`)
+
+	var policies DomainPolicies
+	json.NewDecoder(send("GET", "/domains", "").Body).Decode(&policies)
+	if len(policies) != 2 {
+		t.Errorf("Expected 2 policies, got %+v", policies)
+	}
+
+	now := time.Now()
+	for _, address := range []string{"https://blog.golang.org/a", "https://ads.spam.example.com/b"} {
+		score := &TweetScore{Address: address, Query: "golang", Score: 5, FirstSeen: now, LastActive: now}
+		store.UpdateScore(c, "golang", address, func(stored *TweetScore, exists bool) error {
+			*stored = *score
+			return nil
+		})
+		store.UpdateDomain(c, "golang", addressDomain(address), func(domain *Domain, exists bool) error {
+			domain.addScore(score, score.Score, !exists)
+			return nil
+		})
+	}
+
+	var reports []struct {
+		Domain  string  `json:"domain"`
+		Score   int     `json:"score"`
+		Blocked bool    `json:"blocked"`
+		Boost   float64 `json:"boost"`
+	}
+	json.NewDecoder(send("GET", "/domains?q=golang&limit=10", "").Body).Decode(&reports)
+	if len(reports) != 2 || reports[0].Domain != "ads.spam.example.com" || !reports[0].Blocked ||
+		reports[1].Domain != "blog.golang.org" || reports[1].Boost != 2 {
+		t.Errorf("Expected the top domains with their policies, got %+v", reports)
+	}
+	if response = send("GET", "/domains?q=golang&limit=0", ""); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a limit of 0, got %v", response.Code)
+	}
+
+	body := send("GET", "/consume?q=golang", "").Body.String()
+	if strings.Contains(body, "spam.example.com") || !strings.Contains(body, "blog.golang.org") {
+		t.Errorf("Expected the blocked domain to be left out of the feed, got %v", body)
+	}
+
+	if response = send("DELETE", "/domains/spam.example.com", ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting a policy, got %v", response.Code)
+	}
+	if response = send("GET", "/domains/spam.example.com", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted policy, got %v", response.Code)
+	}
+	body = send("GET", "/consume?q=golang", "").Body.String()
+	if !strings.Contains(body, "spam.example.com") {
+		t.Errorf("Expected the unblocked domain back in the feed, got %v", body)
+	}
+}
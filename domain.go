@@ -0,0 +1,128 @@
+package tweetharvest
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Domain aggregates the TweetScores of one query by the domain of their address.
+// Score is the total the reducer has added to the domain's addresses and Links is
+// the number of distinct addresses on it.
+type Domain struct {
+	Name      string    `json:"domain"`
+	Query     string    `json:"query"`
+	Score     int       `json:"score"`
+	Links     int       `json:"links"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+const domainKind string = "Domain"
+
+//DomainPolicy is set by admins to change how a domain and its subdomains are
+// treated for every query.  Addresses on a Blocked domain are neither scored nor
+// shown in feeds, and the scores of the rest are multiplied by Boost when they are
+// reduced.
+type DomainPolicy struct {
+	Name    string  `json:"domain"`
+	Blocked bool    `json:"blocked"`
+	Boost   float64 `json:"boost"`
+}
+
+const domainPolicyKind string = "DomainPolicy"
+
+//maxBoost keeps a single domain from taking over every feed.
+const maxBoost = 10
+
+//validate checks that the policy can be stored.
+func (policy *DomainPolicy) validate() error {
+	if policy.Name == "" || strings.ContainsAny(policy.Name, "/:?# ") {
+		return errors.New("Domain policy needs a domain name, e.g. example.com.")
+	}
+	if policy.Boost < 0 || policy.Boost > maxBoost {
+		return errors.New("Domain boost must be between 0 and 10.")
+	}
+	return nil
+}
+
+//boost returns the multiplier for the scores of the domain's addresses.
+func (policy *DomainPolicy) boost() float64 {
+	if policy == nil || policy.Boost == 0 {
+		return 1
+	}
+	return policy.Boost
+}
+
+//DomainPolicies is the set of policies applied by the reducer and FeedProducer.
+type DomainPolicies []*DomainPolicy
+
+//find returns the policy for the address, or nil if there isn't one.  The policy
+// for the most specific domain wins, so blog.example.com can be boosted while the
+// rest of example.com is blocked.
+func (policies DomainPolicies) find(address string) *DomainPolicy {
+	return policies.forDomain(addressDomain(address))
+}
+
+//forDomain returns the policy for the domain, or nil if there isn't one.
+func (policies DomainPolicies) forDomain(host string) *DomainPolicy {
+	var found *DomainPolicy
+	for _, policy := range policies {
+		if matchDomain(host, []string{policy.Name}) &&
+			(found == nil || len(policy.Name) > len(found.Name)) {
+			found = policy
+		}
+	}
+	return found
+}
+
+//blocked reports whether the address is on a blocked domain.
+func (policies DomainPolicies) blocked(address string) bool {
+	policy := policies.find(address)
+	return policy != nil && policy.Blocked
+}
+
+//boost returns the score multiplied by the boost of the address's domain.
+func (policies DomainPolicies) boost(address string, score int) int {
+	return int(math.Round(float64(score) * policies.find(address).boost()))
+}
+
+//blockedNames lists the blocked domains, so that feeds cached before a domain
+// was blocked or unblocked aren't served.
+func (policies DomainPolicies) blockedNames() string {
+	var names []string
+	for _, policy := range policies {
+		if policy.Blocked {
+			names = append(names, policy.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+//addressDomain returns the host of the address, lower cased and without www.
+func addressDomain(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+//addScore adds a score the reducer wrote for one of the domain's addresses.
+// added is how much the write raised the address's score by, which is less than
+// score.Score when some of its shares had been counted already, and created
+// reports whether the address is new.
+func (domain *Domain) addScore(score *TweetScore, added int, created bool) {
+	domain.Score += added
+	if created {
+		domain.Links++
+	}
+	if domain.FirstSeen.IsZero() || score.FirstSeen.Before(domain.FirstSeen) {
+		domain.FirstSeen = score.FirstSeen
+	}
+	if score.LastActive.After(domain.LastSeen) {
+		domain.LastSeen = score.LastActive
+	}
+}
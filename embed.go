@@ -0,0 +1,192 @@
+package tweetharvest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//EmbedProvider renders the HTML that shows a tweet in the description of a feed
+// item.
+type EmbedProvider interface {
+	Embed(c context.Context, tweet *LinkTweet) (string, error)
+}
+
+//Names of the EmbedProviders a Topic can choose for its feed.
+const (
+	//embedOEmbed uses Twitter's own embed HTML, falling back to embedTemplate.
+	embedOEmbed = "oembed"
+	//embedTemplate renders tweets locally without calling Twitter.
+	embedTemplate = "template"
+	//embedStub renders just the tweet ID, for tests.
+	embedStub = "stub"
+)
+
+//defaultEmbed is the EmbedProvider used by topics that don't choose one.
+const defaultEmbed = embedOEmbed
+
+//validEmbed reports whether name is one of the EmbedProviders.
+func validEmbed(name string) bool {
+	return name == embedOEmbed || name == embedTemplate || name == embedStub
+}
+
+//DefaultEmbedProviders returns the EmbedProviders used unless Services sets
+// others, by name.
+func DefaultEmbedProviders() map[string]EmbedProvider {
+	return map[string]EmbedProvider{
+		embedOEmbed:   NewOEmbedProvider(TemplateEmbedProvider{}),
+		embedTemplate: TemplateEmbedProvider{},
+		embedStub:     StubEmbedProvider{},
+	}
+}
+
+//permalink returns the address of the tweet on twitter.com.
+func permalink(tweet *LinkTweet) string {
+	user := tweet.User.ScreenName
+	if user == "" {
+		user = "i/web"
+	}
+	return "https://twitter.com/" + user + "/status/" + strconv.FormatInt(tweet.Id, 10)
+}
+
+//defaultOEmbedEndpoint is Twitter's oEmbed API.
+const defaultOEmbedEndpoint = "https://publish.twitter.com/oembed"
+
+//maxOEmbedCache is the number of embeds an OEmbedProvider remembers before it
+// starts over.
+const maxOEmbedCache = 10000
+
+//OEmbedProvider is an EmbedProvider that gets the embed HTML for tweets from an
+// oEmbed endpoint, caching it by tweet ID.  Tweets it can't get an embed for are
+// rendered by Fallback, if it is set.
+type OEmbedProvider struct {
+	Endpoint string
+	Fallback EmbedProvider
+
+	mu    sync.Mutex
+	cache map[int64]string
+}
+
+//NewOEmbedProvider returns an OEmbedProvider for Twitter's oEmbed API.
+func NewOEmbedProvider(fallback EmbedProvider) *OEmbedProvider {
+	return &OEmbedProvider{Endpoint: defaultOEmbedEndpoint, Fallback: fallback}
+}
+
+//Embed returns the oEmbed HTML for the tweet.  The widgets script is left out,
+// since feed readers won't run it.
+func (provider *OEmbedProvider) Embed(c context.Context, tweet *LinkTweet) (string, error) {
+	if embed, ok := provider.cached(tweet.Id); ok {
+		return embed, nil
+	}
+
+	embed, err := provider.fetch(c, tweet)
+	if err != nil {
+		if provider.Fallback == nil {
+			return "", err
+		}
+		return provider.Fallback.Embed(c, tweet)
+	}
+	provider.remember(tweet.Id, embed)
+	return embed, nil
+}
+
+//fetch requests the embed HTML for the tweet from the endpoint.
+func (provider *OEmbedProvider) fetch(c context.Context, tweet *LinkTweet) (string, error) {
+	v := url.Values{
+		"url":         {permalink(tweet)},
+		"omit_script": {"true"},
+		"dnt":         {"true"},
+	}
+	request, err := http.NewRequest("GET", provider.Endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := httpClient(c).Do(request.WithContext(c))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New("oEmbed request for " + permalink(tweet) + " returned " + response.Status)
+	}
+
+	var oembed struct {
+		HTML string `json:"html"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&oembed); err != nil {
+		return "", err
+	}
+	if oembed.HTML == "" {
+		return "", errors.New("oEmbed response for " + permalink(tweet) + " has no html")
+	}
+	return oembed.HTML, nil
+}
+
+//cached returns the cached embed for the tweet.
+func (provider *OEmbedProvider) cached(id int64) (string, bool) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	embed, ok := provider.cache[id]
+	return embed, ok
+}
+
+//remember caches the embed for the tweet.
+func (provider *OEmbedProvider) remember(id int64, embed string) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.cache == nil || len(provider.cache) >= maxOEmbedCache {
+		provider.cache = make(map[int64]string)
+	}
+	provider.cache[id] = embed
+}
+
+const embedTemplateHTML = `<blockquote class="tweet">
+<p>{{if .Avatar}}<img src="{{.Avatar}}" alt="" width="48" height="48"> {{end}}<strong>{{.Name}}</strong> <a href="https://twitter.com/{{.Handle}}">@{{.Handle}}</a></p>
+<p>{{.Text}}</p>
+<p><a href="{{.Permalink}}">{{.Time}}</a></p>
+</blockquote>`
+
+var tweetEmbedTemplate = template.Must(template.New("embed").Parse(embedTemplateHTML))
+
+//TemplateEmbedProvider is an EmbedProvider that renders tweets itself, with the
+// author's name, handle and avatar, the text and a permalink with the time of
+// the tweet.
+type TemplateEmbedProvider struct{}
+
+//Embed renders the tweet with embedTemplateHTML.
+func (TemplateEmbedProvider) Embed(c context.Context, tweet *LinkTweet) (string, error) {
+	created, _ := tweet.CreatedAtTime()
+	name := tweet.User.Name
+	if name == "" {
+		name = tweet.User.ScreenName
+	}
+
+	var b bytes.Buffer
+	err := tweetEmbedTemplate.Execute(&b, struct {
+		Avatar, Name, Handle, Text, Permalink, Time string
+	}{
+		Avatar:    tweet.User.ProfileImageUrlHttps,
+		Name:      name,
+		Handle:    tweet.User.ScreenName,
+		Text:      tweet.Text,
+		Permalink: permalink(tweet),
+		Time:      created.UTC().Format(time.RFC1123),
+	})
+	return b.String(), err
+}
+
+//StubEmbedProvider is an EmbedProvider for tests, which renders only the ID of
+// each tweet.
+type StubEmbedProvider struct{}
+
+//Embed returns a paragraph with the tweet's ID.
+func (StubEmbedProvider) Embed(c context.Context, tweet *LinkTweet) (string, error) {
+	return "<p>tweet " + strconv.FormatInt(tweet.Id, 10) + "</p>", nil
+}
@@ -0,0 +1,95 @@
+package tweetharvest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//testEmbedTweet returns a LinkTweet with a user, for rendering embeds.
+func testEmbedTweet(id int64) *LinkTweet {
+	tweet := testLinkTweet(id, "http://a.com", "golang",
+		time.Date(2015, time.November, 15, 4, 10, 10, 0, time.UTC))
+	tweet.Text = "Go 1.5.1 is out <3"
+	tweet.User.Name = "Gopher Daily"
+	tweet.User.ScreenName = "gopherdaily"
+	tweet.User.ProfileImageUrlHttps = "https://pbs.twimg.com/gopher.png"
+	return tweet
+}
+
+func TestOEmbedProvider(t *testing.T) {
+	c := context.Background()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Query().Get("omit_script") != "true" {
+			t.Errorf("Expected the widgets script to be left out")
+		}
+		if r.URL.Query().Get("url") != "https://twitter.com/gopherdaily/status/1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"html": "<blockquote class=\"twitter-tweet\">Go 1.5.1</blockquote>"}`)
+	}))
+	defer server.Close()
+
+	provider := NewOEmbedProvider(StubEmbedProvider{})
+	provider.Endpoint = server.URL
+
+	for i := 0; i < 2; i++ {
+		embed, err := provider.Embed(c, testEmbedTweet(1))
+		if err != nil || embed != `<blockquote class="twitter-tweet">Go 1.5.1</blockquote>` {
+			t.Errorf("Expected the oEmbed HTML, got %v, %v", embed, err)
+		}
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected the second embed to be cached, made %v requests", requests)
+	}
+
+	embed, err := provider.Embed(c, testEmbedTweet(2))
+	if err != nil || embed != "<p>tweet 2</p>" {
+		t.Errorf("Expected the fallback for a missing tweet, got %v, %v", embed, err)
+	}
+
+	provider.Fallback = nil
+	if _, err := provider.Embed(c, testEmbedTweet(2)); err == nil {
+		t.Errorf("Expected an error without a fallback")
+	}
+}
+
+func TestTemplateEmbedProvider(t *testing.T) {
+	embed, err := TemplateEmbedProvider{}.Embed(context.Background(), testEmbedTweet(1))
+	if err != nil {
+		t.Fatalf("Failed to render embed: %v", err)
+	}
+	for _, expected := range []string{
+		`<img src="https://pbs.twimg.com/gopher.png"`,
+		"<strong>Gopher Daily</strong>",
+		`<a href="https://twitter.com/gopherdaily">@gopherdaily</a>`,
+		"Go 1.5.1 is out &lt;3",
+		`<a href="https://twitter.com/gopherdaily/status/1">Sun, 15 Nov 2015 04:10:10 UTC</a>`,
+	} {
+		if !strings.Contains(embed, expected) {
+			t.Errorf("Expected %v in the embed, got %v", expected, embed)
+		}
+	}
+}
+
+func TestBuildDescription(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	store.PutLinkTweets(c, LinkTweets{testEmbedTweet(3), testEmbedTweet(1), testEmbedTweet(2)})
+
+	item := &FeedItem{TweetScore: TweetScore{TweetIDs: []int64{3, 1, 2, 4}}}
+	item.BuildDescription(store, StubEmbedProvider{}, c)
+
+	expected := "<div class=\"tweets\">\n<p>tweet 1</p>\n<p>tweet 2</p>\n<p>tweet 3</p>\n</div>"
+	if item.description != expected {
+		t.Errorf("Expected the embeds oldest first, got %q", item.description)
+	}
+}
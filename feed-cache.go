@@ -0,0 +1,111 @@
+package tweetharvest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//feedCacheTTL is how long a rendered feed is served from the cache.  The ETag
+// only changes when scores do, but the hot ranking also shifts with time.
+const feedCacheTTL = 15 * time.Minute
+
+//FeedCache keeps rendered feeds so that feed readers polling /consume don't each
+// query the store for every item and tweet.  Feeds are cached per query and per
+// variant (sort, format and embed), and an entry is only served while its ETag
+// matches the current one, so a cache that misses an Invalidate, e.g. on another
+// App Engine instance, still never serves a feed older than the scores.  Expired
+// entries are dropped whenever a feed is put.
+type FeedCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]*cachedFeed
+}
+
+//cachedFeed is a rendered feed.
+type cachedFeed struct {
+	etag        string
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+//NewFeedCache returns an empty FeedCache.
+func NewFeedCache() *FeedCache {
+	return &FeedCache{entries: make(map[string]map[string]*cachedFeed)}
+}
+
+//get returns the feed cached for the query and variant if it has the given ETag
+// and hasn't expired.
+func (cache *FeedCache) get(query string, variant string, etag string, now time.Time) (*cachedFeed, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	feed, ok := cache.entries[query][variant]
+	if !ok || feed.etag != etag || now.After(feed.expires) {
+		return nil, false
+	}
+	return feed, true
+}
+
+//put caches a rendered feed for the query and variant, replacing any feed it
+// had for them, and drops the feeds that have expired.
+func (cache *FeedCache) put(query string, variant string, feed *cachedFeed, now time.Time) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for cachedQuery, variants := range cache.entries {
+		for cachedVariant, cached := range variants {
+			if now.After(cached.expires) {
+				delete(variants, cachedVariant)
+			}
+		}
+		if len(variants) == 0 {
+			delete(cache.entries, cachedQuery)
+		}
+	}
+
+	if cache.entries[query] == nil {
+		cache.entries[query] = make(map[string]*cachedFeed)
+	}
+	cache.entries[query][variant] = feed
+}
+
+//Invalidate drops all of the cached feeds for the query.
+func (cache *FeedCache) Invalidate(query string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	delete(cache.entries, query)
+}
+
+//feedETag returns a strong ETag for a feed variant as of the last write to its
+// scores.  The feed title is included so that renaming the topic changes it.
+func feedETag(query string, variant string, title string, lastUpdate time.Time) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d", query, variant, title, lastUpdate.UnixNano())
+	return fmt.Sprintf(`"%x"`, hash.Sum64())
+}
+
+//notModified reports whether the conditional headers of the request show the
+// client already has the feed.  If-None-Match is used in preference to
+// If-Modified-Since, as in RFC 7232.
+func notModified(request *http.Request, etag string, lastModified time.Time) bool {
+	if match := request.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
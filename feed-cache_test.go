@@ -0,0 +1,141 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsumeConditionalRequests(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+	setTitle := func(title string, lastActive time.Time) {
+		store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+			score.Title = title
+			score.Score = 3
+			score.LastActive = lastActive
+			return nil
+		})
+	}
+	setTitle("First title", now)
+	router := NewRouter(testServices(store, nil))
+
+	consume := func(header string, value string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/consume?q=golang", nil)
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	first := consume("", "")
+	etag := first.Header().Get("ETag")
+	lastModified := first.Header().Get("Last-Modified")
+	if modified, err := http.ParseTime(lastModified); etag == "" || err != nil || modified.Before(now.Truncate(time.Second)) {
+		t.Fatalf("Expected an ETag and Last-Modified, got %q and %q", etag, lastModified)
+	}
+
+	if response := consume("If-None-Match", etag); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %v", response.Code)
+	}
+	if response := consume("If-None-Match", `"other", W/`+etag); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching weak ETag in a list, got %v", response.Code)
+	}
+	if response := consume("If-Modified-Since", lastModified); response.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an unmodified feed, got %v", response.Code)
+	}
+	earlier := now.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if response := consume("If-Modified-Since", earlier); response.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a modified feed, got %v", response.Code)
+	}
+
+	//Any write to a score changes the ETag, even one that doesn't touch LastActive
+	// such as a title or a late tweet, so the feed is rendered again.
+	setTitle("Second title", now)
+	response := consume("If-None-Match", etag)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "Second title") {
+		t.Errorf("Expected a fresh feed after the score was written, got %v", response.Code)
+	}
+	if response.Header().Get("ETag") == etag {
+		t.Errorf("Expected the ETag to change with the score")
+	}
+}
+
+func TestReduceInvalidatesFeedCache(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	cache := NewFeedCache()
+	now := time.Now()
+
+	cache.put("golang", "hot atom", &cachedFeed{etag: `"1"`, expires: now.Add(time.Hour)}, now)
+	cache.put("rust", "hot atom", &cachedFeed{etag: `"2"`, expires: now.Add(time.Hour)}, now)
+	if _, ok := cache.get("golang", "hot atom", `"1"`, now); !ok {
+		t.Fatalf("Expected the feed to be cached")
+	}
+	if _, ok := cache.get("golang", "hot atom", `"1"`, now.Add(2*time.Hour)); ok {
+		t.Errorf("Expected an expired feed not to be served")
+	}
+
+	//The score already exists, so the reducer doesn't fetch the page.
+	store.UpdateScore(c, "golang", "https://a.com", func(score *TweetScore, exists bool) error {
+		score.LastActive = now.Add(-time.Hour)
+		return nil
+	})
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(1, "http://a.com", "golang", now)})
+	reducer := Reducer{c: c, store: store, scorer: DefaultScorer, cache: cache}
+	if _, err := reducer.reduce(); err != nil {
+		t.Fatalf("Reduce failed: %v", err)
+	}
+
+	if _, ok := cache.get("golang", "hot atom", `"1"`, now); ok {
+		t.Errorf("Expected the reducer to invalidate the golang feeds")
+	}
+	if _, ok := cache.get("rust", "hot atom", `"2"`, now); !ok {
+		t.Errorf("Expected the rust feeds to stay cached")
+	}
+}
+
+func TestFeedCacheBounds(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+		score.Title = "A title"
+		score.Score = 3
+		score.LastActive = time.Now()
+		return nil
+	})
+	cache := NewFeedCache()
+	consume := FeedProducer{
+		store:      store,
+		cache:      cache,
+		embeds:     DefaultEmbedProviders(),
+		newContext: testServices(store, nil).NewContext,
+	}
+
+	//Each host gets a feed that links back to it, but they share a cache entry.
+	for _, host := range []string{"one.example.com", "two.example.com", "one.example.com"} {
+		request := httptest.NewRequest("GET", "/consume?q=golang", nil)
+		request.Host = host
+		response := httptest.NewRecorder()
+		consume.ServeHTTP(response, request)
+		if !strings.Contains(response.Body.String(), "http://"+host+"/consume") {
+			t.Errorf("Expected the feed for %v to link to it, got %v", host, response.Body.String())
+		}
+	}
+	if len(cache.entries) != 1 || len(cache.entries["golang"]) != 1 {
+		t.Errorf("Expected one cached feed, got %v", cache.entries)
+	}
+
+	//Putting a feed drops the ones that have expired.
+	later := time.Now().Add(2 * feedCacheTTL)
+	cache.put("rust", "hot atom", &cachedFeed{etag: `"1"`, expires: later.Add(time.Hour)}, later)
+	if len(cache.entries) != 1 || len(cache.entries["rust"]) != 1 {
+		t.Errorf("Expected only the new feed to be cached, got %v", cache.entries)
+	}
+}
@@ -0,0 +1,170 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+//Formats a feed can be written in, selected with the format parameter of
+// /consume or the request's Accept header.
+const (
+	formatAtom = "atom"
+	formatRSS  = "rss"
+	formatJSON = "json"
+)
+
+//formatContentTypes are the Content-Types the feed formats are served with.
+var formatContentTypes = map[string]string{
+	formatAtom: "application/atom+xml; charset=utf-8",
+	formatRSS:  "application/rss+xml; charset=utf-8",
+	formatJSON: "application/feed+json; charset=utf-8",
+}
+
+//acceptFormats maps the media types a client may accept to the format served for
+// them.
+var acceptFormats = map[string]string{
+	"application/atom+xml":  formatAtom,
+	"application/rss+xml":   formatRSS,
+	"application/feed+json": formatJSON,
+	"application/json":      formatJSON,
+	"application/xml":       formatAtom,
+	"text/xml":              formatAtom,
+}
+
+//feedFormat returns the format to write the feed in.  The format parameter wins
+// over the Accept header, and Atom is used if neither picks a format.
+func feedFormat(request *http.Request) (string, error) {
+	if format := request.URL.Query().Get(formatParam); format != "" {
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", errors.New("format must be one of atom, rss or json.")
+		}
+		return format, nil
+	}
+	return negotiateFormat(request.Header.Get("Accept")), nil
+}
+
+//negotiateFormat returns the format the Accept header prefers most, by quality
+// and then by order.  Wildcards and unknown types fall back to Atom.
+func negotiateFormat(accept string) string {
+	best, bestQuality := formatAtom, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := acceptFormats[mediaType]
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best
+}
+
+//renderFeed writes the feed in the given format.  It is served with the format's
+// entry in formatContentTypes.
+func renderFeed(format string, feed *feeds.Feed, items FeedItems) ([]byte, error) {
+	var out string
+	var err error
+	switch format {
+	case formatRSS:
+		out, err = feed.ToRss()
+	case formatJSON:
+		var raw []byte
+		raw, err = json.MarshalIndent(newJSONFeed(feed, items), "", "  ")
+		out = string(raw)
+	default:
+		out, err = feed.ToAtom()
+	}
+	return []byte(out), err
+}
+
+//jsonFeedVersion identifies the version of JSON Feed written by newJSONFeed.
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+//JSONFeed is a feed in the JSON Feed 1.1 format, https://jsonfeed.org/version/1.1
+type JSONFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Authors     []JSONFeedAuthor `json:"authors,omitempty"`
+	Items       []JSONFeedItem   `json:"items"`
+}
+
+//JSONFeedAuthor is an author of a JSON Feed or one of its items.
+type JSONFeedAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+//JSONFeedItem is an item of a JSON Feed.
+type JSONFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title,omitempty"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	Summary       string           `json:"summary,omitempty"`
+	Image         string           `json:"image,omitempty"`
+	DatePublished *time.Time       `json:"date_published,omitempty"`
+	DateModified  *time.Time       `json:"date_modified,omitempty"`
+	Authors       []JSONFeedAuthor `json:"authors,omitempty"`
+}
+
+//newJSONFeed builds a JSON Feed with the details of feed and an item for each of
+// the items.
+func newJSONFeed(feed *feeds.Feed, items FeedItems) *JSONFeed {
+	out := &JSONFeed{
+		Version:     jsonFeedVersion,
+		Title:       feed.Title,
+		Description: feed.Description,
+		Items:       make([]JSONFeedItem, 0, len(items)),
+	}
+	if feed.Link != nil {
+		out.FeedURL = feed.Link.Href
+	}
+	if feed.Author != nil {
+		out.Authors = []JSONFeedAuthor{{Name: feed.Author.Name}}
+	}
+
+	for _, item := range items {
+		entry := item.getItem()
+		jsonItem := JSONFeedItem{
+			ID:          item.Address,
+			URL:         entry.Link.Href,
+			Title:       entry.Title,
+			ContentHTML: item.description,
+			Summary:     item.Description,
+			Image:       item.Image,
+		}
+		if !item.Published.IsZero() {
+			published := item.Published
+			jsonItem.DatePublished = &published
+		}
+		if !item.LastActive.IsZero() {
+			modified := item.LastActive
+			jsonItem.DateModified = &modified
+		}
+		if entry.Author != nil {
+			jsonItem.Authors = []JSONFeedAuthor{{Name: entry.Author.Name}}
+		}
+		out.Items = append(out.Items, jsonItem)
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := map[string]string{
+		"":                                  formatAtom,
+		"*/*":                               formatAtom,
+		"application/rss+xml":               formatRSS,
+		"application/feed+json":             formatJSON,
+		"application/json, text/html;q=0.9": formatJSON,
+		"application/atom+xml;q=0.5, application/rss+xml":   formatRSS,
+		"application/rss+xml;q=0.2, application/json;q=0.8": formatJSON,
+		"text/html": formatAtom,
+	}
+	for accept, expected := range cases {
+		if format := negotiateFormat(accept); format != expected {
+			t.Errorf("negotiateFormat(%q) = %v, expected %v", accept, format, expected)
+		}
+	}
+}
+
+func TestConsumeFormats(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+	store.UpdateScore(c, "golang", "https://blog.golang.org/go1.5.1", func(score *TweetScore, exists bool) error {
+		score.Title = "Go 1.5.1 is released"
+		score.Description = "A minor release"
+		score.Score = 3
+		score.LastActive = now
+		return nil
+	})
+	router := NewRouter(testServices(store, nil))
+
+	consume := func(target string, accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	response := consume("/consume?q=golang", "")
+	if response.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" ||
+		!strings.Contains(response.Body.String(), "<feed") {
+		t.Errorf("Expected Atom by default, got %v", response.Header().Get("Content-Type"))
+	}
+
+	response = consume("/consume?q=golang&format=rss", "application/json")
+	if response.Header().Get("Content-Type") != "application/rss+xml; charset=utf-8" ||
+		!strings.Contains(response.Body.String(), "<rss") {
+		t.Errorf("Expected the format parameter to win, got %v", response.Header().Get("Content-Type"))
+	}
+
+	response = consume("/consume?q=golang", "application/feed+json")
+	if response.Header().Get("Content-Type") != "application/feed+json; charset=utf-8" {
+		t.Fatalf("Expected JSON Feed, got %v", response.Header().Get("Content-Type"))
+	}
+	var feed JSONFeed
+	if err := json.Unmarshal(response.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Failed to decode JSON Feed: %v", err)
+	}
+	if feed.Version != jsonFeedVersion || len(feed.Items) != 1 {
+		t.Fatalf("Unexpected JSON Feed %+v", feed)
+	}
+	item := feed.Items[0]
+	if item.ID != "https://blog.golang.org/go1.5.1" || item.Title != "Go 1.5.1 is released" ||
+		item.Summary != "A minor release" || item.DateModified == nil || item.DatePublished != nil {
+		t.Errorf("Unexpected JSON Feed item %+v", item)
+	}
+
+	response = consume("/consume?q=golang&format=xml", "")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %v", response.Code)
+	}
+}
@@ -1,111 +1,131 @@
 package tweetharvest
 
 import (
-	"bufio"
-	"bytes"
-	"html/template"
+	"context"
+	"mime"
+	"path"
 	"sort"
+	"strings"
 	"sync"
 
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
-
-	"golang.org/x/net/context"
+	"github.com/AndyNortrup/TweetHarvest/log"
 
 	"github.com/gorilla/feeds"
 )
 
-const embed = `<HTML><BODY><UL>
-{{range .}}
-<LI>{{.Text}}</LI>
-{{end}}
-</UL></BODY></HTML>`
-
 //FeedItem is a struct that provides a description for a TweetScore
 type FeedItem struct {
 	description string
 	TweetScore
-	key string
 }
 
+//getItem returns the feed entry for the item.  The page's own description, if it
+// has one, is the entry's summary and the tweets about it are its content.
 func (item *FeedItem) getItem() *feeds.Item {
 	var out feeds.Item
 
 	out.Created = item.LastActive
 	out.Title = item.Title
-	out.Link = &feeds.Link{Href: item.Address}
+	if out.Title == "" {
+		out.Title = item.link()
+	}
+	out.Link = &feeds.Link{Href: item.link()}
 	out.Description = item.description
+	if item.Description != "" {
+		out.Description = item.Description
+		out.Content = item.description
+	}
+
+	switch {
+	case item.Author != "":
+		out.Author = &feeds.Author{Name: item.Author}
+	case item.SiteName != "":
+		out.Author = &feeds.Author{Name: item.SiteName}
+	}
+
+	if item.Image != "" {
+		imageType := mime.TypeByExtension(path.Ext(item.Image))
+		if !strings.HasPrefix(imageType, "image/") {
+			imageType = "image/jpeg"
+		}
+		out.Enclosure = &feeds.Enclosure{Url: item.Image, Type: imageType, Length: "0"}
+	}
 
 	return &out
 }
 
-//BuildDescription creates a feed item by retrieving the twitter embed code from
-// twitter API
-func (item *FeedItem) BuildDescription(c context.Context) {
+//tweetEmbed is the embed HTML rendered for a tweet.
+type tweetEmbed struct {
+	id   int64
+	html string
+}
+
+//BuildDescription creates the description of a feed item from the embeds of the
+// tweets that linked to it, rendered by the given EmbedProvider.
+func (item *FeedItem) BuildDescription(store Store, embeds EmbedProvider, c context.Context) {
 	var embedWG sync.WaitGroup
 
-	embeds := make(chan *LinkTweet)
+	rendered := make(chan tweetEmbed)
 	for _, tweetID := range item.TweetIDs {
 		embedWG.Add(1)
-		go item.getEmbedFor(tweetID, embeds, &embedWG, c)
+		go item.getEmbedFor(tweetID, store, embeds, rendered, &embedWG, c)
 	}
 
 	chanDesc := make(chan string, 1)
-	go item.combineDescription(embeds, chanDesc, c)
+	go item.combineDescription(rendered, chanDesc)
 
 	embedWG.Wait()
-	close(embeds)
+	close(rendered)
 
 	item.description = <-chanDesc
 }
 
-//Send a request to Twitter for the embed.
+//getEmbedFor gets the tweet from the store and renders its embed.
 func (item *FeedItem) getEmbedFor(tweetID int64,
-	out chan<- *LinkTweet,
+	store Store,
+	embeds EmbedProvider,
+	out chan<- tweetEmbed,
 	wg *sync.WaitGroup,
 	c context.Context) {
 
 	defer wg.Done()
-	iterator := datastore.NewQuery(linkTweetKind).
-		Project("User", "Text").
-		Filter("TweetID=", tweetID).
-		Limit(1).
-		Run(c)
-
-	tweet := &LinkTweet{}
-	_, err := iterator.Next(tweet)
+	tweet, err := store.LinkTweet(c, tweetID)
 	if err != nil {
 		log.Errorf(c, "Error getting tweets for embed. \n\t%v", err.Error())
 	}
-	out <- tweet
-}
-
-//buildDescription compiles the individual html parts from the getEmbedFor
-// routines
-func (item *FeedItem) combineDescription(
-	in <-chan *LinkTweet,
-	out chan<- string,
-	c context.Context) {
-
-	var parts LinkTweets
-	for tweet := range in {
-		parts = append(parts, tweet)
+	if tweet == nil {
+		return
 	}
-	sort.Sort(parts)
-
-	var tweetTemplate = template.Must(template.New("tweet").Parse(embed))
-	var b bytes.Buffer
-	w := bufio.NewWriter(&b)
 
-	err := tweetTemplate.Execute(w, parts)
+	html, err := embeds.Embed(c, tweet)
 	if err != nil {
-		log.Errorf(c, "Error writing template.  \n\tStoreTweets: %v\n\t%v", parts, err.Error)
+		log.Errorf(c, "Error embedding tweet %v. \n\t%v", tweetID, err.Error())
+		return
 	}
+	out <- tweetEmbed{id: tweetID, html: html}
+}
+
+//combineDescription compiles the individual embeds from the getEmbedFor
+// routines, oldest tweet first.
+func (item *FeedItem) combineDescription(in <-chan tweetEmbed, out chan<- string) {
 
-	w.Flush()
+	var parts []tweetEmbed
+	for embed := range in {
+		parts = append(parts, embed)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].id < parts[j].id
+	})
+
+	var b strings.Builder
+	b.WriteString(`<div class="tweets">`)
+	for _, part := range parts {
+		b.WriteString("\n")
+		b.WriteString(part.html)
+	}
+	b.WriteString("\n</div>")
 
-	log.Infof(c, "Description: %v", b.String())
-	out <- string(b.String())
+	out <- b.String()
 
 	close(out)
 }
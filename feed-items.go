@@ -1,5 +1,11 @@
 package tweetharvest
 
+import (
+	"math"
+	"sort"
+	"time"
+)
+
 //FeedItems is a sortable slice of FeedItem objects.
 type FeedItems []*FeedItem
 
@@ -8,11 +14,67 @@ func (s FeedItems) Len() int {
 	return len(s)
 }
 
-//Less compares two items in the slice baed on the tweetScore.Score
+//Less compares two items in the slice baed on the tweetScore.Score, so that the
+// highest scores sort first.
 func (s FeedItems) Less(i, j int) bool {
-	return s[i].Score < s[i].Score
+	return s[i].Score > s[j].Score
 }
 
 func (s FeedItems) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
+
+//Orders a feed can be sorted in, selected with the sort parameter of /consume.
+const (
+	//sortHot ranks items by their score decayed by age, like the HN front page.
+	sortHot = "hot"
+	//sortTop ranks items by their score alone.
+	sortTop = "top"
+	//sortNew ranks the most recently discovered items first.
+	sortNew = "new"
+)
+
+//hotGravity controls how quickly a score decays with age.  HN uses 1.8.
+const hotGravity = 1.8
+
+//validSort reports whether order is one of the feed sort orders.
+func validSort(order string) bool {
+	return order == sortHot || order == sortTop || order == sortNew
+}
+
+//sortBy sorts the items in the given order, as of now.  Ties keep their order.
+func (s FeedItems) sortBy(order string, now time.Time) {
+	switch order {
+	case sortTop:
+		sort.Stable(s)
+	case sortNew:
+		sort.SliceStable(s, func(i, j int) bool {
+			return s[i].firstSeen().After(s[j].firstSeen())
+		})
+	default:
+		sort.SliceStable(s, func(i, j int) bool {
+			return s[i].hotness(now) > s[j].hotness(now)
+		})
+	}
+}
+
+//firstSeen returns when the item's address was first seen, falling back to
+// LastActive for scores that predate FirstSeen.
+func (item *FeedItem) firstSeen() time.Time {
+	if item.FirstSeen.IsZero() {
+		return item.LastActive
+	}
+	return item.FirstSeen
+}
+
+//hotness is the item's score divided by a power of its age in hours.  The age is
+// the average of the time since the address was first seen and the time since it
+// was last shared, so a link that is still being shared decays more slowly than
+// one that has gone quiet.
+func (item *FeedItem) hotness(now time.Time) float64 {
+	age := (now.Sub(item.firstSeen()) + now.Sub(item.LastActive)) / 2
+	if age < 0 {
+		age = 0
+	}
+	return float64(item.Score) / math.Pow(age.Hours()+2, hotGravity)
+}
@@ -0,0 +1,46 @@
+package tweetharvest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedItemsSortBy(t *testing.T) {
+	now := time.Now()
+	item := func(address string, score int, firstSeen time.Duration, lastActive time.Duration) *FeedItem {
+		return &FeedItem{TweetScore: TweetScore{
+			Address:    address,
+			Score:      score,
+			FirstSeen:  now.Add(-firstSeen),
+			LastActive: now.Add(-lastActive),
+		}}
+	}
+
+	//old has the highest score but was last shared days ago, fresh has a modest
+	// score from the last hour and steady was found yesterday and is still being
+	// shared.
+	old := item("old", 100, 6*24*time.Hour, 5*24*time.Hour)
+	fresh := item("fresh", 10, time.Hour, 0)
+	steady := item("steady", 30, 24*time.Hour, 0)
+
+	expected := map[string][]*FeedItem{
+		sortTop: {old, steady, fresh},
+		sortNew: {fresh, steady, old},
+		sortHot: {fresh, steady, old},
+	}
+	for order, want := range expected {
+		items := FeedItems{old, fresh, steady}
+		items.sortBy(order, now)
+		for i := range want {
+			if items[i] != want[i] {
+				t.Errorf("Sort %v: expected %v at %v, got %v", order, want[i].Address, i, items[i].Address)
+			}
+		}
+	}
+
+	//Scores from before FirstSeen was recorded fall back to LastActive.
+	legacy := &FeedItem{TweetScore: TweetScore{LastActive: now}}
+	if !legacy.firstSeen().Equal(now) {
+		t.Errorf("Expected firstSeen to fall back to LastActive")
+	}
+}
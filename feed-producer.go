@@ -1,39 +1,107 @@
 package tweetharvest
 
 import (
+	"context"
 	"net/http"
-	"sort"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/gorilla/feeds"
 
-	"golang.org/x/net/context"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
+	"github.com/AndyNortrup/TweetHarvest/log"
 )
 
 //FeedProducer is a Handler that takes a query and returns a RSS feed
 type FeedProducer struct {
-	c     context.Context
-	query string
+	c          context.Context
+	query      string
+	topic      *Topic
+	link       string
+	order      string
+	format     string
+	policies   DomainPolicies
+	variant    string
+	etag       string
+	store      Store
+	cache      *FeedCache
+	embeds     map[string]EmbedProvider
+	newContext ContextFunc
 }
 
 //ServeHTTP responds to http requests for the /consume endpoint
 func (fp FeedProducer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	fp.c = appengine.NewContext(request)
+	fp.c = fp.newContext(request)
 
 	query, err := getQuery(request, fp.c)
 	if err != nil {
 		log.Errorf(fp.c, "No query provided.")
 		http.Error(writer, "No query provided.", http.StatusBadRequest)
+		return
 	}
 	fp.query = query
+	fp.link = feedLink(request, query)
+
+	fp.order = request.URL.Query().Get(sortParam)
+	if fp.order == "" {
+		fp.order = sortHot
+	}
+	if !validSort(fp.order) {
+		http.Error(writer, "sort must be one of hot, top or new.", http.StatusBadRequest)
+		return
+	}
+
+	fp.format, err = feedFormat(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fp.topic, err = fp.store.GetTopic(fp.c, query)
+	if err != nil {
+		log.Errorf(fp.c, "Error reading topic from store. %v", err.Error())
+	}
+	if fp.topic == nil {
+		fp.topic = &Topic{Query: query}
+	}
+
+	fp.policies, err = fp.store.GetDomainPolicies(fp.c)
+	if err != nil {
+		log.Errorf(fp.c, "Error reading domain policies from store. %v", err.Error())
+	}
+
+	//Feeds only change when the query's scores do, so conditional requests and the
+	// cache are answered from the last write to any of them.
+	writer.Header().Add("Vary", "Accept")
+	lastUpdate, err := fp.store.LastQueryUpdate(fp.c, query)
+	if err != nil {
+		log.Errorf(fp.c, "Error reading last update from store. %v", err.Error())
+	} else {
+		//The cache keeps one entry per variant, and the host the feed links to and
+		// the blocked domains only go into the ETag that the entry is checked
+		// against, so that requests can't add entries without end.
+		fp.variant = fp.order + " " + fp.format + " " + fp.topic.embed()
+		fp.etag = feedETag(query, fp.variant+" "+fp.link+" "+fp.policies.blockedNames(),
+			fp.topic.feedTitle(), lastUpdate)
+		writer.Header().Set("ETag", fp.etag)
+		if !lastUpdate.IsZero() {
+			writer.Header().Set("Last-Modified", lastUpdate.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(request, fp.etag, lastUpdate) {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if cached, ok := fp.cache.get(query, fp.variant, fp.etag, time.Now()); ok {
+			log.Infof(fp.c, "Serving cached feed.")
+			writeCachedFeed(writer, cached)
+			return
+		}
+	}
 
 	items := make(chan *FeedItem)
 
-	scores := fp.getContentFromDatastore()
+	scores := fp.getContent()
 	log.Infof(fp.c, "Recieved %v scores.", len(scores))
 
 	go fp.getDescriptions(scores, items)
@@ -41,40 +109,39 @@ func (fp FeedProducer) ServeHTTP(writer http.ResponseWriter, request *http.Reque
 	fp.returnFeed(writer, items)
 }
 
-func (fp FeedProducer) getContentFromDatastore() FeedItems {
+//getContent gets the scores for the query that have been active in the last week,
+// leaving out those on blocked domains
+func (fp FeedProducer) getContent() FeedItems {
 
 	var out FeedItems
 
-	q := datastore.NewQuery(tweetScoreKind).
-		Ancestor(getTweetScoreKey(fp.c)).
-		Filter("LastActive >=", time.Now().AddDate(0, 0, -7)).
-		Distinct().
-		Filter("Query =", fp.query).
-		Order("-LastActive")
-
-	for i := q.Run(fp.c); ; {
-		item := &FeedItem{}
-		key, err := i.Next(item)
-		if err == datastore.Done {
-			break
-		}
-		if err != nil {
-			log.Errorf(fp.c, "Error reading from datastore. %v", err.Error())
-		}
-		item.key = key.StringID()
+	scores, err := fp.store.RecentScores(fp.c, fp.query, time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		log.Errorf(fp.c, "Error reading from store. %v", err.Error())
+	}
 
-		out = append(out, item)
+	for _, score := range scores {
+		if fp.policies.blocked(score.Address) {
+			continue
+		}
+		out = append(out, &FeedItem{TweetScore: *score})
 	}
 	return out
 }
 
 func (fp FeedProducer) getDescriptions(in FeedItems, out chan<- *FeedItem) {
+	embeds, ok := fp.embeds[fp.topic.embed()]
+	if !ok {
+		log.Errorf(fp.c, "No embed provider named %v, using the template.", fp.topic.embed())
+		embeds = TemplateEmbedProvider{}
+	}
+
 	var wg sync.WaitGroup
 	for _, val := range in {
 		wg.Add(1)
 		go func(item *FeedItem) {
 			defer wg.Done()
-			item.BuildDescription(fp.c)
+			item.BuildDescription(fp.store, embeds, fp.c)
 			out <- item
 		}(val)
 	}
@@ -85,10 +152,11 @@ func (fp FeedProducer) getDescriptions(in FeedItems, out chan<- *FeedItem) {
 func (fp FeedProducer) returnFeed(w http.ResponseWriter, in <-chan *FeedItem) {
 
 	feed := &feeds.Feed{
-		Link:    &feeds.Link{Href: "http://tweet-integrator.appspot.com/consume?q=golang"},
-		Author:  &feeds.Author{Name: "Andy Nortrup", Email: "andrew.nortrup@gmail.com"},
-		Title:   "Top articles from twitter about: " + fp.query,
-		Updated: time.Now(),
+		Link:        &feeds.Link{Href: fp.link},
+		Author:      &feeds.Author{Name: "Andy Nortrup", Email: "andrew.nortrup@gmail.com"},
+		Title:       fp.topic.feedTitle(),
+		Description: defaultFeedTitle(fp.query),
+		Updated:     time.Now(),
 	}
 
 	var scoreItems FeedItems
@@ -96,20 +164,52 @@ func (fp FeedProducer) returnFeed(w http.ResponseWriter, in <-chan *FeedItem) {
 		scoreItems = append(scoreItems, item)
 	}
 
-	sort.Sort(scoreItems)
+	scoreItems.sortBy(fp.order, time.Now())
 	log.Infof(fp.c, "%v total items to put in feed", len(scoreItems))
 
 	for _, item := range scoreItems {
 		feed.Add(item.getItem())
 	}
 
-	log.Infof(fp.c, "Writing output.")
-	atom, err := feed.ToAtom()
+	log.Infof(fp.c, "Writing output as %v.", fp.format)
+	body, err := renderFeed(fp.format, feed, scoreItems)
 	if err != nil {
-		log.Errorf(fp.c, "Error writing ATOM: %v", err.Error())
+		log.Errorf(fp.c, "Error writing %v: %v", fp.format, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	//w.Header().Add("Content-Type", "application/atom+xml")
-	w.Write([]byte(atom))
 
+	now := time.Now()
+	rendered := &cachedFeed{
+		etag:        fp.etag,
+		contentType: formatContentTypes[fp.format],
+		body:        body,
+		expires:     now.Add(feedCacheTTL),
+	}
+	if fp.etag != "" {
+		fp.cache.put(fp.query, fp.variant, rendered, now)
+	}
+	writeCachedFeed(w, rendered)
+}
+
+//writeCachedFeed writes a rendered feed with its Content-Type.
+func writeCachedFeed(w http.ResponseWriter, feed *cachedFeed) {
+	w.Header().Set("Content-Type", feed.contentType)
+	w.Write(feed.body)
+}
+
+//feedLink returns the address of the feed for a query on the server that received
+// the request.
+func feedLink(request *http.Request, query string) string {
+	scheme := "http"
+	if request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	link := url.URL{
+		Scheme:   scheme,
+		Host:     request.Host,
+		Path:     "/consume",
+		RawQuery: url.Values{queryParam: {query}}.Encode(),
+	}
+	return link.String()
 }
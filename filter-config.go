@@ -0,0 +1,145 @@
+package tweetharvest
+
+import "errors"
+
+//FilterConfig describes the Filters a Topic applies to its tweets in the map
+// stage, as it is stored with the topic and sent to the /topics endpoints.  A
+// tweet must pass every setting in a FilterConfig, so
+//
+//	{"languages": ["en"], "not": {"exclude_retweets": true, "verified_only": true},
+//	 "or": [{"min_followers": 500}, {"allow_domains": ["golang.org"]}]}
+//
+// keeps English tweets that aren't verified retweets, from users with 500
+// followers or linking to golang.org.  Each call to Filter builds new Filters,
+// so a SpamFilter in the configuration only sees one harvest, or one batch of
+// the StreamHarvester.
+type FilterConfig struct {
+	//And, Or and Not combine nested configurations: all of And must pass, at
+	// least one of Or must pass and Not must fail.
+	And []*FilterConfig `json:"and,omitempty"`
+	Or  []*FilterConfig `json:"or,omitempty"`
+	Not *FilterConfig   `json:"not,omitempty"`
+
+	MinFollowers    int      `json:"min_followers,omitempty"`
+	Languages       []string `json:"languages,omitempty"`
+	VerifiedOnly    bool     `json:"verified_only,omitempty"`
+	ExcludeRetweets bool     `json:"exclude_retweets,omitempty"`
+	AllowDomains    []string `json:"allow_domains,omitempty"`
+	DenyDomains     []string `json:"deny_domains,omitempty"`
+	BlockUsers      []string `json:"block_users,omitempty"`
+	ExcludeKeywords []string `json:"exclude_keywords,omitempty"`
+
+	//MaxHashtags is a pointer so that 0, allowing no hashtags, can be told apart
+	// from not limiting them.
+	MaxHashtags *int `json:"max_hashtags,omitempty"`
+
+	//Spam rejects tweets that look like they come from bots, see SpamFilter.  It
+	// can only be used where its rejections drop the tweet, not inside Or or Not.
+	Spam *SpamConfig `json:"spam,omitempty"`
+}
+
+//SpamConfig configures the SpamFilter of a FilterConfig.
+type SpamConfig struct {
+	//Threshold is the score at which tweets are rejected, defaultSpamThreshold
+	// if it is 0.
+	Threshold int `json:"threshold,omitempty"`
+}
+
+//Filter builds the Filter described by the configuration, or returns an error if
+// it isn't valid.
+func (config *FilterConfig) Filter() (Filter, error) {
+	return config.build(nil, false)
+}
+
+//build builds the Filter described by the configuration, with rejected passed
+// to its SpamFilters.  negatable is true under an or or a not, where a tweet a
+// filter rejects may still be kept, so spam isn't allowed there: its rejections
+// would be reported for tweets that are harvested after all.
+func (config *FilterConfig) build(rejected func(rejection SpamRejection), negatable bool) (Filter, error) {
+	var out AndFilter
+
+	if config.MinFollowers < 0 {
+		return nil, errors.New("Filter min_followers can't be negative.")
+	}
+	if config.MinFollowers > 0 {
+		out = append(out, MinFollowersFilter{Min: config.MinFollowers})
+	}
+	if len(config.Languages) > 0 {
+		out = append(out, LanguageFilter{Languages: config.Languages})
+	}
+	if config.VerifiedOnly {
+		out = append(out, VerifiedFilter{})
+	}
+	if config.ExcludeRetweets {
+		out = append(out, RetweetFilter{})
+	}
+	if len(config.AllowDomains) > 0 || len(config.DenyDomains) > 0 {
+		out = append(out, DomainFilter{Allow: config.AllowDomains, Deny: config.DenyDomains})
+	}
+	if len(config.BlockUsers) > 0 {
+		out = append(out, UserBlockFilter{ScreenNames: config.BlockUsers})
+	}
+	if len(config.ExcludeKeywords) > 0 {
+		out = append(out, KeywordFilter{Keywords: config.ExcludeKeywords})
+	}
+	if config.MaxHashtags != nil {
+		if *config.MaxHashtags < 0 {
+			return nil, errors.New("Filter max_hashtags can't be negative.")
+		}
+		out = append(out, MaxHashtagsFilter{Max: *config.MaxHashtags})
+	}
+
+	if config.Spam != nil {
+		if negatable {
+			return nil, errors.New("Filter spam can't be used inside or or not.")
+		}
+		if config.Spam.Threshold < 0 {
+			return nil, errors.New("Filter spam threshold can't be negative.")
+		}
+		spam := NewSpamFilter(config.Spam.Threshold)
+		spam.Rejected = rejected
+		out = append(out, spam)
+	}
+
+	for _, nested := range config.And {
+		if nested == nil {
+			return nil, errors.New("Filter and can't contain null.")
+		}
+		filter, err := nested.build(rejected, negatable)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, filter)
+	}
+
+	if config.Or != nil {
+		if len(config.Or) == 0 {
+			return nil, errors.New("Filter or needs at least one filter.")
+		}
+		var or OrFilter
+		for _, nested := range config.Or {
+			if nested == nil {
+				return nil, errors.New("Filter or can't contain null.")
+			}
+			filter, err := nested.build(rejected, true)
+			if err != nil {
+				return nil, err
+			}
+			or = append(or, filter)
+		}
+		out = append(out, or)
+	}
+
+	if config.Not != nil {
+		filter, err := config.Not.build(rejected, true)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, NotFilter{Inner: filter})
+	}
+
+	if len(out) == 1 {
+		return out[0], nil
+	}
+	return out, nil
+}
@@ -14,3 +14,40 @@ func FilterTweet(tweet *anaconda.Tweet, filterer Filter, out chan<- *anaconda.Tw
 		out <- tweet
 	}
 }
+
+//AndFilter passes tweets that pass all of its filters.  An empty AndFilter passes
+// every tweet.
+type AndFilter []Filter
+
+//Filter returns true if none of the filters reject the tweet.
+func (filters AndFilter) Filter(tweet *anaconda.Tweet) bool {
+	for _, filter := range filters {
+		if !filter.Filter(tweet) {
+			return false
+		}
+	}
+	return true
+}
+
+//OrFilter passes tweets that pass at least one of its filters.
+type OrFilter []Filter
+
+//Filter returns true if any of the filters pass the tweet.
+func (filters OrFilter) Filter(tweet *anaconda.Tweet) bool {
+	for _, filter := range filters {
+		if filter.Filter(tweet) {
+			return true
+		}
+	}
+	return false
+}
+
+//NotFilter passes the tweets its Inner filter rejects.
+type NotFilter struct {
+	Inner Filter
+}
+
+//Filter returns the opposite of the Inner filter.
+func (filter NotFilter) Filter(tweet *anaconda.Tweet) bool {
+	return !filter.Inner.Filter(tweet)
+}
@@ -0,0 +1,105 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//filterTweet decodes a tweet for the filter tests.
+func filterTweet(t *testing.T, raw string) *anaconda.Tweet {
+	var tweet anaconda.Tweet
+	if err := json.Unmarshal([]byte(raw), &tweet); err != nil {
+		t.Fatalf("Failed to decode tweet: %v", err)
+	}
+	return &tweet
+}
+
+func TestTweetFilters(t *testing.T) {
+	tweet := filterTweet(t, `{"id": 1, "lang": "en", "text": "Hiring Go developers #golang #jobs",
+		"user": {"screen_name": "GoJobs", "followers_count": 150, "verified": false},
+		"entities": {"urls": [{"expanded_url": "https://www.jobs.example.com/go"}],
+			"hashtags": [{"text": "golang"}, {"text": "jobs"}]}}`)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		pass   bool
+	}{
+		{"enough followers", MinFollowersFilter{Min: 100}, true},
+		{"too few followers", MinFollowersFilter{Min: 200}, false},
+		{"language", LanguageFilter{Languages: []string{"de", "EN"}}, true},
+		{"other language", LanguageFilter{Languages: []string{"de"}}, false},
+		{"unverified", VerifiedFilter{}, false},
+		{"not a retweet", RetweetFilter{}, true},
+		{"allowed subdomain", DomainFilter{Allow: []string{"example.com"}}, true},
+		{"denied subdomain", DomainFilter{Deny: []string{"example.com"}}, false},
+		{"not allowed", DomainFilter{Allow: []string{"golang.org"}}, false},
+		{"blocked user", UserBlockFilter{ScreenNames: []string{"@gojobs"}}, false},
+		{"other user blocked", UserBlockFilter{ScreenNames: []string{"gopherdaily"}}, true},
+		{"keyword", KeywordFilter{Keywords: []string{"hiring"}}, false},
+		{"no keyword", KeywordFilter{Keywords: []string{"webinar"}}, true},
+		{"hashtags at max", MaxHashtagsFilter{Max: 2}, true},
+		{"too many hashtags", MaxHashtagsFilter{Max: 1}, false},
+		{"and", AndFilter{URLFilter{}, VerifiedFilter{}}, false},
+		{"empty and", AndFilter{}, true},
+		{"or", OrFilter{VerifiedFilter{}, MinFollowersFilter{Min: 100}}, true},
+		{"not", NotFilter{Inner: VerifiedFilter{}}, true},
+	}
+	for _, test := range tests {
+		if pass := test.filter.Filter(tweet); pass != test.pass {
+			t.Errorf("%v: expected %v, got %v", test.name, test.pass, pass)
+		}
+	}
+
+	retweet := filterTweet(t, `{"id": 2, "retweeted_status": {"id": 1}}`)
+	if (RetweetFilter{}).Filter(retweet) {
+		t.Errorf("Expected a retweet to be rejected")
+	}
+}
+
+func TestFilterConfig(t *testing.T) {
+	var config FilterConfig
+	err := json.Unmarshal([]byte(`{"languages": ["en"], "max_hashtags": 0,
+		"not": {"exclude_keywords": ["hiring"]},
+		"or": [{"verified_only": true}, {"min_followers": 100}]}`), &config)
+	if err != nil {
+		t.Fatalf("Failed to decode filter config: %v", err)
+	}
+	filter, err := config.Filter()
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	//not inverts exclude_keywords, so only tweets mentioning hiring pass it.
+	hiring := filterTweet(t, `{"lang": "en", "text": "Hiring gophers", "user": {"followers_count": 150}}`)
+	if !filter.Filter(hiring) {
+		t.Errorf("Expected a tweet passing every setting to pass")
+	}
+	tagged := filterTweet(t, `{"lang": "en", "text": "Hiring gophers #golang",
+		"user": {"followers_count": 150}, "entities": {"hashtags": [{"text": "golang"}]}}`)
+	if filter.Filter(tagged) {
+		t.Errorf("Expected max_hashtags of 0 to reject a tweet with a hashtag")
+	}
+	if filter.Filter(filterTweet(t, `{"lang": "en", "text": "Hiring", "user": {"followers_count": 5}}`)) {
+		t.Errorf("Expected a tweet failing every or filter to be rejected")
+	}
+
+	for _, invalid := range []string{
+		`{"min_followers": -1}`,
+		`{"max_hashtags": -1}`,
+		`{"or": []}`,
+		`{"and": [null]}`,
+		`{"not": {"or": []}}`,
+		`{"spam": {"threshold": -1}}`,
+		`{"not": {"spam": {}}}`,
+		`{"or": [{"verified_only": true}, {"and": [{"spam": {}}]}]}`,
+	} {
+		var config FilterConfig
+		json.Unmarshal([]byte(invalid), &config)
+		if _, err := config.Filter(); err == nil {
+			t.Errorf("Expected %v to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tweetharvest
+
+import "time"
+
+//HarvestCheckpoint records how far the harvests of a query have got.  MaxID is the
+// newest tweet harvested, and the next harvest asks only for tweets after it.
+//
+// If a harvest stops at the page cap before getting back to MaxID, ResumeID is
+// the max_id of the page it would have fetched next and NewestID the newest tweet
+// harvested since MaxID.  The next harvest carries on paging back from ResumeID,
+// and once it gets back to MaxID the checkpoint moves up to NewestID.
+type HarvestCheckpoint struct {
+	Query    string
+	MaxID    int64
+	ResumeID int64
+	NewestID int64
+	Updated  time.Time
+}
+
+const harvestCheckpointKind string = "HarvestCheckpoint"
+
+//first returns true if no harvest of the query has got anywhere yet.
+func (checkpoint *HarvestCheckpoint) first() bool {
+	return checkpoint.MaxID == 0 && checkpoint.ResumeID == 0
+}
+
+//advance moves the checkpoint on after a harvest whose newest tweet was newest,
+// and which would have fetched the page at resumeID next if it hadn't stopped at
+// the page cap.  Only the first harvest of a query skips the tweets past the cap;
+// a later one keeps MaxID and resumes from resumeID, so that none of the tweets
+// since the last harvest are lost.  It returns true if the checkpoint changed.
+func (checkpoint *HarvestCheckpoint) advance(newest, resumeID int64) bool {
+	if checkpoint.NewestID > newest {
+		newest = checkpoint.NewestID
+	}
+
+	if resumeID > 0 && !checkpoint.first() {
+		changed := resumeID != checkpoint.ResumeID || newest != checkpoint.NewestID
+		checkpoint.ResumeID = resumeID
+		checkpoint.NewestID = newest
+		return changed
+	}
+
+	changed := newest > checkpoint.MaxID || checkpoint.ResumeID != 0
+	if newest > checkpoint.MaxID {
+		checkpoint.MaxID = newest
+	}
+	checkpoint.ResumeID = 0
+	checkpoint.NewestID = 0
+	return changed
+}
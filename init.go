@@ -1,21 +1,21 @@
-package tweetharvest
+// +build appengine
 
-import (
-	"net/http"
+package tweetharvest
 
-	"github.com/gorilla/mux"
-)
+import "net/http"
 
 func init() {
-	th := &MapBuilder{}
-	proc := &Reducer{}
-	consume := &FeedProducer{}
-
-	plex := mux.NewRouter()
-	plex.Handle("/map", th)
-	plex.Handle("/reduce", proc)
-	plex.Handle("/consume", consume)
-
-	http.Handle("/", plex)
+	services := Services{
+		Store: DatastoreStore{},
+		Source: TwitterSource{
+			ConsumerKey:       consumerKey,
+			ConsumerSecret:    consumerSecretKey,
+			AccessToken:       accessToken,
+			AccessTokenSecret: accessTokenSecret,
+		},
+		NewContext: AppEngineContext,
+		Resolver:   NewResolver(),
+	}
 
+	http.Handle("/", NewRouter(services))
 }
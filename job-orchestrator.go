@@ -0,0 +1,319 @@
+package tweetharvest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"github.com/gorilla/mux"
+)
+
+//JobOrchestrator runs the map and reduce stages as one harvest cycle, recording
+// each cycle as a JobRun:
+//
+//	GET  /cycle               harvests each topic that is due, then reduces
+//	GET  /jobs?status=failed  lists the runs, newest first
+//	GET  /jobs/{id}           returns a run
+//	POST /jobs/{id}/rerun     re-runs a failed run
+//
+//The reducer runs once every harvest of the cycle has finished, so it no longer
+// has to be timed to follow the harvests.  A cycle with no topics due isn't
+// recorded.  A re-run harvests the topics the failed run didn't, whether or not
+// they are due, and reduces again; each failed run can be re-run once.
+type JobOrchestrator struct {
+	c          context.Context
+	store      Store
+	scheduler  TopicScheduler
+	reducer    Reducer
+	newContext ContextFunc
+}
+
+//defaultJobLimit and maxJobLimit bound the number of runs listed.
+const (
+	defaultJobLimit = 20
+	maxJobLimit     = 500
+)
+
+//jobTimeout is how long a run may be running before it is taken to have stopped
+// without finishing, and is marked as failed.
+const jobTimeout = time.Hour
+
+//Reasons a run can't be re-run.
+var (
+	errNoJobRun     = errors.New("No such job run.")
+	errJobNotFailed = errors.New("Only failed job runs can be re-run.")
+	errJobRerun     = errors.New("The job run has already been re-run.")
+)
+
+//ServeHTTP dispatches on the path and method.
+func (jo JobOrchestrator) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	jo.c = jo.newContext(request)
+	raw, one := mux.Vars(request)["id"]
+
+	var id int64
+	if one {
+		var err error
+		if id, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			http.Error(writer, "Job run IDs are numbers.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch {
+	case request.URL.Path == "/cycle":
+		jo.cycle(writer)
+	case !one && request.Method == "GET":
+		jo.list(writer, request)
+	case one && strings.HasSuffix(request.URL.Path, "/rerun") && request.Method == "POST":
+		jo.rerun(writer, id)
+	case one && !strings.HasSuffix(request.URL.Path, "/rerun") && request.Method == "GET":
+		jo.get(writer, id)
+	default:
+		http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (jo JobOrchestrator) cycle(writer http.ResponseWriter) {
+	now := time.Now()
+	jo.failStaleRuns(now)
+
+	topics, err := jo.store.GetTopics(jo.c)
+	if err != nil {
+		jo.serverError(writer, err)
+		return
+	}
+
+	due := dueTopics(topics, now)
+	if len(due) == 0 {
+		log.Infof(jo.c, "No topics are due.")
+		writeJSON(writer, http.StatusOK, &JobRun{
+			Status:   jobSkipped,
+			Started:  now,
+			Finished: now,
+			Map:      JobStage{Status: jobSkipped},
+			Harvests: []TopicHarvest{},
+			Reduce:   JobStage{Status: jobSkipped},
+		})
+		return
+	}
+
+	run, err := jo.run(due, 0)
+	if err != nil {
+		jo.serverError(writer, err)
+		return
+	}
+	jo.respond(writer, run)
+}
+
+func (jo JobOrchestrator) list(writer http.ResponseWriter, request *http.Request) {
+	limit := defaultJobLimit
+	if raw := request.URL.Query().Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxJobLimit {
+			http.Error(writer, "limit must be a number from 1 to 500.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := jo.store.GetJobRuns(jo.c, request.URL.Query().Get("status"), limit)
+	if err != nil {
+		jo.serverError(writer, err)
+		return
+	}
+	if runs == nil {
+		runs = []*JobRun{}
+	}
+	writeJSON(writer, http.StatusOK, runs)
+}
+
+func (jo JobOrchestrator) get(writer http.ResponseWriter, id int64) {
+	run, err := jo.store.GetJobRun(jo.c, id)
+	if err != nil {
+		jo.serverError(writer, err)
+		return
+	}
+	if run == nil {
+		http.Error(writer, "No such job run.", http.StatusNotFound)
+		return
+	}
+	writeJSON(writer, http.StatusOK, run)
+}
+
+//rerun re-runs a failed run.  The run is marked as re-run in the same transaction
+// that checks it, so that two requests can't both re-run it.
+func (jo JobOrchestrator) rerun(writer http.ResponseWriter, id int64) {
+	var queries []string
+	err := jo.store.UpdateJobRun(jo.c, id, func(failed *JobRun, exists bool) error {
+		switch {
+		case !exists:
+			return errNoJobRun
+		case failed.Status != jobFailed:
+			return errJobNotFailed
+		case failed.Rerun:
+			return errJobRerun
+		}
+		queries = failed.failedQueries()
+		failed.Rerun = true
+		return nil
+	})
+	switch err {
+	case nil:
+	case errNoJobRun:
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	case errJobNotFailed, errJobRerun:
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	default:
+		jo.serverError(writer, err)
+		return
+	}
+
+	//Topics deleted since the run are left out.
+	var topics []*Topic
+	for _, query := range queries {
+		topic, err := jo.store.GetTopic(jo.c, query)
+		if err != nil {
+			jo.release(id)
+			jo.serverError(writer, err)
+			return
+		}
+		if topic != nil {
+			topics = append(topics, topic)
+		}
+	}
+
+	log.Infof(jo.c, "Re-running job %v", id)
+	run, err := jo.run(topics, id)
+	if err != nil {
+		jo.release(id)
+		jo.serverError(writer, err)
+		return
+	}
+	jo.respond(writer, run)
+}
+
+//release clears the mark left by rerun on a failed run whose re-run couldn't be
+// started, so that it can be re-run again.
+func (jo JobOrchestrator) release(id int64) {
+	err := jo.store.UpdateJobRun(jo.c, id, func(failed *JobRun, exists bool) error {
+		failed.Rerun = false
+		return nil
+	})
+	if err != nil {
+		log.Errorf(jo.c, "Failed to release job run %v for another re-run. %v", id, err.Error())
+	}
+}
+
+//failStaleRuns marks the runs that have been running for longer than jobTimeout
+// as failed, so that they can be re-run.  The cycle carries on if they can't be.
+func (jo JobOrchestrator) failStaleRuns(now time.Time) {
+	runs, err := jo.store.GetJobRuns(jo.c, jobRunning, maxJobLimit)
+	if err != nil {
+		log.Errorf(jo.c, "Failed to list running job runs. %v", err.Error())
+		return
+	}
+	for _, stale := range runs {
+		if now.Sub(stale.Started) < jobTimeout {
+			continue
+		}
+		err := jo.store.UpdateJobRun(jo.c, stale.ID, func(run *JobRun, exists bool) error {
+			if run.Status == jobRunning {
+				run.abandon(now)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf(jo.c, "Failed to mark job run %v as failed. %v", stale.ID, err.Error())
+			continue
+		}
+		log.Infof(jo.c, "Job run %v stopped without finishing, marked as failed.", stale.ID)
+	}
+}
+
+//run harvests the topics, then reduces the tweets harvested, recording the
+// JobRun as it goes.  The reduce stage is skipped if nothing was harvested,
+// unless the run is a re-run, whose reduce may be the stage that failed.
+func (jo JobOrchestrator) run(topics []*Topic, retryOf int64) (*JobRun, error) {
+	now := time.Now()
+	run := &JobRun{Status: jobRunning, RetryOf: retryOf, Started: now, Harvests: []TopicHarvest{}}
+	for _, topic := range topics {
+		run.Harvests = append(run.Harvests, TopicHarvest{Query: topic.Query, Status: harvestPending})
+	}
+	run.Map.start(now)
+	if err := jo.store.PutJobRun(jo.c, run); err != nil {
+		log.Errorf(jo.c, "Failed to record job run. %v", err.Error())
+		return nil, err
+	}
+
+	ts := jo.scheduler
+	ts.c = jo.c
+	run.Harvests = ts.harvestTopics(topics, now)
+	harvested := 0
+	for _, harvest := range run.Harvests {
+		switch harvest.Status {
+		case harvestDone:
+			harvested++
+		case harvestFailed:
+			run.Map.Errors = append(run.Map.Errors, harvest.Query+": "+harvest.Error)
+		}
+	}
+	run.Map.finish(time.Now())
+	jo.save(run)
+
+	if harvested == 0 && retryOf == 0 {
+		run.Reduce.Status = jobSkipped
+	} else {
+		run.Reduce.start(time.Now())
+		reduce := jo.reducer
+		reduce.c = jo.c
+		summary, err := reduce.reduce()
+		run.Reduced = summary
+		if err != nil {
+			run.Reduce.Errors = append(run.Reduce.Errors, err.Error())
+		}
+		if summary.Failed > 0 {
+			run.Reduce.Errors = append(run.Reduce.Errors,
+				fmt.Sprintf("%v scores failed to be written.", summary.Failed))
+		}
+		run.Reduce.finish(time.Now())
+	}
+
+	run.Finished = time.Now()
+	run.Status = jobSucceeded
+	if run.Map.Status == jobFailed || run.Reduce.Status == jobFailed {
+		run.Status = jobFailed
+	}
+	jo.save(run)
+	log.Infof(jo.c, "Job %v %v: %v of %v topics harvested, %v tweets reduced.",
+		run.ID, run.Status, harvested, len(run.Harvests), run.Reduced.Tweets)
+	return run, nil
+}
+
+//save records the progress of the run.  A run that can't be saved carries on,
+// since the harvests and scores it records are already written.
+func (jo JobOrchestrator) save(run *JobRun) {
+	if err := jo.store.PutJobRun(jo.c, run); err != nil {
+		log.Errorf(jo.c, "Failed to record job run %v. %v", run.ID, err.Error())
+	}
+}
+
+//respond writes the run, with an error status if it failed so that cron logs it.
+func (jo JobOrchestrator) respond(writer http.ResponseWriter, run *JobRun) {
+	status := http.StatusOK
+	if run.Status == jobFailed {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(writer, status, run)
+}
+
+func (jo JobOrchestrator) serverError(writer http.ResponseWriter, err error) {
+	log.Errorf(jo.c, "Job store error. %v", err.Error())
+	http.Error(writer, err.Error(), http.StatusInternalServerError)
+}
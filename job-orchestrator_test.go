@@ -0,0 +1,194 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//queryFailingSource is a TweetSource that fails every search for one query and
+// finds nothing for the rest.
+type queryFailingSource struct {
+	failing string
+}
+
+func (source queryFailingSource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	if query == source.failing {
+		return anaconda.SearchResponse{}, errors.New("search unavailable")
+	}
+	return anaconda.SearchResponse{}, nil
+}
+
+func TestJobOrchestrator(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><head><title>Page %v</title></head></html>", r.URL.Path)
+	}))
+	defer pages.Close()
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, pages.URL+"/a", "golang", now.Add(-time.Hour)),
+		testLinkTweet(2, pages.URL+"/b", "golang", now.Add(-time.Hour)),
+	})
+	for _, query := range []string{"golang", "rust"} {
+		store.PutTopic(c, &Topic{Query: query, Interval: time.Hour, Enabled: true})
+	}
+
+	send := func(router http.Handler, method string, target string) (int, *JobRun) {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+		run := &JobRun{}
+		json.Unmarshal(response.Body.Bytes(), run)
+		return response.Code, run
+	}
+
+	//One harvest fails, but the reducer still runs once the harvests are done.
+	router := NewRouter(testServices(store, queryFailingSource{failing: "rust"}))
+	code, run := send(router, "GET", "/cycle")
+	if code != http.StatusInternalServerError || run.Status != jobFailed || run.ID == 0 {
+		t.Fatalf("Expected a failed run, got %v: %+v", code, run)
+	}
+	if len(run.Harvests) != 2 || run.Harvests[0].Status != harvestDone || run.Harvests[1].Status != harvestFailed ||
+		run.Map.Status != jobFailed || len(run.Map.Errors) != 1 {
+		t.Errorf("Expected the rust harvest to fail the map stage, got %+v", run)
+	}
+	if run.Reduce.Status != jobSucceeded || run.Reduced.Tweets != 2 || run.Reduce.Started.Before(run.Map.Finished) {
+		t.Errorf("Expected the reduce stage to follow the map stage, got %+v", run)
+	}
+	failed := run.ID
+
+	var runs []*JobRun
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/jobs?status=failed", nil))
+	json.NewDecoder(response.Body).Decode(&runs)
+	if len(runs) != 1 || runs[0].ID != failed {
+		t.Errorf("Expected the failed run to be listed, got %+v", runs)
+	}
+
+	//The failed topic is backed off rather than tried again on the next cycle.
+	if code, run = send(router, "GET", "/cycle"); code != http.StatusOK || run.Status != jobSkipped {
+		t.Errorf("Expected the failed topic to be backed off, got %v: %+v", code, run)
+	}
+	if topic, _ := store.GetTopic(c, "rust"); topic.Failures != 1 || !topic.LastHarvest.IsZero() {
+		t.Errorf("Expected the failed harvest to be recorded, got %+v", topic)
+	}
+
+	//Once it is due again, with nothing harvested there is nothing to reduce.
+	store.UpdateTopic(c, "rust", func(topic *Topic, exists bool) error {
+		topic.LastFailure = topic.LastFailure.Add(-time.Hour)
+		return nil
+	})
+	if code, run = send(router, "GET", "/cycle"); code != http.StatusInternalServerError ||
+		len(run.Harvests) != 1 || run.Harvests[0].Query != "rust" || run.Reduce.Status != jobSkipped {
+		t.Errorf("Expected only the failed topic to be due and no reduce, got %v: %+v", code, run)
+	}
+
+	//Once the search works again the failed run is re-run.
+	router = NewRouter(testServices(store, queryFailingSource{}))
+	code, run = send(router, "POST", fmt.Sprintf("/jobs/%v/rerun", failed))
+	if code != http.StatusOK || run.Status != jobSucceeded || run.RetryOf != failed ||
+		len(run.Harvests) != 1 || run.Harvests[0].Query != "rust" || run.Reduce.Status != jobSucceeded {
+		t.Errorf("Expected the re-run to harvest rust, got %v: %+v", code, run)
+	}
+	if code, _ = send(router, "POST", fmt.Sprintf("/jobs/%v/rerun", run.ID)); code != http.StatusConflict {
+		t.Errorf("Expected 409 re-running a run that succeeded, got %v", code)
+	}
+	if code, _ = send(router, "POST", fmt.Sprintf("/jobs/%v/rerun", failed)); code != http.StatusConflict {
+		t.Errorf("Expected 409 re-running a run that has been re-run, got %v", code)
+	}
+	if code, run = send(router, "GET", fmt.Sprintf("/jobs/%v", failed)); code != http.StatusOK || run.ID != failed ||
+		!run.Rerun {
+		t.Errorf("Expected the failed run to be marked as re-run, got %v: %+v", code, run)
+	}
+	if code, _ = send(router, "GET", "/jobs/999"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %v", code)
+	}
+	if code, _ = send(router, "POST", "/jobs/999/rerun"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 re-running a missing run, got %v", code)
+	}
+
+	//With every topic harvested, the next cycle has nothing to do and isn't
+	// recorded.
+	before, _ := store.GetJobRuns(c, "", maxJobLimit)
+	if code, run = send(router, "GET", "/cycle"); code != http.StatusOK || run.Status != jobSkipped || run.ID != 0 {
+		t.Errorf("Expected a skipped cycle, got %v: %+v", code, run)
+	}
+	if after, _ := store.GetJobRuns(c, "", maxJobLimit); len(after) != len(before) {
+		t.Errorf("Expected the idle cycle not to be recorded, got %v runs after %v", len(after), len(before))
+	}
+}
+
+func TestJobOrchestratorFailsStaleRuns(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+	store.PutTopic(c, &Topic{Query: "golang", Interval: time.Hour, Enabled: true, LastHarvest: now})
+
+	//The instance running the first run stopped during its harvests; the second
+	// is still within its time.
+	stale := &JobRun{Status: jobRunning, Started: now.Add(-2 * jobTimeout),
+		Harvests: []TopicHarvest{{Query: "golang", Status: harvestPending}}}
+	stale.Map.start(stale.Started)
+	running := &JobRun{Status: jobRunning, Started: now.Add(-time.Minute)}
+	store.PutJobRun(c, stale)
+	store.PutJobRun(c, running)
+
+	router := NewRouter(testServices(store, queryFailingSource{}))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/cycle", nil))
+
+	run, _ := store.GetJobRun(c, stale.ID)
+	if run.Status != jobFailed || run.Map.Status != jobFailed || len(run.Map.Errors) != 1 || run.Finished.IsZero() {
+		t.Errorf("Expected the stale run to be marked as failed, got %+v", run)
+	}
+	if run, _ = store.GetJobRun(c, running.ID); run.Status != jobRunning {
+		t.Errorf("Expected the recent run to be left running, got %+v", run)
+	}
+
+	//The harvests the stale run never finished are re-run.
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("POST", fmt.Sprintf("/jobs/%v/rerun", stale.ID), nil))
+	rerun := &JobRun{}
+	json.Unmarshal(response.Body.Bytes(), rerun)
+	if response.Code != http.StatusOK || len(rerun.Harvests) != 1 || rerun.Harvests[0].Query != "golang" ||
+		rerun.Harvests[0].Status != harvestDone {
+		t.Errorf("Expected the re-run to harvest golang, got %v: %+v", response.Code, rerun)
+	}
+}
+
+//editingSource is a TweetSource that renames the topic it searches for, as an
+// edit through /topics made during the harvest would, and finds nothing.
+type editingSource struct {
+	store Store
+}
+
+func (source editingSource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	source.store.UpdateTopic(c, query, func(topic *Topic, exists bool) error {
+		topic.Title = "Renamed"
+		return nil
+	})
+	return anaconda.SearchResponse{}, nil
+}
+
+func TestJobOrchestratorKeepsTopicEdits(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	store.PutTopic(c, &Topic{Query: "golang", Title: "Go", Interval: time.Hour, Enabled: true})
+
+	router := NewRouter(testServices(store, editingSource{store: store}))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/cycle", nil))
+
+	topic, _ := store.GetTopic(c, "golang")
+	if topic.Title != "Renamed" || topic.LastHarvest.IsZero() {
+		t.Errorf("Expected the harvest to be recorded without undoing the edit, got %+v", topic)
+	}
+}
@@ -0,0 +1,122 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+//Statuses of a JobRun and of its stages.
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobSkipped   = "skipped"
+)
+
+//JobRun records one harvest cycle run by the JobOrchestrator: the map stage,
+// which harvests each topic that is due, and the reduce stage that follows it.
+// RetryOf is the ID of the failed run this one re-ran, if any, and Rerun is set
+// on a failed run once it has been re-run, so that it is only re-run once.
+type JobRun struct {
+	ID       int64     `json:"id"`
+	Status   string    `json:"status"`
+	RetryOf  int64     `json:"retry_of,omitempty"`
+	Rerun    bool      `json:"rerun,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	Map      JobStage       `json:"map"`
+	Harvests []TopicHarvest `json:"harvests"`
+
+	Reduce  JobStage      `json:"reduce"`
+	Reduced ReduceSummary `json:"reduced"`
+}
+
+//JobStage records the run of one stage of a JobRun.
+type JobStage struct {
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+const jobRunKind string = "JobRun"
+
+//start marks the stage as running from now.
+func (stage *JobStage) start(now time.Time) {
+	stage.Status = jobRunning
+	stage.Started = now
+}
+
+//finish marks the stage as done at now, failed if it recorded any errors.
+func (stage *JobStage) finish(now time.Time) {
+	stage.Finished = now
+	stage.Status = jobSucceeded
+	if len(stage.Errors) > 0 {
+		stage.Status = jobFailed
+	}
+}
+
+//abandon marks a run that stopped without finishing, e.g. with the instance that
+// was running it, as failed at now, along with the stage it stopped in.
+func (run *JobRun) abandon(now time.Time) {
+	for _, stage := range []*JobStage{&run.Map, &run.Reduce} {
+		if stage.Status == jobRunning {
+			stage.Errors = append(stage.Errors, "The run stopped before the stage finished.")
+			stage.finish(now)
+		}
+	}
+	run.Status = jobFailed
+	run.Finished = now
+}
+
+//failedQueries returns the queries the run didn't harvest, because their harvest
+// failed, was deferred or never finished, which are the ones a re-run harvests
+// again.
+func (run *JobRun) failedQueries() []string {
+	var queries []string
+	for _, harvest := range run.Harvests {
+		if harvest.Status != harvestDone {
+			queries = append(queries, harvest.Query)
+		}
+	}
+	return queries
+}
+
+//copy returns a copy of the run that shares none of its slices.
+func (run *JobRun) copy() *JobRun {
+	found := *run
+	found.Harvests = append([]TopicHarvest(nil), run.Harvests...)
+	found.Map.Errors = append([]string(nil), run.Map.Errors...)
+	found.Reduce.Errors = append([]string(nil), run.Reduce.Errors...)
+	return &found
+}
+
+//Load fulfills the PropertyLoadSaver interface, restoring the run from the JSON
+// copy written by Save.
+func (run *JobRun) Load(properties []datastore.Property) error {
+	for _, property := range properties {
+		if property.Name == "Run" {
+			raw, _ := property.Value.([]byte)
+			return json.Unmarshal(raw, run)
+		}
+	}
+	return nil
+}
+
+//Save fulfills the PropertyLoadSaver interface.  The stages nest slices the
+// datastore can't store, so the whole run is kept as unindexed JSON and only the
+// fields runs are listed by are written as properties of their own.
+func (run *JobRun) Save() ([]datastore.Property, error) {
+	raw, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	return []datastore.Property{
+		{Name: "Status", Value: run.Status},
+		{Name: "Started", Value: run.Started},
+		{Name: "Run", Value: raw, NoIndex: true},
+	}, nil
+}
@@ -0,0 +1,83 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+//LinkHandler serves the /links endpoint, which shows each topic a link has been
+// shared in and how it scores there:
+//
+//	GET /links?address=https://blog.golang.org/go1.5
+//
+//The address is canonicalized first, so any form of it that was tweeted finds
+// the same scores.
+type LinkHandler struct {
+	c          context.Context
+	store      Store
+	newContext ContextFunc
+}
+
+//addressParam is the parameter holding the address of a link.
+const addressParam string = "address"
+
+//linkReport lists the topics a link has been scored under.
+type linkReport struct {
+	Address string      `json:"address"`
+	Title   string      `json:"title"`
+	Topics  []linkTopic `json:"topics"`
+}
+
+//linkTopic is the score of a link under one topic.
+type linkTopic struct {
+	Query      string    `json:"query"`
+	Score      int       `json:"score"`
+	Tweets     int       `json:"tweets"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastActive time.Time `json:"last_active"`
+}
+
+//ServeHTTP responds with the scores of the address under every query.
+func (lh LinkHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	lh.c = lh.newContext(request)
+	if request.Method != "GET" {
+		http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw := request.URL.Query().Get(addressParam)
+	if raw == "" {
+		http.Error(writer, "No address given.", http.StatusBadRequest)
+		return
+	}
+	address := DefaultCanonicalizer.Canonical(raw)
+
+	scores, err := lh.store.AddressScores(lh.c, address)
+	if err != nil {
+		log.Errorf(lh.c, "Failed to get scores for %v. %v", address, err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(scores) == 0 {
+		http.Error(writer, "Link has not been scored.", http.StatusNotFound)
+		return
+	}
+
+	report := linkReport{Address: address, Topics: []linkTopic{}}
+	for _, score := range scores {
+		if report.Title == "" {
+			report.Title = score.Title
+		}
+		report.Topics = append(report.Topics, linkTopic{
+			Query:      score.Query,
+			Score:      score.Score,
+			Tweets:     len(score.TweetIDs),
+			FirstSeen:  score.FirstSeen,
+			LastActive: score.LastActive,
+		})
+	}
+	writeJSON(writer, http.StatusOK, report)
+}
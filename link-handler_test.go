@@ -0,0 +1,73 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLinkScoredPerTopic(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Shared</title></head><body></body></html>`)
+	}))
+	defer pages.Close()
+	shared := pages.URL + "/shared"
+
+	//The article is tweeted twice under golang but once under rust.
+	again := testLinkTweet(2, shared, "golang", now)
+	again.User.Id = 42
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, shared, "golang", now.Add(-time.Hour)),
+		again,
+		testLinkTweet(3, shared, "rust", now.Add(-2*time.Hour)),
+	})
+
+	router := NewRouter(testServices(store, nil))
+	runReduce(t, router)
+
+	golang, _ := store.RecentScores(c, "golang", now.Add(-24*time.Hour))
+	rust, _ := store.RecentScores(c, "rust", now.Add(-24*time.Hour))
+	if len(golang) != 1 || golang[0].Score != 4 || len(rust) != 1 || rust[0].Score != 2 {
+		t.Fatalf("Expected the article scored separately in each topic, got %+v and %+v", golang, rust)
+	}
+
+	send := func(target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", target, nil))
+		return response
+	}
+
+	response := send("/links?address=" + url.QueryEscape(shared+"?utm_source=twitter"))
+	var report linkReport
+	json.NewDecoder(response.Body).Decode(&report)
+	if response.Code != http.StatusOK || report.Address != DefaultCanonicalizer.Canonical(shared) ||
+		report.Title != "Shared" || len(report.Topics) != 2 {
+		t.Fatalf("Expected both topics for the article, got %v: %+v", response.Code, report)
+	}
+	golangTopic, rustTopic := report.Topics[0], report.Topics[1]
+	if golangTopic.Query != "golang" || golangTopic.Score != 4 || golangTopic.Tweets != 2 ||
+		!golangTopic.LastActive.Equal(now) {
+		t.Errorf("Unexpected golang topic %+v", golangTopic)
+	}
+	if rustTopic.Query != "rust" || rustTopic.Score != 2 || rustTopic.Tweets != 1 ||
+		!rustTopic.FirstSeen.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("Unexpected rust topic %+v", rustTopic)
+	}
+
+	if response = send("/links?address=" + url.QueryEscape(pages.URL+"/other")); response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a link that hasn't been scored, got %v", response.Code)
+	}
+	if response = send("/links"); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an address, got %v", response.Code)
+	}
+}
@@ -1,18 +1,26 @@
 package tweetharvest
 
 import (
-	"reflect"
+	"encoding/json"
+	"errors"
 
 	"google.golang.org/appengine/datastore"
 
 	"github.com/ChimeraCoder/anaconda"
 )
 
-//LinkTweet contains the address extracted from a tweet and the original tweet
+//LinkTweet contains the address extracted from a tweet and the original tweet.
+// ShortAddress is the address as it was tweeted, OriginalAddress is the address
+// of the article it leads to, used to display it, and Address is the canonical
+// form of that, used to score it.  Sequence is set by the store when the tweet is
+// written, and increases with each tweet written.
 type LinkTweet struct {
 	Address string
 	anaconda.Tweet
-	Query string
+	Query           string
+	OriginalAddress string
+	ShortAddress    string
+	Sequence        int64
 }
 
 //LinkTweets is a sortable collection of LinkTweet structs
@@ -23,45 +31,92 @@ const linkTweetKind string = "LinkTweet"
 //LinkTweetFrom creates a LinkTweet by extracting an address from the given
 //Tweet.  If no link is found then an error is returned
 func LinkTweetFrom(tweet anaconda.Tweet) (LinkTweet, error) {
+	if len(tweet.Entities.Urls) == 0 {
+		return LinkTweet{}, errors.New("Tweet has no links.")
+	}
 
 	//log.Infof(c, "Extracted Link: %v", rawAddr)
+	rawAddr := tweet.Entities.Urls[0].Expanded_url
 	linkTweet := LinkTweet{
-		Address: tweet.Entities.Urls[0].Expanded_url,
-		Tweet:   tweet,
+		Address:         DefaultCanonicalizer.Canonical(rawAddr),
+		Tweet:           tweet,
+		OriginalAddress: rawAddr,
+		ShortAddress:    rawAddr,
 	}
 	return linkTweet, nil
 }
 
-func (linkTweet LinkTweet) getScore() int {
-	return linkTweet.FavoriteCount + 1
+//LinkTweetsFrom creates a LinkTweet for each distinct canonical address linked
+// from the given Tweet.
+func LinkTweetsFrom(tweet anaconda.Tweet) LinkTweets {
+	var out LinkTweets
+	seen := make(map[string]bool)
+	for _, url := range tweet.Entities.Urls {
+		if url.Expanded_url == "" {
+			continue
+		}
+		address := DefaultCanonicalizer.Canonical(url.Expanded_url)
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+		out = append(out, &LinkTweet{
+			Address:         address,
+			Tweet:           tweet,
+			OriginalAddress: url.Expanded_url,
+			ShortAddress:    url.Expanded_url,
+		})
+	}
+	return out
 }
 
-//Load fulfills the PropertyLoadSaver interface
-func (linkTweet *LinkTweet) Load(in <-chan datastore.Property) error {
-	propertyList := []datastore.Property{}
-	for x := range in {
-		propertyList = append(propertyList, x)
-	}
-	if err := datastore.LoadStruct(linkTweet, propertyList); err != nil {
-		return err
+//Load fulfills the PropertyLoadSaver interface.  The tweet itself is restored
+// from the JSON copy written by Save.
+func (linkTweet *LinkTweet) Load(properties []datastore.Property) error {
+	for _, property := range properties {
+		switch property.Name {
+		case "Address":
+			linkTweet.Address, _ = property.Value.(string)
+		case "Query":
+			linkTweet.Query, _ = property.Value.(string)
+		case "OriginalAddress":
+			linkTweet.OriginalAddress, _ = property.Value.(string)
+		case "ShortAddress":
+			linkTweet.ShortAddress, _ = property.Value.(string)
+		case "Sequence":
+			linkTweet.Sequence, _ = property.Value.(int64)
+		case "Tweet":
+			raw, _ := property.Value.([]byte)
+			if err := json.Unmarshal(raw, &linkTweet.Tweet); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-//Save fullfills the PropertyLoadSaver interface
-func (linkTweet *LinkTweet) Save(out chan<- datastore.Property) error {
-	st := reflect.TypeOf(linkTweet)
-	for x := 0; x < st.NumField(); x++ {
-		//Hashtags is a slice, inside a slice, and we we don't need it at the moment
-		if !st.Field(x).Anonymous && st.Field(x).Name == "Entities" {
-			out <- datastore.Property{
-				Name:  st.Field(x).Name,
-				Value: reflect.ValueOf(st.Field(x)),
-			}
-		}
+//Save fullfills the PropertyLoadSaver interface.  anaconda.Tweet nests structs
+// the datastore can't store, so the whole tweet is kept as unindexed JSON and
+// only the fields we query on are written as properties of their own.
+func (linkTweet *LinkTweet) Save() ([]datastore.Property, error) {
+	raw, err := json.Marshal(linkTweet.Tweet)
+	if err != nil {
+		return nil, err
 	}
+	created, _ := linkTweet.CreatedAtTime()
 
-	return nil
+	return []datastore.Property{
+		{Name: "Address", Value: linkTweet.Address},
+		{Name: "Query", Value: linkTweet.Query},
+		{Name: "OriginalAddress", Value: linkTweet.OriginalAddress, NoIndex: true},
+		{Name: "ShortAddress", Value: linkTweet.ShortAddress, NoIndex: true},
+		{Name: "Sequence", Value: linkTweet.Sequence},
+		{Name: "TweetID", Value: linkTweet.Id},
+		{Name: "CreatedTime", Value: created},
+		{Name: "Text", Value: linkTweet.Text},
+		{Name: "User", Value: linkTweet.User.ScreenName},
+		{Name: "Tweet", Value: raw, NoIndex: true},
+	}, nil
 }
 
 //Len returns the length of the collection
@@ -71,7 +126,7 @@ func (s LinkTweets) Len() int {
 
 //Less compares two items in the slice based on the Tweet ID
 func (s LinkTweets) Less(i, j int) bool {
-	return s[i].Id < s[i].Id
+	return s[i].Id < s[j].Id
 }
 
 //Swap changes the position of two items in the collection
@@ -0,0 +1,117 @@
+//Package log provides the leveled logging functions used by TweetHarvest.  They
+// mirror google.golang.org/appengine/log, but write to whichever Logger has been
+// attached to the context, so the same handlers can log through App Engine or
+// to a plain io.Writer when running standalone.
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+
+	aelog "google.golang.org/appengine/log"
+)
+
+//Logger receives the messages logged with a context.
+type Logger interface {
+	Debugf(c context.Context, format string, args ...interface{})
+	Infof(c context.Context, format string, args ...interface{})
+	Warningf(c context.Context, format string, args ...interface{})
+	Errorf(c context.Context, format string, args ...interface{})
+}
+
+//loggerKey is the context key a Logger is stored under.
+type loggerKey struct{}
+
+//Default is used for contexts that don't have a Logger attached.
+var Default Logger = NewStdLogger(os.Stderr)
+
+//WithLogger returns a copy of c that logs to logger.
+func WithLogger(c context.Context, logger Logger) context.Context {
+	return context.WithValue(c, loggerKey{}, logger)
+}
+
+//FromContext returns the Logger attached to c, or Default if there is none.
+func FromContext(c context.Context) Logger {
+	if logger, ok := c.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return Default
+}
+
+//Debugf formats its arguments and logs them at debug level.
+func Debugf(c context.Context, format string, args ...interface{}) {
+	FromContext(c).Debugf(c, format, args...)
+}
+
+//Infof formats its arguments and logs them at info level.
+func Infof(c context.Context, format string, args ...interface{}) {
+	FromContext(c).Infof(c, format, args...)
+}
+
+//Warningf formats its arguments and logs them at warning level.
+func Warningf(c context.Context, format string, args ...interface{}) {
+	FromContext(c).Warningf(c, format, args...)
+}
+
+//Errorf formats its arguments and logs them at error level.
+func Errorf(c context.Context, format string, args ...interface{}) {
+	FromContext(c).Errorf(c, format, args...)
+}
+
+//StdLogger writes messages through a standard library logger, prefixed with
+// their level.
+type StdLogger struct {
+	logger *stdlog.Logger
+}
+
+//NewStdLogger returns a StdLogger that writes to w.
+func NewStdLogger(w io.Writer) StdLogger {
+	return StdLogger{logger: stdlog.New(w, "", stdlog.LstdFlags)}
+}
+
+//Debugf logs at debug level.
+func (l StdLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	l.logger.Print("DEBUG: " + fmt.Sprintf(format, args...))
+}
+
+//Infof logs at info level.
+func (l StdLogger) Infof(c context.Context, format string, args ...interface{}) {
+	l.logger.Print("INFO: " + fmt.Sprintf(format, args...))
+}
+
+//Warningf logs at warning level.
+func (l StdLogger) Warningf(c context.Context, format string, args ...interface{}) {
+	l.logger.Print("WARNING: " + fmt.Sprintf(format, args...))
+}
+
+//Errorf logs at error level.
+func (l StdLogger) Errorf(c context.Context, format string, args ...interface{}) {
+	l.logger.Print("ERROR: " + fmt.Sprintf(format, args...))
+}
+
+//AppEngine sends messages to the App Engine request log.  It only works with
+// contexts created by appengine.NewContext.
+type AppEngine struct{}
+
+//Debugf logs at debug level.
+func (AppEngine) Debugf(c context.Context, format string, args ...interface{}) {
+	aelog.Debugf(c, format, args...)
+}
+
+//Infof logs at info level.
+func (AppEngine) Infof(c context.Context, format string, args ...interface{}) {
+	aelog.Infof(c, format, args...)
+}
+
+//Warningf logs at warning level.
+func (AppEngine) Warningf(c context.Context, format string, args ...interface{}) {
+	aelog.Warningf(c, format, args...)
+}
+
+//Errorf logs at error level.
+func (AppEngine) Errorf(c context.Context, format string, args ...interface{}) {
+	aelog.Errorf(c, format, args...)
+}
@@ -1,115 +1,307 @@
 package tweetharvest
 
 import (
+	"context"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
-	"golang.org/x/net/context"
-
+	"github.com/AndyNortrup/TweetHarvest/log"
 	"github.com/ChimeraCoder/anaconda"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 //MapBuilder is a microservice that querries the Twitter API and gets copies
 // of all of the tweets with a specified string from the query string.
 type MapBuilder struct {
-	c     context.Context
-	query string
+	c          context.Context
+	query      string
+	store      Store
+	source     TweetSource
+	resolver   *Resolver
+	maxPages   int
+	newContext ContextFunc
 }
 
 //ServeHTTP recives and processes a request from the web.  Expects a parameter
 //q which is the query string.
 func (mb MapBuilder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	//Create a context
-	mb.c = appengine.NewContext(request)
-	log.Infof(mb.c, "Starting Tweet Harvest.")
+	mb.c = mb.newContext(request)
 
 	//Get the query string and validate that it is not an error
 	query, err := getQuery(request, mb.c)
 	if err != nil {
 		log.Errorf(mb.c, "Failed to get query from querystring.")
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	summary, err := mb.harvest(query)
+	if limitErr, ok := err.(*RateLimitError); ok {
+		writer.Header().Set("Retry-After", retryAfter(limitErr.NextAllowed, time.Now()))
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	writeJSON(writer, http.StatusOK, summary)
+}
+
+//HarvestSummary reports the work done by a harvest.
+type HarvestSummary struct {
+	Query     string `json:"query"`
+	Fetched   int    `json:"fetched"`
+	WithLinks int    `json:"with_links"`
+	Filtered  int    `json:"filtered"`
+	Spam      int    `json:"spam"`
+	Stored    int    `json:"stored"`
+}
+
+//harvest gets the tweets for the query that are newer than its HarvestCheckpoint
+// from the TweetSource, writes a LinkTweet for each address they link to into the
+// store and moves the checkpoint up to the newest of them.  If the search endpoint
+// has turned us away, a RateLimitError is returned without searching.
+func (mb MapBuilder) harvest(query string) (HarvestSummary, error) {
+	log.Infof(mb.c, "Starting Tweet Harvest.")
 	mb.query = query
+	summary := HarvestSummary{Query: query}
 
-	cutoff := getNewestTweet(mb.c)
-	log.Infof(mb.c, "Newest Tweet in datastore is dated: %v", cutoff.String())
+	filter, err := mb.topicFilter(query, &summary)
+	if err != nil {
+		log.Errorf(mb.c, "Failed to get the filters for %v. %v", query, err.Error())
+		return summary, err
+	}
+
+	checkpoint, err := mb.store.GetHarvestCheckpoint(mb.c, query)
+	if err != nil {
+		log.Errorf(mb.c, "Failed to get the harvest checkpoint for %v. %v", query, err.Error())
+		return summary, err
+	}
+	if checkpoint == nil {
+		checkpoint = &HarvestCheckpoint{Query: query}
+	}
+
+	limit, err := mb.store.GetRateLimit(mb.c, searchEndpoint)
+	if err != nil {
+		log.Errorf(mb.c, "Failed to get the rate limit of %v. %v", searchEndpoint, err.Error())
+		return summary, err
+	}
+	if limit == nil {
+		limit = &RateLimit{Endpoint: searchEndpoint}
+	}
+	if err := limit.allowed(time.Now()); err != nil {
+		log.Infof(mb.c, "Deferring harvest of %v. %v", query, err.Error())
+		return summary, err
+	}
 
 	rawTweets := make(chan anaconda.Tweet)
-	shortLinkTweets := make(chan anaconda.Tweet)
-	//longLinkTweets := make(chan LinkTweet, 15)
+	linkTweets := make(chan anaconda.Tweet)
 
-	retriever := &TweetRetriever{context: mb.c, out: rawTweets}
+	maxPages := mb.maxPages
+	if maxPages == 0 {
+		maxPages = defaultMaxPages
+	}
+	retriever := &TweetRetriever{
+		context:  mb.c,
+		out:      rawTweets,
+		source:   mb.source,
+		maxPages: maxPages,
+		limit:    limit,
+	}
 
+	//Each stage writes its own fields of the summary, which is read once all
+	// of the stages are done.
 	var wg sync.WaitGroup
-	wg.Add(4)
-	go retriever.getTweets(query, cutoff, &wg)
-	go mb.extractLinks(rawTweets, shortLinkTweets, &wg)
-
+	wg.Add(3)
+	go retriever.getTweets(query, checkpoint.MaxID, checkpoint.ResumeID, &wg)
+	go mb.extractLinks(rawTweets, linkTweets, filter, &summary, &wg)
+	var storeErr error
+	go func() {
+		defer wg.Done()
+		storeErr = mb.writeLinkTweet(linkTweets, &summary)
+	}()
 	wg.Wait()
-	writer.WriteHeader(http.StatusOK)
+
+	if err := mb.store.PutRateLimit(mb.c, limit); err != nil {
+		log.Errorf(mb.c, "Failed to save the rate limit of %v. %v", searchEndpoint, err.Error())
+	}
+	if storeErr != nil {
+		return summary, storeErr
+	}
+
+	//If the search failed part way through, the tweets between the pages that were
+	// fetched and the checkpoint haven't been seen yet, so the checkpoint stays
+	// where it was for the next harvest to try again.
+	if retriever.err != nil {
+		return summary, retriever.err
+	}
+	first := checkpoint.first()
+	if checkpoint.advance(retriever.maxID, retriever.resumeID) {
+		if first && retriever.resumeID > 0 {
+			log.Infof(mb.c, "First harvest of %v stopped at the page cap, older tweets are skipped.", query)
+		}
+		checkpoint.Updated = time.Now()
+		if err := mb.store.PutHarvestCheckpoint(mb.c, checkpoint); err != nil {
+			log.Errorf(mb.c, "Failed to save the harvest checkpoint for %v. %v", query, err.Error())
+			return summary, err
+		}
+	}
+	log.Infof(mb.c, "Harvested %v: %v tweets fetched, %v with links, %v filtered (%v as spam), %v stored.",
+		query, summary.Fetched, summary.WithLinks, summary.Filtered, summary.Spam, summary.Stored)
+	return summary, nil
 }
 
-//extractLinks searches the contents of a tweet and pulls out any url from the
-// text.  Results in a channel of LinkTweet objects.
+//topicFilter returns the Filter of the query's Topic, or nil if it has none.
+// The tweets its SpamFilters reject are logged with the reasons for rejecting
+// them and counted in the summary.
+func (mb MapBuilder) topicFilter(query string, summary *HarvestSummary) (Filter, error) {
+	topic, err := mb.store.GetTopic(mb.c, query)
+	if err != nil || topic == nil {
+		return nil, err
+	}
+	return topic.filter(func(rejection SpamRejection) {
+		summary.Spam++
+		log.Infof(mb.c, "Spam filter rejected tweet %v by @%v with score %v: %v",
+			rejection.TweetID, rejection.User, rejection.Score, strings.Join(rejection.Reasons, ", "))
+	})
+}
+
+//extractLinks passes on the tweets that contain at least one link and pass the
+// filter, if there is one, counting the tweets it sees.
 func (mb MapBuilder) extractLinks(tweets <-chan anaconda.Tweet,
 	out chan<- anaconda.Tweet,
+	filter Filter,
+	summary *HarvestSummary,
 	wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	defer close(out)
 
-	var inner sync.WaitGroup
 	for tweet := range tweets {
-		inner.Add(1)
-		go func(tweet anaconda.Tweet) {
-			defer inner.Done()
-			if len(tweet.Entities.Urls) > 0 {
-				out <- tweet
-			}
-		}(tweet)
+		summary.Fetched++
+		if !(URLFilter{}).Filter(&tweet) {
+			continue
+		}
+		summary.WithLinks++
+		if filter != nil && !filter.Filter(&tweet) {
+			summary.Filtered++
+			continue
+		}
+		out <- tweet
 	}
-	inner.Wait()
-	close(out)
 }
 
-func makeMap(tweets <-chan anaconda.Tweet, wg *sync.WaitGroup) map[string][]int64 {
-	var out map[string][]int64
+//writeLinkTweet writes a LinkTweet for each address in the given Tweets to the
+// store.  Tweets that are already in the store for the query, or that were seen
+// earlier in the harvest, are skipped, as are tweets that can't be looked up.
+func (mb MapBuilder) writeLinkTweet(tweets <-chan anaconda.Tweet,
+	summary *HarvestSummary) error {
+
+	var values LinkTweets
+	seen := make(map[int64]bool)
 
 	for tweet := range tweets {
-		for _, address := range tweet.Entities.Urls {
-			if out[address.Expanded_url] == nil {
-				out[address.Expanded_url] = []int64{tweet.Id}
-			}
+		if seen[tweet.Id] {
+			continue
+		}
+		seen[tweet.Id] = true
+
+		existing, err := mb.store.QueryLinkTweet(mb.c, mb.query, tweet.Id)
+		if err != nil {
+			log.Errorf(mb.c, "Failed to look up tweet %v. %v", tweet.Id, err.Error())
+			continue
+		}
+		if existing != nil {
+			continue
+		}
+
+		linkTweets := LinkTweetsFrom(tweet)
+		for _, linkTweet := range linkTweets {
+			linkTweet.Query = mb.query
+		}
+		if len(linkTweets) > 0 {
+			values = append(values, linkTweets...)
+			summary.Stored++
 		}
 	}
-	return out
+
+	if len(values) == 0 {
+		return nil
+	}
+	values = mb.resolveLinks(values)
+	err := mb.store.PutLinkTweets(mb.c, values)
+	if err != nil {
+		log.Errorf(mb.c, "Failed to write LinkTweet to store. %v", err.Error())
+		summary.Stored = 0
+	}
+	return err
 }
 
-//WriteLinkTweet writes a given Tweet to the datastore
-func (mb MapBuilder) writeLinkTweet(tweets <-chan anaconda.Tweet, wg *sync.WaitGroup) {
-	defer wg.Done()
+//resolveWorkers is the number of addresses that are resolved at once.
+const resolveWorkers = 8
 
-	var keys []*datastore.Key
-	var values []*int64
+//tweetAddress identifies a LinkTweet by its tweet and address.
+type tweetAddress struct {
+	id      int64
+	address string
+}
 
-	for tweet := range tweets {
-		key := datastore.NewIncompleteKey(mb.c, linkTweetKind, getTweetKey(mb.c))
-		keys = append(keys, key)
-		values = append(values, &tweet.Id)
+//resolveLinks follows the redirects of the tweeted addresses and updates the
+// LinkTweets with the addresses they lead to.  Addresses that can't be resolved
+// are kept as tweeted, and a tweet that links to the same article twice, e.g.
+// through two different shorteners, keeps only one LinkTweet for it.
+func (mb MapBuilder) resolveLinks(tweets LinkTweets) LinkTweets {
+	if mb.resolver == nil {
+		return tweets
 	}
 
-	err := datastore.RunInTransaction(mb.c, func(c context.Context) error {
-		_, err := datastore.PutMulti(c, keys, values)
+	var distinct []string
+	resolved := make(map[string]string)
+	for _, tweet := range tweets {
+		if _, ok := resolved[tweet.ShortAddress]; !ok {
+			resolved[tweet.ShortAddress] = tweet.ShortAddress
+			distinct = append(distinct, tweet.ShortAddress)
+		}
+	}
 
-		if err != nil {
-			log.Errorf(c, "Failed to write LinkTweet to datastore. %v", err.Error())
-			return err
+	addresses := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(resolveWorkers)
+	for i := 0; i < resolveWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for address := range addresses {
+				final, err := mb.resolver.Resolve(mb.c, address)
+				if err != nil {
+					log.Infof(mb.c, "Failed to resolve address: %v \n\t%v", address, err.Error())
+				}
+				mu.Lock()
+				resolved[address] = final
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, address := range distinct {
+		addresses <- address
+	}
+	close(addresses)
+	wg.Wait()
+
+	out := make(LinkTweets, 0, len(tweets))
+	seen := make(map[tweetAddress]bool)
+	for _, tweet := range tweets {
+		tweet.OriginalAddress = resolved[tweet.ShortAddress]
+		tweet.Address = DefaultCanonicalizer.Canonical(tweet.OriginalAddress)
+		key := tweetAddress{id: tweet.Id, address: tweet.Address}
+		if seen[key] {
+			continue
 		}
-		return nil
-	}, nil)
-	if err != nil {
-		log.Errorf(mb.c, "Failed to write LinkTweet to datastore. %v", err.Error())
+		seen[key] = true
+		out = append(out, tweet)
 	}
+	return out
 }
@@ -0,0 +1,355 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+func TestMapStoresLinkTweets(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	//An older copy of one of the fixture tweets, so that it is already stored.
+	old := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(665720000000000000, "https://blog.golang.org/go1.5.1", "golang", old),
+	})
+
+	router := NewRouter(testServices(store, source))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/map?q=golang", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %v", response.Code, response.Body.String())
+	}
+
+	var summary HarvestSummary
+	if err := json.Unmarshal(response.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	expected := HarvestSummary{Query: "golang", Fetched: 5, WithLinks: 4, Stored: 3}
+	if summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	tweets, _ := store.GetAllNewTweets(c, old)
+	if len(tweets) != 3 {
+		t.Fatalf("Expected 3 new LinkTweets, got %v", len(tweets))
+	}
+	for _, tweet := range tweets {
+		expanded := tweet.Entities.Urls[0].Expanded_url
+		if tweet.Query != "golang" || tweet.OriginalAddress != expanded ||
+			tweet.Address != DefaultCanonicalizer.Canonical(expanded) {
+			t.Errorf("Unexpected LinkTweet %v: %v, %v", tweet.Id, tweet.Query, tweet.Address)
+		}
+	}
+}
+
+func TestMapAppliesTopicFilters(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	store.PutTopic(c, &Topic{Query: "golang", Filters: &FilterConfig{MinFollowers: 100}})
+
+	router := NewRouter(testServices(store, source))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/map?q=golang", nil))
+
+	var summary HarvestSummary
+	json.Unmarshal(response.Body.Bytes(), &summary)
+	expected := HarvestSummary{Query: "golang", Fetched: 5, WithLinks: 4, Filtered: 1, Stored: 3}
+	if summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	if tweet, _ := store.LinkTweet(c, 665740000000000000); tweet != nil {
+		t.Errorf("Expected the tweet from a user with 40 followers to be filtered out")
+	}
+}
+
+//aliasSource is a TweetSource that answers every query with the results of
+// query.
+type aliasSource struct {
+	TweetSource
+	query string
+}
+
+func (source aliasSource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	return source.TweetSource.Search(c, source.query, v)
+}
+
+func TestMapStoresTweetForEachTopic(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	//The same tweets are found by searches for both topics.
+	router := NewRouter(testServices(store, aliasSource{TweetSource: source, query: "golang"}))
+	for _, query := range []string{"golang", "go"} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", "/map?q="+query, nil))
+		var summary HarvestSummary
+		json.Unmarshal(response.Body.Bytes(), &summary)
+		if summary.Stored != 4 {
+			t.Errorf("Expected 4 tweets stored for %v, got %+v", query, summary)
+		}
+	}
+	runReduce(t, router)
+
+	golang, _ := store.RecentScores(c, "golang", time.Time{})
+	gopher, _ := store.RecentScores(c, "go", time.Time{})
+	if len(golang) == 0 || len(golang) != len(gopher) {
+		t.Fatalf("Expected both topics to score the same links, got %v and %v", len(golang), len(gopher))
+	}
+	scores := make(map[string]int)
+	for _, score := range golang {
+		scores[score.Address] = score.Score
+	}
+	for _, score := range gopher {
+		if scored, ok := scores[score.Address]; !ok || scored != score.Score {
+			t.Errorf("Expected %v scored alike under both topics, got %v and %v",
+				score.Address, scored, score.Score)
+		}
+	}
+}
+
+//failingPageSource is a TweetSource that fails to get any page after the first.
+type failingPageSource struct {
+	TweetSource
+}
+
+func (source failingPageSource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	if v.Get("max_id") != "" {
+		return anaconda.SearchResponse{}, errors.New("page unavailable")
+	}
+	return source.TweetSource.Search(c, query, v)
+}
+
+func TestMapHarvestsSinceCheckpoint(t *testing.T) {
+	c := context.Background()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	harvest := func(services Services) (int, HarvestSummary) {
+		response := httptest.NewRecorder()
+		NewRouter(services).ServeHTTP(response, httptest.NewRequest("GET", "/map?q=golang", nil))
+		var summary HarvestSummary
+		json.Unmarshal(response.Body.Bytes(), &summary)
+		return response.Code, summary
+	}
+
+	//The page cap bounds the first harvest, and the next asks only for newer tweets.
+	store := NewMemoryStore()
+	services := testServices(store, source)
+	services.MaxPages = 1
+	if _, summary := harvest(services); summary.Fetched != 3 {
+		t.Errorf("Expected only the first page to be fetched, got %+v", summary)
+	}
+	checkpoint, _ := store.GetHarvestCheckpoint(c, "golang")
+	if checkpoint == nil || checkpoint.MaxID != 665756769528999936 || checkpoint.Updated.IsZero() {
+		t.Fatalf("Expected the checkpoint at the newest tweet, got %+v", checkpoint)
+	}
+	if _, summary := harvest(services); summary.Fetched != 0 {
+		t.Errorf("Expected no tweets newer than the checkpoint, got %+v", summary)
+	}
+
+	//A failed page leaves the checkpoint alone, so the next harvest fills the gap.
+	store = NewMemoryStore()
+	if code, _ := harvest(testServices(store, failingPageSource{source})); code != http.StatusInternalServerError {
+		t.Errorf("Expected the failed page to fail the harvest, got %v", code)
+	}
+	if checkpoint, _ := store.GetHarvestCheckpoint(c, "golang"); checkpoint != nil {
+		t.Errorf("Expected no checkpoint after a failed page, got %+v", checkpoint)
+	}
+	_, summary := harvest(testServices(store, source))
+	if summary.Fetched != 5 || summary.Stored != 1 {
+		t.Errorf("Expected the retry to store only the tweet from the missing page, got %+v", summary)
+	}
+}
+
+func TestMapResumesAfterPageCap(t *testing.T) {
+	c := context.Background()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	harvest := func(services Services) HarvestSummary {
+		response := httptest.NewRecorder()
+		NewRouter(services).ServeHTTP(response, httptest.NewRequest("GET", "/map?q=golang", nil))
+		if response.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %v: %v", response.Code, response.Body.String())
+		}
+		var summary HarvestSummary
+		json.Unmarshal(response.Body.Bytes(), &summary)
+		return summary
+	}
+
+	//An earlier harvest got as far as tweet 1, so both pages are newer than it.
+	store := NewMemoryStore()
+	store.PutHarvestCheckpoint(c, &HarvestCheckpoint{Query: "golang", MaxID: 1})
+	services := testServices(store, source)
+	services.MaxPages = 1
+
+	if summary := harvest(services); summary.Fetched != 3 {
+		t.Errorf("Expected only the first page to be fetched, got %+v", summary)
+	}
+	checkpoint, _ := store.GetHarvestCheckpoint(c, "golang")
+	if checkpoint == nil || checkpoint.MaxID != 1 || checkpoint.ResumeID != 665719999999999999 ||
+		checkpoint.NewestID != 665756769528999936 {
+		t.Fatalf("Expected the checkpoint to resume from the second page, got %+v", checkpoint)
+	}
+
+	if summary := harvest(services); summary.Fetched != 2 {
+		t.Errorf("Expected the second page to be fetched, got %+v", summary)
+	}
+	checkpoint, _ = store.GetHarvestCheckpoint(c, "golang")
+	if checkpoint == nil || checkpoint.MaxID != 665756769528999936 || checkpoint.ResumeID != 0 ||
+		checkpoint.NewestID != 0 {
+		t.Fatalf("Expected the checkpoint at the newest tweet, got %+v", checkpoint)
+	}
+	if summary := harvest(services); summary.Fetched != 0 {
+		t.Errorf("Expected no tweets newer than the checkpoint, got %+v", summary)
+	}
+
+	tweets, _ := store.GetAllNewTweets(c, time.Time{})
+	if len(tweets) != 4 {
+		t.Errorf("Expected the LinkTweets of both pages, got %v", len(tweets))
+	}
+}
+
+func TestLinkTweetsFrom(t *testing.T) {
+	var tweet anaconda.Tweet
+	err := json.Unmarshal([]byte(`{"id": 1, "entities": {"urls": [
+		{"expanded_url": "http://a.com"},
+		{"expanded_url": "http://b.com"},
+		{"expanded_url": "https://www.a.com/?utm_source=twitter"}]}}`), &tweet)
+	if err != nil {
+		t.Fatalf("Failed to decode tweet: %v", err)
+	}
+
+	linkTweets := LinkTweetsFrom(tweet)
+	if len(linkTweets) != 2 || linkTweets[0].Address != "https://a.com" ||
+		linkTweets[1].Address != "https://b.com" {
+		t.Errorf("Expected one LinkTweet per distinct canonical address, got %v", len(linkTweets))
+	}
+	if linkTweets[0].OriginalAddress != "http://a.com" {
+		t.Errorf("Expected the tweeted address to be kept, got %v", linkTweets[0].OriginalAddress)
+	}
+
+	if _, err := LinkTweetFrom(anaconda.Tweet{}); err == nil {
+		t.Errorf("Expected an error for a tweet without links")
+	}
+}
+
+//limitedSource is a LimitedSource that allows remaining more searches before
+// answering with a 429.
+type limitedSource struct {
+	TweetSource
+	remaining *int
+	calls     *int
+	reset     time.Time
+}
+
+func (source limitedSource) LimitedSearch(c context.Context, query string,
+	v url.Values) (anaconda.SearchResponse, *RateLimitHeaders, error) {
+
+	*source.calls++
+	header := http.Header{
+		"X-Rate-Limit-Limit":     {"180"},
+		"X-Rate-Limit-Remaining": {"0"},
+		"X-Rate-Limit-Reset":     {strconv.FormatInt(source.reset.Unix(), 10)},
+	}
+	if *source.remaining == 0 {
+		return anaconda.SearchResponse{}, nil,
+			&anaconda.ApiError{StatusCode: http.StatusTooManyRequests, Header: header}
+	}
+	*source.remaining--
+	header.Set("X-Rate-Limit-Remaining", strconv.Itoa(*source.remaining))
+	result, err := source.Search(c, query, v)
+	return result, parseRateLimitHeaders(header), err
+}
+
+func TestMapDefersWhenRateLimited(t *testing.T) {
+	c := context.Background()
+	replay, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+	remaining, calls := 1, 0
+	reset := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	source := limitedSource{TweetSource: replay, remaining: &remaining, calls: &calls, reset: reset}
+
+	store := NewMemoryStore()
+	for _, query := range []string{"golang", "rust"} {
+		store.PutTopic(c, &Topic{Query: query, Interval: time.Hour, Enabled: true})
+	}
+	router := NewRouter(testServices(store, source))
+	send := func(target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", target, nil))
+		return response
+	}
+
+	//The first page uses up the window, so the harvest stops and every topic due is
+	// deferred until it resets.
+	var scheduled struct {
+		Harvested []string `json:"harvested"`
+		Deferred  []string `json:"deferred"`
+	}
+	json.NewDecoder(send("/schedule").Body).Decode(&scheduled)
+	if len(scheduled.Harvested) != 0 || len(scheduled.Deferred) != 2 || calls != 1 {
+		t.Errorf("Expected both topics to be deferred after 1 search, got %+v after %v", scheduled, calls)
+	}
+	if topic, _ := store.GetTopic(c, "golang"); !topic.LastHarvest.IsZero() {
+		t.Errorf("Expected the deferred topic to be left due")
+	}
+
+	response := send("/map?q=golang")
+	if response.Code != http.StatusServiceUnavailable || response.Header().Get("Retry-After") == "" || calls != 1 {
+		t.Errorf("Expected 503 with Retry-After and no search, got %v after %v", response.Code, calls)
+	}
+
+	var status struct {
+		RateLimits []struct {
+			Endpoint    string    `json:"endpoint"`
+			Remaining   int       `json:"remaining"`
+			NextAllowed time.Time `json:"next_allowed"`
+			Allowed     bool      `json:"allowed"`
+		} `json:"rate_limits"`
+	}
+	json.NewDecoder(send("/status").Body).Decode(&status)
+	if len(status.RateLimits) != 1 || status.RateLimits[0].Endpoint != searchEndpoint ||
+		status.RateLimits[0].Allowed || !status.RateLimits[0].NextAllowed.Equal(reset) {
+		t.Errorf("Expected the search quota to be used up until %v, got %+v", reset, status)
+	}
+
+	//A 429 backs off until the window resets.
+	store = NewMemoryStore()
+	router = NewRouter(testServices(store, source))
+	if response := send("/map?q=golang"); response.Code != http.StatusServiceUnavailable || calls != 2 {
+		t.Errorf("Expected 503 after a 429, got %v after %v", response.Code, calls)
+	}
+	limit, _ := store.GetRateLimit(c, searchEndpoint)
+	if limit == nil || limit.Backoffs != 1 || limit.NextAllowed.Before(reset) {
+		t.Errorf("Expected the backoff to last until %v, got %+v", reset, limit)
+	}
+}
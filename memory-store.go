@@ -0,0 +1,613 @@
+package tweetharvest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+//MemoryStore is a Store that keeps LinkTweets and TweetScores in memory.  It lets
+// the map, reduce and consume stages run outside of App Engine, e.g. in tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	tweets   LinkTweets
+	scores   map[scoreID]*TweetScore
+	topics   map[string]*Topic
+	harvests map[string]*HarvestCheckpoint
+	limits   map[string]*RateLimit
+	domains  map[domainID]*Domain
+	policies map[string]*DomainPolicy
+	jobs     map[int64]*JobRun
+	nextJob  int64
+	sequence int64
+	reduced  map[string]*ReduceCheckpoint
+}
+
+//domainID identifies the Domain of a query.
+type domainID struct {
+	query string
+	name  string
+}
+
+//NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		scores:   make(map[scoreID]*TweetScore),
+		topics:   make(map[string]*Topic),
+		harvests: make(map[string]*HarvestCheckpoint),
+		limits:   make(map[string]*RateLimit),
+		domains:  make(map[domainID]*Domain),
+		policies: make(map[string]*DomainPolicy),
+		jobs:     make(map[int64]*JobRun),
+		reduced:  make(map[string]*ReduceCheckpoint),
+	}
+}
+
+//PutLinkTweets stores a copy of each of the tweets, numbered in the order they
+// were put.
+func (store *MemoryStore) PutLinkTweets(c context.Context, tweets LinkTweets) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, tweet := range tweets {
+		store.sequence++
+		stored := *tweet
+		stored.Sequence = store.sequence
+		store.tweets = append(store.tweets, &stored)
+	}
+	return nil
+}
+
+//GetTweetsAfter returns copies of up to limit of the query's tweets put after the
+// one numbered sequence.
+func (store *MemoryStore) GetTweetsAfter(c context.Context, query string, sequence int64, limit int) (LinkTweets, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out LinkTweets
+	for _, tweet := range store.tweets {
+		if len(out) == limit {
+			break
+		}
+		if tweet.Query == query && tweet.Sequence > sequence {
+			found := *tweet
+			out = append(out, &found)
+		}
+	}
+	return out, nil
+}
+
+//TweetQueries returns the distinct queries of the stored tweets, in order.
+func (store *MemoryStore) TweetQueries(c context.Context) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var out []string
+	for _, tweet := range store.tweets {
+		if !seen[tweet.Query] {
+			seen[tweet.Query] = true
+			out = append(out, tweet.Query)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+//GetAllNewTweets returns copies of the tweets created after since.
+func (store *MemoryStore) GetAllNewTweets(c context.Context, since time.Time) (LinkTweets, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	out := make(LinkTweets, 0, 15)
+	for _, tweet := range store.tweets {
+		if created, _ := tweet.CreatedAtTime(); created.After(since) {
+			found := *tweet
+			out = append(out, &found)
+		}
+	}
+	return out, nil
+}
+
+//NewestTweet returns the creation time of the newest stored tweet.
+func (store *MemoryStore) NewestTweet(c context.Context) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var newest time.Time
+	for _, tweet := range store.tweets {
+		if created, _ := tweet.CreatedAtTime(); created.After(newest) {
+			newest = created
+		}
+	}
+	return newest, nil
+}
+
+//LinkTweet returns a copy of the first stored tweet with the given ID.
+func (store *MemoryStore) LinkTweet(c context.Context, tweetID int64) (*LinkTweet, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, tweet := range store.tweets {
+		if tweet.Id == tweetID {
+			found := *tweet
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+//QueryLinkTweet returns a copy of the first tweet stored with the given ID under
+// the query.
+func (store *MemoryStore) QueryLinkTweet(c context.Context, query string, tweetID int64) (*LinkTweet, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, tweet := range store.tweets {
+		if tweet.Id == tweetID && tweet.Query == query {
+			found := *tweet
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+//GetHarvestCheckpoint returns a copy of the checkpoint for the query.
+func (store *MemoryStore) GetHarvestCheckpoint(c context.Context, query string) (*HarvestCheckpoint, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoint, ok := store.harvests[query]
+	if !ok {
+		return nil, nil
+	}
+	found := *checkpoint
+	return &found, nil
+}
+
+//PutHarvestCheckpoint stores a copy of the checkpoint.
+func (store *MemoryStore) PutHarvestCheckpoint(c context.Context, checkpoint *HarvestCheckpoint) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *checkpoint
+	store.harvests[checkpoint.Query] = &stored
+	return nil
+}
+
+//GetRateLimit returns a copy of the rate limit of the endpoint.
+func (store *MemoryStore) GetRateLimit(c context.Context, endpoint string) (*RateLimit, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	limit, ok := store.limits[endpoint]
+	if !ok {
+		return nil, nil
+	}
+	found := *limit
+	return &found, nil
+}
+
+//GetRateLimits returns copies of the rate limits, ordered by endpoint.
+func (store *MemoryStore) GetRateLimits(c context.Context) ([]*RateLimit, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*RateLimit
+	for _, limit := range store.limits {
+		found := *limit
+		out = append(out, &found)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out, nil
+}
+
+//PutRateLimit stores a copy of the rate limit.
+func (store *MemoryStore) PutRateLimit(c context.Context, limit *RateLimit) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *limit
+	store.limits[limit.Endpoint] = &stored
+	return nil
+}
+
+//UpdateScore holds the store lock while update runs, so concurrent updates are
+// applied one after another.
+func (store *MemoryStore) UpdateScore(c context.Context, query string, address string,
+	update func(score *TweetScore, exists bool) error) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	score, err := store.updatedScore(query, address, update)
+	if err != nil {
+		return err
+	}
+	store.scores[scoreID{query: query, address: address}] = score
+	return nil
+}
+
+//UpdateScores applies update to copies of the scores for the addresses, and only
+// stores them and the checkpoint once every update has succeeded.  Nothing is
+// applied if the stored checkpoint isn't at from.
+func (store *MemoryStore) UpdateScores(c context.Context, addresses []string, from int64, checkpoint *ReduceCheckpoint,
+	update func(score *TweetScore, exists bool) error) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var current int64
+	if reduced, ok := store.reduced[checkpoint.Query]; ok {
+		current = reduced.Sequence
+	}
+	if current != from {
+		return errCheckpointMoved
+	}
+
+	updated := make([]*TweetScore, len(addresses))
+	for i, address := range addresses {
+		score, err := store.updatedScore(checkpoint.Query, address, update)
+		if err != nil {
+			return err
+		}
+		updated[i] = score
+	}
+
+	for i, address := range addresses {
+		store.scores[scoreID{query: checkpoint.Query, address: address}] = updated[i]
+	}
+	stored := *checkpoint
+	store.reduced[checkpoint.Query] = &stored
+	return nil
+}
+
+//updatedScore hands a copy of the query's score for the address to update and
+// returns it.  The caller must hold the store lock.
+func (store *MemoryStore) updatedScore(query string, address string,
+	update func(score *TweetScore, exists bool) error) (*TweetScore, error) {
+
+	score := &TweetScore{Query: query, Address: address}
+	old, exists := store.scores[scoreID{query: query, address: address}]
+	if exists {
+		score = copyScore(old)
+	}
+	if err := update(score, exists); err != nil {
+		return nil, err
+	}
+	score.Query = query
+	score.Address = address
+	score.Updated = time.Now()
+	return copyScore(score), nil
+}
+
+//AddressScores returns copies of the address's scores, ordered by query.
+func (store *MemoryStore) AddressScores(c context.Context, address string) ([]*TweetScore, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*TweetScore
+	for id, score := range store.scores {
+		if id.address == address {
+			out = append(out, copyScore(score))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Query < out[j].Query
+	})
+	return out, nil
+}
+
+//GetReduceCheckpoint returns a copy of the reduce checkpoint for the query.
+func (store *MemoryStore) GetReduceCheckpoint(c context.Context, query string) (*ReduceCheckpoint, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoint, ok := store.reduced[query]
+	if !ok {
+		return nil, nil
+	}
+	found := *checkpoint
+	return &found, nil
+}
+
+//RecentScores returns copies of the query's scores active since the given time.
+func (store *MemoryStore) RecentScores(c context.Context, query string, since time.Time) ([]*TweetScore, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*TweetScore
+	for _, score := range store.scores {
+		if score.Query == query && !score.LastActive.Before(since) {
+			out = append(out, copyScore(score))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastActive.After(out[j].LastActive)
+	})
+	return out, nil
+}
+
+//LastScoreActivity returns the newest LastActive of all of the stored scores.
+func (store *MemoryStore) LastScoreActivity(c context.Context) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var newest time.Time
+	for _, score := range store.scores {
+		if score.LastActive.After(newest) {
+			newest = score.LastActive
+		}
+	}
+	return newest, nil
+}
+
+//LastQueryActivity returns the newest LastActive of the query's scores.
+func (store *MemoryStore) LastQueryActivity(c context.Context, query string) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var newest time.Time
+	for _, score := range store.scores {
+		if score.Query == query && score.LastActive.After(newest) {
+			newest = score.LastActive
+		}
+	}
+	return newest, nil
+}
+
+//LastQueryUpdate returns the newest Updated of the query's scores.
+func (store *MemoryStore) LastQueryUpdate(c context.Context, query string) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var newest time.Time
+	for _, score := range store.scores {
+		if score.Query == query && score.Updated.After(newest) {
+			newest = score.Updated
+		}
+	}
+	return newest, nil
+}
+
+//PutTopic stores a copy of the topic.
+func (store *MemoryStore) PutTopic(c context.Context, topic *Topic) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *topic
+	store.topics[topic.Query] = &stored
+	return nil
+}
+
+//UpdateTopic holds the store lock while update runs on a copy of the topic.
+func (store *MemoryStore) UpdateTopic(c context.Context, query string,
+	update func(topic *Topic, exists bool) error) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	topic := &Topic{Query: query}
+	old, exists := store.topics[query]
+	if exists {
+		found := *old
+		topic = &found
+	}
+	if err := update(topic, exists); err != nil {
+		return err
+	}
+	topic.Query = query
+	store.topics[query] = topic
+	return nil
+}
+
+//GetTopic returns a copy of the topic for the query.
+func (store *MemoryStore) GetTopic(c context.Context, query string) (*Topic, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	topic, ok := store.topics[query]
+	if !ok {
+		return nil, nil
+	}
+	found := *topic
+	return &found, nil
+}
+
+//GetTopics returns copies of all of the topics.
+func (store *MemoryStore) GetTopics(c context.Context) ([]*Topic, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*Topic
+	for _, topic := range store.topics {
+		found := *topic
+		out = append(out, &found)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Query < out[j].Query
+	})
+	return out, nil
+}
+
+//DeleteTopic removes the topic for the query.
+func (store *MemoryStore) DeleteTopic(c context.Context, query string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.topics, query)
+	return nil
+}
+
+//UpdateDomain holds the store lock while update runs, like UpdateScore.
+func (store *MemoryStore) UpdateDomain(c context.Context, query string, name string,
+	update func(domain *Domain, exists bool) error) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	id := domainID{query: query, name: name}
+	domain := &Domain{Name: name, Query: query}
+	old, exists := store.domains[id]
+	if exists {
+		found := *old
+		domain = &found
+	}
+
+	if err := update(domain, exists); err != nil {
+		return err
+	}
+	stored := *domain
+	stored.Name, stored.Query = name, query
+	store.domains[id] = &stored
+	return nil
+}
+
+//TopDomains returns copies of the query's highest scoring domains.
+func (store *MemoryStore) TopDomains(c context.Context, query string, limit int) ([]*Domain, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*Domain
+	for _, domain := range store.domains {
+		if domain.Query == query {
+			found := *domain
+			out = append(out, &found)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Name < out[j].Name
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+//PutDomainPolicy stores a copy of the policy.
+func (store *MemoryStore) PutDomainPolicy(c context.Context, policy *DomainPolicy) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stored := *policy
+	store.policies[policy.Name] = &stored
+	return nil
+}
+
+//GetDomainPolicy returns a copy of the policy for the domain.
+func (store *MemoryStore) GetDomainPolicy(c context.Context, name string) (*DomainPolicy, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	policy, ok := store.policies[name]
+	if !ok {
+		return nil, nil
+	}
+	found := *policy
+	return &found, nil
+}
+
+//GetDomainPolicies returns copies of all of the policies.
+func (store *MemoryStore) GetDomainPolicies(c context.Context) (DomainPolicies, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out DomainPolicies
+	for _, policy := range store.policies {
+		found := *policy
+		out = append(out, &found)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+//DeleteDomainPolicy removes the policy for the domain.
+func (store *MemoryStore) DeleteDomainPolicy(c context.Context, name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.policies, name)
+	return nil
+}
+
+//copyScore returns a copy of score that shares no slices with the original.
+func copyScore(score *TweetScore) *TweetScore {
+	out := *score
+	out.TweetIDs = append([]int64(nil), score.TweetIDs...)
+	out.Shares = append([]Share(nil), score.Shares...)
+	return &out
+}
+
+//PutJobRun stores a copy of the run, numbering new runs from 1.
+func (store *MemoryStore) PutJobRun(c context.Context, run *JobRun) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if run.ID == 0 {
+		store.nextJob++
+		run.ID = store.nextJob
+	}
+	store.jobs[run.ID] = run.copy()
+	return nil
+}
+
+//GetJobRun returns a copy of the run with the ID.
+func (store *MemoryStore) GetJobRun(c context.Context, id int64) (*JobRun, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	run, ok := store.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return run.copy(), nil
+}
+
+//UpdateJobRun applies update to a copy of the run with the ID and stores it.
+func (store *MemoryStore) UpdateJobRun(c context.Context, id int64,
+	update func(run *JobRun, exists bool) error) error {
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	old, exists := store.jobs[id]
+	run := &JobRun{ID: id}
+	if exists {
+		run = old.copy()
+	}
+	if err := update(run, exists); err != nil || !exists {
+		return err
+	}
+	run.ID = id
+	store.jobs[id] = run.copy()
+	return nil
+}
+
+//GetJobRuns returns copies of the runs with the status, newest first.
+func (store *MemoryStore) GetJobRuns(c context.Context, status string, limit int) ([]*JobRun, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var out []*JobRun
+	for _, run := range store.jobs {
+		if status == "" || run.Status == status {
+			out = append(out, run.copy())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Started.Equal(out[j].Started) {
+			return out[i].Started.After(out[j].Started)
+		}
+		return out[i].ID > out[j].ID
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
@@ -0,0 +1,31 @@
+package tweetharvest
+
+import "testing"
+
+func TestMemoryStoreTweets(t *testing.T) {
+	testStoreTweets(t, NewMemoryStore())
+}
+
+func TestMemoryStoreScores(t *testing.T) {
+	testStoreScores(t, NewMemoryStore())
+}
+
+func TestMemoryStoreTopics(t *testing.T) {
+	testStoreTopics(t, NewMemoryStore())
+}
+
+func TestMemoryStoreDomains(t *testing.T) {
+	testStoreDomains(t, NewMemoryStore())
+}
+
+func TestMemoryStoreHarvests(t *testing.T) {
+	testStoreHarvests(t, NewMemoryStore())
+}
+
+func TestMemoryStoreJobs(t *testing.T) {
+	testStoreJobs(t, NewMemoryStore())
+}
+
+func TestMemoryStoreOverlappingReduces(t *testing.T) {
+	testStoreOverlappingReduces(t, NewMemoryStore())
+}
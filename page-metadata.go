@@ -0,0 +1,161 @@
+package tweetharvest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+//maxMetadataScan is the most of a page that is read looking for its metadata.
+const maxMetadataScan = 1024 * 1024
+
+//PageMetadata is the information an article gives about itself in the head of its
+// HTML: the <title> and Open Graph, Twitter card and other <meta> tags.
+type PageMetadata struct {
+	Title       string
+	Description string
+	Image       string
+	Card        string
+	Author      string
+	SiteName    string
+	Published   time.Time
+}
+
+//publishedLayouts are the formats published dates are given in.
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+//metadataFields maps the name or property of a <meta> tag to the field it sets.
+// Where tags name the same field, the one earlier in its list wins.
+var metadataFields = map[string]struct {
+	field    string
+	priority int
+}{
+	"og:title":               {"title", 0},
+	"twitter:title":          {"title", 1},
+	"og:description":         {"description", 0},
+	"twitter:description":    {"description", 1},
+	"description":            {"description", 2},
+	"og:image":               {"image", 0},
+	"og:image:url":           {"image", 1},
+	"og:image:secure_url":    {"image", 2},
+	"twitter:image":          {"image", 3},
+	"twitter:image:src":      {"image", 4},
+	"twitter:card":           {"card", 0},
+	"author":                 {"author", 0},
+	"article:author":         {"author", 1},
+	"twitter:creator":        {"author", 2},
+	"og:site_name":           {"site", 0},
+	"application-name":       {"site", 1},
+	"twitter:site":           {"site", 2},
+	"article:published_time": {"published", 0},
+	"og:published_time":      {"published", 1},
+	"date":                   {"published", 2},
+	"pubdate":                {"published", 3},
+}
+
+//extractMetadata reads the metadata of the HTML page in the response.  Any HTML
+// content type is accepted, and the page is converted to UTF-8 from the charset
+// given by the Content-Type header or the page itself.
+func extractMetadata(resp *http.Response) (PageMetadata, error) {
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isHTML(contentType) {
+		return PageMetadata{}, errors.New("Wrong content type.  Recieved: " + contentType +
+			" from " + resp.Request.URL.String())
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxMetadataScan), contentType)
+	if err != nil {
+		return PageMetadata{}, err
+	}
+	metadata := scrapeMetadata(body)
+	if metadata.Title == "" {
+		return metadata, errors.New("Unable to find title tag in " + resp.Request.URL.String())
+	}
+	return metadata, nil
+}
+
+//scrapeMetadata reads the tags in the head of the page, stopping at its body.
+func scrapeMetadata(page io.Reader) PageMetadata {
+	found := make(map[string]string)
+	priority := make(map[string]int)
+	var title string
+
+	tokenizer := html.NewTokenizer(page)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return buildMetadata(found, title)
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return buildMetadata(found, title)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag := tokenizer.Token()
+			switch tag.Data {
+			case "body":
+				return buildMetadata(found, title)
+			case "title":
+				if title == "" && tokenizer.Next() == html.TextToken {
+					title = strings.TrimSpace(html.UnescapeString(string(tokenizer.Text())))
+				}
+			case "meta":
+				var key, content string
+				for _, attr := range tag.Attr {
+					switch attr.Key {
+					case "property", "name", "itemprop":
+						if key == "" || attr.Key == "property" {
+							key = strings.ToLower(attr.Val)
+						}
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				meta, ok := metadataFields[key]
+				if !ok || content == "" {
+					continue
+				}
+				if current, seen := priority[meta.field]; !seen || meta.priority < current {
+					found[meta.field] = content
+					priority[meta.field] = meta.priority
+				}
+			}
+		}
+	}
+}
+
+//buildMetadata collects the found fields, falling back to the <title> tag.
+func buildMetadata(found map[string]string, title string) PageMetadata {
+	metadata := PageMetadata{
+		Title:       found["title"],
+		Description: found["description"],
+		Image:       found["image"],
+		Card:        found["card"],
+		Author:      found["author"],
+		SiteName:    found["site"],
+	}
+	if metadata.Title == "" {
+		metadata.Title = title
+	}
+	for _, layout := range publishedLayouts {
+		if published, err := time.Parse(layout, found["published"]); err == nil {
+			metadata.Published = published
+			break
+		}
+	}
+	return metadata
+}
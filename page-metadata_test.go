@@ -0,0 +1,102 @@
+package tweetharvest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testPage returns a response serving body with the given content type.
+func testPage(contentType string, body string) *http.Response {
+	return &http.Response{
+		Header:  http.Header{"Content-Type": {contentType}},
+		Body:    ioutil.NopCloser(strings.NewReader(body)),
+		Request: httptest.NewRequest("GET", "http://example.com/article", nil),
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	page := `<!DOCTYPE html><html><head>
+		<title>Fallback &amp; title</title>
+		<meta name="description" content="Plain description">
+		<meta property="og:description" content="Open Graph description">
+		<meta name="twitter:title" content="Twitter title">
+		<meta property="og:title" content="Go 1.5.1 is released">
+		<meta property="og:image" content="https://blog.golang.org/gopher.png">
+		<meta name="twitter:card" content="summary_large_image">
+		<meta name="author" content="Andrew Gerrand">
+		<meta property="og:site_name" content="The Go Blog">
+		<meta property="article:published_time" content="2015-09-08T16:00:00Z">
+		</head><body><meta property="og:title" content="Ignored"></body></html>`
+
+	metadata, err := extractMetadata(testPage("text/html", page))
+	if err != nil {
+		t.Fatalf("Failed to extract metadata: %v", err)
+	}
+	expected := PageMetadata{
+		Title:       "Go 1.5.1 is released",
+		Description: "Open Graph description",
+		Image:       "https://blog.golang.org/gopher.png",
+		Card:        "summary_large_image",
+		Author:      "Andrew Gerrand",
+		SiteName:    "The Go Blog",
+		Published:   time.Date(2015, time.September, 8, 16, 0, 0, 0, time.UTC),
+	}
+	if metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, metadata)
+	}
+
+	metadata, _ = extractMetadata(testPage("text/html; charset=UTF-8",
+		`<html><head><title> Fallback &amp; title </title></head></html>`))
+	if metadata.Title != "Fallback & title" {
+		t.Errorf("Expected the <title> to be used without Open Graph tags, got %q", metadata.Title)
+	}
+
+	//"Café" in ISO-8859-1, with the charset only given by the page.
+	metadata, _ = extractMetadata(testPage("application/xhtml+xml",
+		"<html><head><meta charset=\"iso-8859-1\"><title>Caf\xe9</title></head></html>"))
+	if metadata.Title != "Café" {
+		t.Errorf("Expected the title to be decoded from ISO-8859-1, got %q", metadata.Title)
+	}
+
+	metadata, _ = extractMetadata(testPage("text/html; charset=windows-1252",
+		`<html><head><meta property="og:published_time" content="2015-11-14"><title>Date</title></head></html>`))
+	if !metadata.Published.Equal(time.Date(2015, time.November, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a date only published time to be parsed, got %v", metadata.Published)
+	}
+
+	if _, err := extractMetadata(testPage("application/pdf", "%PDF")); err == nil {
+		t.Errorf("Expected an error for a page that isn't HTML")
+	}
+}
+
+func TestFeedItemMetadata(t *testing.T) {
+	item := &FeedItem{description: "<ul><li>tweet</li></ul>", TweetScore: TweetScore{
+		Address:     "https://blog.golang.org/go1.5.1",
+		Title:       "Go 1.5.1 is released",
+		Description: "Open Graph description",
+		Image:       "https://blog.golang.org/gopher.png",
+		SiteName:    "The Go Blog",
+	}}
+
+	entry := item.getItem()
+	if entry.Description != "Open Graph description" || entry.Content != "<ul><li>tweet</li></ul>" {
+		t.Errorf("Expected the page description as summary and tweets as content, got %q and %q",
+			entry.Description, entry.Content)
+	}
+	if entry.Author == nil || entry.Author.Name != "The Go Blog" {
+		t.Errorf("Expected the site name as author, got %v", entry.Author)
+	}
+	if entry.Enclosure == nil || entry.Enclosure.Type != "image/png" {
+		t.Errorf("Expected the image as an enclosure, got %v", entry.Enclosure)
+	}
+
+	item = &FeedItem{description: "tweets", TweetScore: TweetScore{Address: "https://a.com"}}
+	entry = item.getItem()
+	if entry.Title != "https://a.com" || entry.Description != "tweets" || entry.Author != nil {
+		t.Errorf("Expected an entry without metadata to fall back, got %+v", entry)
+	}
+}
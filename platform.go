@@ -0,0 +1,47 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/urlfetch"
+)
+
+//ContextFunc creates the context a request is handled with.  Besides the usual
+// deadlines, the context carries the log.Logger and *http.Client the handlers use.
+type ContextFunc func(request *http.Request) context.Context
+
+//httpClientKey is the context key the outgoing *http.Client is stored under.
+type httpClientKey struct{}
+
+//WithHTTPClient returns a copy of c whose outgoing requests are made with client.
+func WithHTTPClient(c context.Context, client *http.Client) context.Context {
+	return context.WithValue(c, httpClientKey{}, client)
+}
+
+//httpClient returns the client attached to c, or http.DefaultClient.
+func httpClient(c context.Context) *http.Client {
+	if client, ok := c.Value(httpClientKey{}).(*http.Client); ok {
+		return client
+	}
+	return http.DefaultClient
+}
+
+//AppEngineContext is the ContextFunc used on App Engine.  Messages go to the
+// request log and outgoing requests go through urlfetch.
+func AppEngineContext(request *http.Request) context.Context {
+	c := appengine.NewContext(request)
+	c = log.WithLogger(c, log.AppEngine{})
+	return WithHTTPClient(c, urlfetch.Client(c))
+}
+
+//StandaloneContext returns a ContextFunc for running outside of App Engine, that
+// logs to logger and makes outgoing requests with client.
+func StandaloneContext(logger log.Logger, client *http.Client) ContextFunc {
+	return func(request *http.Request) context.Context {
+		c := log.WithLogger(request.Context(), logger)
+		return WithHTTPClient(c, client)
+	}
+}
@@ -0,0 +1,162 @@
+package tweetharvest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//searchEndpoint is the Twitter API endpoint the TweetRetriever calls.
+const searchEndpoint = "search/tweets"
+
+//RateLimit records what Twitter last told us about the rate limit of an endpoint.
+// Limit and Remaining are the calls allowed in the window that ends at Reset.
+// NextAllowed is set when the endpoint has turned us away, or would, and no call
+// is made to it before then.  Backoffs counts the calls turned away in a row.
+type RateLimit struct {
+	Endpoint    string    `json:"endpoint"`
+	Limit       int       `json:"limit"`
+	Remaining   int       `json:"remaining"`
+	Reset       time.Time `json:"reset"`
+	NextAllowed time.Time `json:"next_allowed"`
+	Backoffs    int       `json:"backoffs"`
+	Updated     time.Time `json:"updated"`
+}
+
+const rateLimitKind string = "RateLimit"
+
+//rateLimitBackoff is the wait after being turned away without a reset time,
+// doubled each time it happens again up to maxRateLimitBackoff, one rate limit
+// window.
+const (
+	rateLimitBackoff    = time.Minute
+	maxRateLimitBackoff = 15 * time.Minute
+)
+
+//rateLimitJitter returns a random wait up to d, added to each backoff so that
+// harvests deferred together don't all come back at once.  Tests replace it.
+var rateLimitJitter = func(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+//RateLimitHeaders are the x-rate-limit headers of a Twitter API response.
+type RateLimitHeaders struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+//parseRateLimitHeaders reads the x-rate-limit headers, returning nil if the
+// response doesn't have them.
+func parseRateLimitHeaders(header http.Header) *RateLimitHeaders {
+	limit, err := strconv.Atoi(header.Get("X-Rate-Limit-Limit"))
+	if err != nil {
+		return nil
+	}
+	remaining, err := strconv.Atoi(header.Get("X-Rate-Limit-Remaining"))
+	if err != nil {
+		return nil
+	}
+	reset, err := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &RateLimitHeaders{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
+
+//twitterRateLimitCode is the error code Twitter gives with a 429.
+const twitterRateLimitCode = 88
+
+//rateLimited reports whether err is the API turning a call away, with a 429 or
+// the 420 of older endpoints, and returns the headers that came with it.
+func rateLimited(err error) (bool, *RateLimitHeaders) {
+	apiErr, ok := err.(*anaconda.ApiError)
+	if !ok {
+		return false, nil
+	}
+	headers := parseRateLimitHeaders(apiErr.Header)
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == 420 {
+		return true, headers
+	}
+	for _, twitterErr := range apiErr.Decoded.Errors {
+		if twitterErr.Code == twitterRateLimitCode {
+			return true, headers
+		}
+	}
+	return false, headers
+}
+
+//RateLimitError is returned instead of calling an endpoint that has turned us
+// away until NextAllowed.
+type RateLimitError struct {
+	Endpoint    string
+	NextAllowed time.Time
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit of %v reached, next call allowed at %v.",
+		err.Endpoint, err.NextAllowed.Format(time.RFC3339))
+}
+
+//allowed returns a RateLimitError if the endpoint can't be called at now.
+func (limit *RateLimit) allowed(now time.Time) error {
+	if now.Before(limit.NextAllowed) {
+		return &RateLimitError{Endpoint: limit.Endpoint, NextAllowed: limit.NextAllowed}
+	}
+	return nil
+}
+
+//observe records the headers of a call that succeeded.  Once the window's calls
+// are used up, none are allowed until it resets.
+func (limit *RateLimit) observe(headers *RateLimitHeaders, now time.Time) {
+	limit.Backoffs = 0
+	limit.Updated = now
+	if headers == nil {
+		return
+	}
+	limit.Limit = headers.Limit
+	limit.Remaining = headers.Remaining
+	limit.Reset = headers.Reset
+	if headers.Remaining <= 0 {
+		limit.NextAllowed = headers.Reset
+	}
+}
+
+//backoff records a call that was turned away and returns the RateLimitError to
+// defer the harvest with.  The endpoint isn't called again until the window
+// resets, or if Twitter didn't say when that is, for a backoff that doubles each
+// time.  Either way some jitter is added.
+func (limit *RateLimit) backoff(headers *RateLimitHeaders, now time.Time) error {
+	wait := rateLimitBackoff << uint(limit.Backoffs)
+	if wait > maxRateLimitBackoff || wait <= 0 {
+		wait = maxRateLimitBackoff
+	}
+	if headers != nil {
+		limit.Limit = headers.Limit
+		limit.Reset = headers.Reset
+		if headers.Reset.After(now) {
+			wait = headers.Reset.Sub(now)
+		}
+	}
+	limit.Remaining = 0
+	limit.Backoffs++
+	limit.Updated = now
+	limit.NextAllowed = now.Add(wait + rateLimitJitter(wait/4+time.Second))
+	return limit.allowed(now)
+}
+
+//retryAfter returns the Retry-After header for a response deferred until next.
+func retryAfter(next time.Time, now time.Time) string {
+	seconds := int(next.Sub(now)/time.Second) + 1
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,78 @@
+package tweetharvest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+func TestRateLimited(t *testing.T) {
+	reset := time.Unix(1700000000, 0)
+	header := http.Header{
+		"X-Rate-Limit-Limit":     {"180"},
+		"X-Rate-Limit-Remaining": {"0"},
+		"X-Rate-Limit-Reset":     {"1700000000"},
+	}
+
+	tests := []struct {
+		err     error
+		limited bool
+		headers bool
+	}{
+		{&anaconda.ApiError{StatusCode: http.StatusTooManyRequests, Header: header}, true, true},
+		{&anaconda.ApiError{StatusCode: 420, Header: http.Header{}}, true, false},
+		{&anaconda.ApiError{StatusCode: http.StatusForbidden, Header: http.Header{},
+			Decoded: anaconda.TwitterErrorResponse{Errors: []anaconda.TwitterError{{Code: 88}}}}, true, false},
+		{&anaconda.ApiError{StatusCode: http.StatusInternalServerError, Header: header}, false, true},
+		{nil, false, false},
+	}
+	for _, test := range tests {
+		limited, headers := rateLimited(test.err)
+		if limited != test.limited || (headers != nil) != test.headers {
+			t.Errorf("rateLimited(%v) = %v, %+v", test.err, limited, headers)
+		}
+		if headers != nil && (headers.Limit != 180 || headers.Remaining != 0 || !headers.Reset.Equal(reset)) {
+			t.Errorf("Headers read wrong, got %+v", headers)
+		}
+	}
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	defer func(jitter func(time.Duration) time.Duration) { rateLimitJitter = jitter }(rateLimitJitter)
+	rateLimitJitter = func(time.Duration) time.Duration { return time.Second }
+	now := time.Now()
+	limit := &RateLimit{Endpoint: searchEndpoint}
+
+	//Without a reset time, the wait doubles each time up to one window.
+	for _, wait := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute,
+		8 * time.Minute, 15 * time.Minute, 15 * time.Minute} {
+
+		err, ok := limit.backoff(nil, now).(*RateLimitError)
+		if !ok || !err.NextAllowed.Equal(now.Add(wait+time.Second)) {
+			t.Errorf("Expected to wait %v, got %v", wait, err)
+		}
+	}
+	if limit.allowed(now.Add(15*time.Minute)) == nil || limit.allowed(now.Add(16*time.Minute)) != nil {
+		t.Errorf("Expected calls to be allowed only after the backoff, got %+v", limit)
+	}
+
+	//A reset time is waited for, and a call that succeeds starts the backoff again.
+	limit.observe(&RateLimitHeaders{Limit: 180, Remaining: 10, Reset: now.Add(time.Minute)}, now)
+	reset := now.Add(5 * time.Minute)
+	limit.backoff(&RateLimitHeaders{Limit: 180, Reset: reset}, now)
+	if !limit.NextAllowed.Equal(reset.Add(time.Second)) || limit.Backoffs != 1 {
+		t.Errorf("Expected to wait for the reset, got %+v", limit)
+	}
+
+	//Using up the window's calls defers the next until it resets.
+	limit = &RateLimit{Endpoint: searchEndpoint}
+	limit.observe(&RateLimitHeaders{Limit: 180, Remaining: 0, Reset: reset}, now)
+	if err := limit.allowed(now); err == nil {
+		t.Errorf("Expected no calls once the remaining calls are used up")
+	}
+	if retryAfter(reset, now) != "301" {
+		t.Errorf("Expected Retry-After of 301 seconds, got %v", retryAfter(reset, now))
+	}
+}
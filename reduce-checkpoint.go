@@ -0,0 +1,15 @@
+package tweetharvest
+
+import "time"
+
+//ReduceCheckpoint records how far the reduce stage has got with a query.  Sequence
+// is that of the last LinkTweet counted in the query's scores; it is only ever
+// written in the same transaction as those scores, so the next run starts after
+// it without counting any tweet twice.
+type ReduceCheckpoint struct {
+	Query    string
+	Sequence int64
+	Updated  time.Time
+}
+
+const reduceCheckpointKind string = "ReduceCheckpoint"
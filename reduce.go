@@ -1,212 +1,360 @@
 package tweetharvest
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/net/context"
-	"golang.org/x/net/html"
-
-	"github.com/ChimeraCoder/anaconda"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
+	"github.com/AndyNortrup/TweetHarvest/log"
 )
 
 //Reducer is an instance of an HTTP server
 type Reducer struct {
-	c context.Context
+	c          context.Context
+	store      Store
+	scorer     Scorer
+	cache      *FeedCache
+	newContext ContextFunc
 }
 
-//Constans are used to standardize strings used for data access from the Datastore.
-const tweetScoreKind string = "TweetScore"
-const scoreKey string = "Scores"
-const scoreKeyID string = "default_scorestore"
+//errCheckpointMoved is returned by UpdateScores when another reduce has moved the
+// query's checkpoint since the batch was read.
+var errCheckpointMoved = errors.New("Reduce checkpoint has moved.")
+
+//reduceWorkers is the number of pages fetched for new scores at once.
+const reduceWorkers = 8
+
+//reduceBatchSize is the largest number of tweets whose scores are written in one
+// transaction with the checkpoint that follows them.
+const reduceBatchSize = 250
+
+//ReduceSummary reports the work done by a reduce run.
+type ReduceSummary struct {
+	Tweets    int `json:"tweets"`
+	Addresses int `json:"addresses"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Failed    int `json:"failed"`
+	Blocked   int `json:"blocked"`
+}
+
+//scoreID identifies the score an address has under a query.
+type scoreID struct {
+	query   string
+	address string
+}
 
 //ServeHTTP is an Handler for Process requests.  It serves as the reduce function of
 // the system, creating a score, for each of the addresses found in tweets.
 func (reduce Reducer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	reduce.c = appengine.NewContext(request)
+	reduce.c = reduce.newContext(request)
 	log.Infof(reduce.c, "Starting Reduce Processing.")
 
-	//Wait group keeps the process open until all go routines are complete inc to 1 for
-	// the calculatenewScores routines
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	//tweets is a channel for holding TweetIDs
-	tweets := make(chan *anaconda.Tweet)
-	go reduce.queryForTweets(tweets, &wg)
+	summary, err := reduce.reduce()
+	if err != nil {
+		log.Errorf(reduce.c, "Reduce failed. %v", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	//Hold until updateDatastore is compelete
-	wg.Wait()
-	writer.WriteHeader(http.StatusOK)
+	log.Infof(reduce.c, "Reduced %v tweets into %v addresses: %v created, %v updated, %v failed, %v blocked.",
+		summary.Tweets, summary.Addresses, summary.Created, summary.Updated, summary.Failed, summary.Blocked)
+	writeJSON(writer, http.StatusOK, summary)
 }
 
-func (reduce Reducer) queryForTweets(out chan<- *anaconda.Tweet, wg *sync.WaitGroup) {
-	defer wg.Done()
-	//lastProcess := reduce.getLastProcessedTweet()
+//reduce scores the tweets of each query stored since its ReduceCheckpoint.  A
+// query that fails is left at its last checkpoint for the next run while the
+// other queries carry on, and the error is returned once every query has been
+// reduced.  Scores that couldn't be written are also counted in Failed.
+func (reduce Reducer) reduce() (ReduceSummary, error) {
+	var summary ReduceSummary
 
+	policies, err := reduce.store.GetDomainPolicies(reduce.c)
+	if err != nil {
+		log.Errorf(reduce.c, "Failed to get domain policies from store. %v", err.Error())
+		return summary, err
+	}
+
+	queries, err := reduce.store.TweetQueries(reduce.c)
+	if err != nil {
+		log.Errorf(reduce.c, "Failed to get tweet queries from store. %v", err.Error())
+		return summary, err
+	}
+
+	var failed error
+	for _, query := range queries {
+		if err := reduce.reduceQuery(query, policies, &summary); err != nil {
+			log.Errorf(reduce.c, "Failed to reduce %v. %v", query, err.Error())
+			failed = err
+		}
+	}
+	return summary, failed
 }
-func (reduce Reducer) calculateNewScores(out chan<- *TweetScore, wg *sync.WaitGroup) {
-	log.Infof(reduce.c, "Calculating New Scores")
 
-	//Tell the wait group that the method is done when it completes.
-	defer wg.Done()
+//reduceQuery scores the query's tweets after its checkpoint, reduceBatchSize at a
+// time.  The scores of each batch are written in the same transaction as the
+// checkpoint that moves past it, so a run that stops part way through is resumed
+// from the last batch written and no tweet is counted twice.  A batch that
+// another reduce has written meanwhile is dropped, and the run carries on from
+// the checkpoint that reduce left.
+func (reduce Reducer) reduceQuery(query string, policies DomainPolicies, summary *ReduceSummary) error {
+	checkpoint, err := reduce.store.GetReduceCheckpoint(reduce.c, query)
+	if err != nil {
+		return err
+	}
 
-	//Score map holds a mapping of addresses to their scores.
-	score := make(map[string]*TweetScore)
+	//A query reduced before checkpoints were kept has none, and its tweets up to
+	// the newest in its scores have been counted already.
+	var sequence int64
+	var counted time.Time
+	if checkpoint != nil {
+		sequence = checkpoint.Sequence
+	} else if counted, err = reduce.store.LastQueryActivity(reduce.c, query); err != nil {
+		return err
+	}
 
-	//Get all new tweets since the last time the reduce process was run than range over
-	// those tweets.
-	tweets := GetAllNewTweets(reduce.getLastProcessedTweet(), reduce.c)
-	for _, data := range tweets {
+	for {
+		tweets, err := reduce.store.GetTweetsAfter(reduce.c, query, sequence, reduceBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(tweets) == 0 {
+			return nil
+		}
+		next := &ReduceCheckpoint{
+			Query:    query,
+			Sequence: tweets[len(tweets)-1].Sequence,
+			Updated:  time.Now(),
+		}
 
-		//If the map does not contain a key for this address, create a new value and add
-		// it to the map.
-		if score[data.Address] == nil {
-			score[data.Address] = &TweetScore{
-				Address: data.Address,
-				Query:   data.Query,
+		uncounted := make(LinkTweets, 0, len(tweets))
+		for _, tweet := range tweets {
+			if created, _ := tweet.CreatedAtTime(); created.After(counted) {
+				uncounted = append(uncounted, tweet)
 			}
 		}
 
-		//Update the score, LastAvtive, and TweetIDs data with the current tweet
-		score[data.Address].Score = score[data.Address].Score + data.getScore()
-		score[data.Address].LastActive, _ = data.CreatedAtTime()
-		score[data.Address].TweetIDs = append(score[data.Address].TweetIDs, data.Id)
-	}
+		scores, blocked := reduce.calculateNewScores(uncounted, policies)
+		created, untitled, added, err := reduce.writeScores(scores, sequence, next)
+		if err == errCheckpointMoved {
+			log.Infof(reduce.c, "Another reduce has moved the checkpoint of %v, resuming from it.", query)
+			if checkpoint, err = reduce.store.GetReduceCheckpoint(reduce.c, query); err != nil {
+				return err
+			}
+			if checkpoint != nil {
+				sequence = checkpoint.Sequence
+			}
+			continue
+		}
+		if err != nil {
+			summary.Failed += len(scores)
+			return err
+		}
 
-	//Range over the map and output the values into the channel for further processing
-	for _, data := range score {
-		log.Infof(reduce.c, "Calculate: Address: %v\tScore: %v", data.Address, data.Score)
-		out <- data
+		summary.Tweets += len(uncounted)
+		summary.Blocked += blocked
+		summary.Addresses += len(scores)
+		for _, score := range scores {
+			if created[score.Address] {
+				summary.Created++
+			} else {
+				summary.Updated++
+			}
+			reduce.updateDomain(score, added[score.Address], created[score.Address])
+		}
+		reduce.fetchMetadata(scores, untitled)
+		if len(scores) > 0 {
+			//The cached feed of the query is out of date, now that the new scores
+			// have their titles.
+			reduce.cache.Invalidate(query)
+		}
+
+		sequence = next.Sequence
+		if len(tweets) < reduceBatchSize {
+			return nil
+		}
 	}
-	//Close the channel to indicate that there is no more data
-	close(out)
 }
 
-//updateDataStoreScore is a method that updates or creates records in the datastore with
-// with the contents of the a TweetScore struct
-func (reduce Reducer) updateDataStoreScore(score TweetScore, wg *sync.WaitGroup) {
-	//At the end of the method close the score
-	defer wg.Done()
+//calculateNewScores scores the tweets for each address and query with the
+// Reducer's Scorer, boosted by the policy of the address's domain.  Addresses on
+// blocked domains are counted in blocked instead.
+func (reduce Reducer) calculateNewScores(tweets LinkTweets,
+	policies DomainPolicies) (out []*TweetScore, blocked int) {
 
-	//Get the existing score for this address from the datastore.
-	//log.Infof(reduce.c, "Pulling existing score for address: %v", score.Address)
-	q := datastore.NewQuery(tweetScoreKind).
-		Filter("Address =", score.Address).
-		Ancestor(getTweetScoreKey(reduce.c))
+	log.Infof(reduce.c, "Calculating New Scores")
 
-	iterator := q.Run(reduce.c)
+	//Score map holds a mapping of addresses to their scores, and shared holds the
+	// tweets that link to each of them.
+	score := make(map[scoreID]*TweetScore)
+	shared := make(map[scoreID]LinkTweets)
 
-	oldScore := &TweetScore{}
-	key, err := iterator.Next(oldScore)
-	if err != nil && err == datastore.Done {
-		//log.Infof(reduce.c, "No old score exists for this address.")
-		//No old score exists, so we just add the new one
+	for _, data := range tweets {
+		//Tweets stored before addresses were canonicalized, or under older rules,
+		// are scored with the address they would have today.
+		original := data.OriginalAddress
+		if original == "" {
+			original = data.Address
+		}
+		data.Address = DefaultCanonicalizer.Canonical(original)
+		id := scoreID{query: data.Query, address: data.Address}
 
-		oldScore.Title, err = reduce.
-			getTitle(score.Address)
-		if err != nil {
-			log.Infof(reduce.c, "Failed to GET address: %v \n\t%v", score.Address, err.Error())
+		//If the map does not contain a key for this address, create a new value and add
+		// it to the map.
+		if score[id] == nil {
+			score[id] = &TweetScore{
+				Address:         data.Address,
+				Query:           data.Query,
+				OriginalAddress: original,
+			}
 		}
-		oldScore.Address = score.Address
-		oldScore.LastActive = score.LastActive
-		oldScore.Score = score.Score
-		oldScore.TweetIDs = score.TweetIDs
-		oldScore.Query = score.Query
 
-		//Create a new key
-		key = datastore.NewIncompleteKey(reduce.c, tweetScoreKind, getTweetScoreKey(reduce.c))
-	} else {
-		//We have an old score, increment the score, add new TweetIDs, and update LastActive
-		//log.Infof(reduce.c, "Old score for this address is: %v", oldScore.Score)
-		oldScore = &TweetScore{Score: score.Score,
-			LastActive: score.LastActive,
-			TweetIDs:   score.TweetIDs,
+		//Update the LastAvtive, FirstSeen and TweetIDs data with the current tweet
+		created, _ := data.CreatedAtTime()
+		if created.After(score[id].LastActive) {
+			score[id].LastActive = created
 		}
+		if score[id].FirstSeen.IsZero() || created.Before(score[id].FirstSeen) {
+			score[id].FirstSeen = created
+		}
+		score[id].TweetIDs = append(score[id].TweetIDs, data.Id)
+		shared[id] = append(shared[id], data)
 	}
 
-	//log.Infof(reduce.c, "Writing to database: %v\t%v", oldScore.Address, oldScore.Score)
-	datastore.Put(reduce.c, key, oldScore)
+	//Range over the map and output the values for further processing
+	for id, data := range score {
+		if policies.blocked(data.Address) {
+			blocked++
+			continue
+		}
+		data.Shares = reduce.scorer.Score(shared[id])
+		for i := range data.Shares {
+			data.Shares[i].Score = policies.boost(data.Address, data.Shares[i].Score)
+		}
+		data.Score = shareTotal(data.Shares)
+		log.Infof(reduce.c, "Calculate: Address: %v\tScore: %v", data.Address, data.Score)
+		out = append(out, data)
+	}
+	return out, blocked
 }
 
-//getTitle retrives the content of an address then sends the body to the scraper
-// to in order to find the title which is returnted to the user.
-func (reduce Reducer) getTitle(address string) (string, error) {
-	client := urlfetch.Client(reduce.c)
-	resp, err := client.Get(address)
-
+//updateDomain adds what a write added to a score to the Domain of its address.
+// Failing to do so is logged but doesn't fail the score.
+func (reduce Reducer) updateDomain(score *TweetScore, added int, created bool) {
+	name := addressDomain(score.Address)
+	if name == "" {
+		return
+	}
+	err := reduce.store.UpdateDomain(reduce.c, score.Query, name,
+		func(domain *Domain, exists bool) error {
+			domain.addScore(score, added, created)
+			return nil
+		})
 	if err != nil {
-		return "", err
+		log.Errorf(reduce.c, "Failed to update domain %v. %v", name, err.Error())
 	}
-	return reduce.scrapeTitle(resp)
 }
 
-//scrapeTitle parses through an HTML document to find the <title> tag then get
-// the content of that tag and return it to the user.
-func (reduce Reducer) scrapeTitle(resp *http.Response) (string, error) {
-	defer resp.Body.Close()
+//writeScores merges the scores into those in the store and moves the checkpoint on
+// from the sequence the batch was read after, as a single transaction.  created
+// holds the addresses that had no score before, untitled those whose stored score
+// has no title yet, and added how much each stored score went up by.
+func (reduce Reducer) writeScores(scores []*TweetScore, from int64, checkpoint *ReduceCheckpoint) (
+	created map[string]bool, untitled map[string]bool, added map[string]int, err error) {
+
+	created = make(map[string]bool)
+	untitled = make(map[string]bool)
+	added = make(map[string]int)
+	batch := make(map[string]*TweetScore, len(scores))
+	addresses := make([]string, len(scores))
+	for i, score := range scores {
+		batch[score.Address] = score
+		addresses[i] = score.Address
+	}
 
-	if strings.ToLower(resp.Header.Get("Content-Type")) != "text/html; charset=utf-8" {
-		message := "Wrong content type.  Recieved: " + resp.Header.Get("Content-Type") + " from " + resp.Request.URL.String()
-		log.Infof(reduce.c, message)
-		return "", errors.New(message)
+	//The store may retry the transaction, so each address's flags are set again by
+	// every attempt rather than added to.
+	err = reduce.store.UpdateScores(reduce.c, addresses, from, checkpoint,
+		func(oldScore *TweetScore, exists bool) error {
+			score := batch[oldScore.Address]
+			created[score.Address] = !exists
+			untitled[score.Address] = oldScore.Title == ""
+			before := oldScore.Score
+			oldScore.merge(score)
+			added[score.Address] = oldScore.Score - before
+			return nil
+		})
+	if err != nil {
+		if err != errCheckpointMoved {
+			log.Errorf(reduce.c, "Failed to write scores for %v. %v", checkpoint.Query, err.Error())
+		}
+		return nil, nil, nil, err
 	}
+	return created, untitled, added, nil
+}
 
-	tokenizer := html.NewTokenizer(resp.Body)
-	var titleIsNext bool
-	for {
-		token := tokenizer.Next()
-		switch {
-		case token == html.ErrorToken:
-			log.Infof(reduce.c, "Hit the end of the doc without finding title.")
-			return "", errors.New("Unable to find title tag in " + resp.Request.URL.String())
-		case token == html.StartTagToken:
-			tag := tokenizer.Token()
-			isTitle := tag.Data == "title"
-
-			if isTitle {
-				titleIsNext = true
+//fetchMetadata fetches the page of each of the scores that has no title yet,
+// reduceWorkers at a time, and saves its metadata to the score.  A page that
+// can't be fetched is logged and leaves its score without metadata, to be tried
+// again the next time the address is reduced.
+func (reduce Reducer) fetchMetadata(scores []*TweetScore, untitled map[string]bool) {
+	in := make(chan *TweetScore)
+	var wg sync.WaitGroup
+	wg.Add(reduceWorkers)
+	for i := 0; i < reduceWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for score := range in {
+				reduce.updateMetadata(score)
 			}
-		case titleIsNext && token == html.TextToken:
-			title := tokenizer.Token().Data
-			log.Infof(reduce.c, "Pulled title: %v", title)
-			return title, nil
+		}()
+	}
+	for _, score := range scores {
+		if untitled[score.Address] {
+			in <- score
 		}
 	}
+	close(in)
+	wg.Wait()
 }
 
-//getLastProcessedTweet gets the last date that any score was active,
-// which should be the date of the last processed tweet
-func (reduce Reducer) getLastProcessedTweet() time.Time {
-	//Get a single value from the datastore with the newest date
-	q := datastore.NewQuery(tweetScoreKind).
-		Project("LastActive").
-		Order("-LastActive").Limit(1)
-
-	i := q.Run(reduce.c)
-
-	score := &TweetScore{}
-	_, err := i.Next(score)
+//updateMetadata fetches the page of a score and saves its metadata to the score,
+// unless another run has given the score a title in the meantime.  If the page
+// can't be fetched the score is left alone for a later run to try again.
+func (reduce Reducer) updateMetadata(score *TweetScore) {
+	metadata, err := reduce.getMetadata(score.link())
 	if err != nil {
-		//If there is no date (I.E. this is the first time we have run this process) return
-		// a date of one day prior, to keep processing size under control.
-		log.Infof(reduce.c, "Could not find last processed tweet date, returning one day ago")
-		return time.Now().AddDate(0, 0, -1)
+		log.Errorf(reduce.c, "Failed to GET address: %v \n\t%v", score.Address, err.Error())
+		return
+	}
+	err = reduce.store.UpdateScore(reduce.c, score.Query, score.Address, func(stored *TweetScore, exists bool) error {
+		if !exists {
+			return errors.New("score was removed")
+		}
+		if stored.Title == "" {
+			stored.setMetadata(metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf(reduce.c, "Failed to write metadata for %v. %v", score.Address, err.Error())
 	}
-	//log.Infof(reduce.c, "Last tweet scored is dated: %v", score.LastActive)
-	return score.LastActive
 }
 
-//getTweetScoreKey returns the same key every time so that all TweetScore entites have
-// a common Ancestor
-func getTweetScoreKey(c context.Context) *datastore.Key {
-	// The string "default_guestbook" here could be varied to have multiple guestbooks.
-	return datastore.NewKey(c, scoreKey, scoreKeyID, 0, nil)
+//getMetadata retrives the content of an address then sends the body to the
+// scraper in order to find the title and other metadata of the page.
+func (reduce Reducer) getMetadata(address string) (PageMetadata, error) {
+	client := httpClient(reduce.c)
+	resp, err := client.Get(address)
+
+	if err != nil {
+		return PageMetadata{}, err
+	}
+	metadata, err := extractMetadata(resp)
+	if metadata.Title != "" {
+		log.Infof(reduce.c, "Pulled title: %v", metadata.Title)
+	}
+	return metadata, err
 }
@@ -0,0 +1,319 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+func TestReduceScoresNewTweets(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>Page %v</title>
+			<meta property="og:description" content="About %v"></head><body></body></html>`,
+			r.URL.Path, r.URL.Path)
+	}))
+	defer pages.Close()
+	a, b := pages.URL+"/a", pages.URL+"/b"
+
+	shared := testLinkTweet(3, a, "golang", now.Add(-30*time.Minute))
+	shared.User.Id = 42
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, a, "golang", now.Add(-2*time.Hour)),
+		testLinkTweet(2, b, "golang", now.Add(-time.Hour)),
+		shared,
+	})
+
+	router := NewRouter(testServices(store, nil))
+	summary := runReduce(t, router)
+	expected := ReduceSummary{Tweets: 3, Addresses: 2, Created: 2}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	scores, err := store.RecentScores(c, "golang", now.Add(-24*time.Hour))
+	if err != nil || len(scores) != 2 {
+		t.Fatalf("Expected 2 scores, got %v, %v", len(scores), err)
+	}
+	first := scores[0]
+	if first.Address != DefaultCanonicalizer.Canonical(a) || first.OriginalAddress != a || first.Score != 4 || len(first.TweetIDs) != 2 ||
+		!first.LastActive.Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("Unexpected score for %v: %+v", a, first)
+	}
+	if first.Title != "Page /a" || scores[1].Title != "Page /b" {
+		t.Errorf("Expected titles from the pages, got %q and %q", first.Title, scores[1].Title)
+	}
+	if first.Description != "About /a" {
+		t.Errorf("Expected the description from the page, got %q", first.Description)
+	}
+
+	//Only the tweet stored since the checkpoint is reduced on the next run.
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(4, b, "golang", now)})
+	summary = runReduce(t, router)
+	expected = ReduceSummary{Tweets: 1, Addresses: 1, Updated: 1}
+	if summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+}
+
+func TestReduceMergesExistingScores(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+
+	var fetched int32
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><title>Page %v</title></head><body></body></html>`, r.URL.Path)
+	}))
+	defer pages.Close()
+	a, b := pages.URL+"/a", pages.URL+"/b"
+
+	//a has a title, b's page couldn't be fetched when its score was created.
+	store.UpdateScore(c, "golang", DefaultCanonicalizer.Canonical(a), func(score *TweetScore, exists bool) error {
+		score.Title = "Kept"
+		score.OriginalAddress = a
+		score.Score = 5
+		score.TweetIDs = []int64{1}
+		score.FirstSeen = now.Add(-48 * time.Hour)
+		score.LastActive = now.Add(-time.Hour)
+		return nil
+	})
+	store.UpdateScore(c, "golang", DefaultCanonicalizer.Canonical(b), func(score *TweetScore, exists bool) error {
+		score.OriginalAddress = b
+		score.Score = 2
+		score.TweetIDs = []int64{3}
+		score.LastActive = now
+		return nil
+	})
+
+	//Tweet 1 was harvested again, and tweet 4 is older than b's last activity.
+	again := testLinkTweet(1, a, "golang", now.Add(-2*time.Hour))
+	newer := testLinkTweet(2, a, "golang", now.Add(-30*time.Minute))
+	newer.User.Id = 42
+	store.PutLinkTweets(c, LinkTweets{again, newer, testLinkTweet(4, b, "golang", now.Add(-3*time.Hour))})
+	store.UpdateScores(c, nil, 0, &ReduceCheckpoint{Query: "golang"},
+		func(score *TweetScore, exists bool) error { return nil })
+
+	summary := runReduce(t, NewRouter(testServices(store, nil)))
+	expected := ReduceSummary{Tweets: 3, Addresses: 2, Updated: 2}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	scores, _ := store.RecentScores(c, "golang", now.Add(-24*time.Hour))
+	if len(scores) != 2 {
+		t.Fatalf("Expected 2 scores, got %+v", scores)
+	}
+	merged := scores[1]
+	if merged.Address != DefaultCanonicalizer.Canonical(a) || merged.Query != "golang" || merged.OriginalAddress != a ||
+		merged.Score != 9 || len(merged.TweetIDs) != 2 || merged.TweetIDs[0] != 1 || merged.TweetIDs[1] != 2 {
+		t.Errorf("Expected the new tweets added to a's score, got %+v", merged)
+	}
+	if !merged.LastActive.Equal(now.Add(-30*time.Minute)) || !merged.FirstSeen.Equal(now.Add(-48*time.Hour)) {
+		t.Errorf("Expected LastActive to advance and FirstSeen to be kept, got %v and %v",
+			merged.LastActive, merged.FirstSeen)
+	}
+	if merged.Title != "Kept" {
+		t.Errorf("Expected the title to be kept, got %q", merged.Title)
+	}
+
+	merged = scores[0]
+	if merged.Score != 4 || !merged.LastActive.Equal(now) || !merged.FirstSeen.Equal(now.Add(-3*time.Hour)) {
+		t.Errorf("Expected LastActive to be kept and FirstSeen set for b, got %+v", merged)
+	}
+	if fetches := atomic.LoadInt32(&fetched); merged.Title != "Page /b" || fetches != 1 {
+		t.Errorf("Expected only b's missing title to be fetched, got %q after %v fetches", merged.Title, fetches)
+	}
+}
+
+func TestReduceCountsSharesOnce(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	router := NewRouter(testServices(store, nil))
+	now := time.Now().Truncate(time.Second)
+	address := DefaultCanonicalizer.Canonical("http://a.com")
+
+	original := testLinkTweet(1, address, "golang", now.Add(-time.Hour))
+	original.User.Id = 10
+	original.FavoriteCount = 3
+	original.RetweetCount = 1
+	store.PutLinkTweets(c, LinkTweets{original})
+	runReduce(t, router)
+
+	//A later retweet carries newer counts of the original, and its author posts
+	// the link again; only the original's new favorites and retweets count.
+	retweet := testLinkTweet(2, address, "golang", now)
+	retweet.User.Id = 20
+	retweet.RetweetedStatus = &anaconda.Tweet{Id: 1, FavoriteCount: 5, RetweetCount: 2}
+	retweet.RetweetedStatus.User.Id = 10
+	repost := testLinkTweet(3, address, "golang", now)
+	repost.User.Id = 10
+	store.PutLinkTweets(c, LinkTweets{retweet, repost})
+	runReduce(t, router)
+
+	scores, _ := store.AddressScores(c, address)
+	if len(scores) != 1 || scores[0].Score != 11 {
+		t.Errorf("Expected the second reduce to add only the original's new counts for 11, got %+v", scores)
+	}
+
+	//The domain is only raised by what the link's score was.
+	domains, _ := store.TopDomains(c, "golang", 10)
+	if len(domains) != 1 || domains[0].Score != 11 || domains[0].Links != 1 {
+		t.Errorf("Expected the domain to score the link's 11 once, got %+v", domains)
+	}
+}
+
+//failingScoreStore is a MemoryStore whose UpdateScores fails for query until fail
+// is cleared.
+type failingScoreStore struct {
+	*MemoryStore
+	query string
+	fail  bool
+}
+
+func (store *failingScoreStore) UpdateScores(c context.Context, addresses []string, from int64,
+	checkpoint *ReduceCheckpoint, update func(score *TweetScore, exists bool) error) error {
+
+	if store.fail && checkpoint.Query == store.query {
+		return errors.New("store unavailable")
+	}
+	return store.MemoryStore.UpdateScores(c, addresses, from, checkpoint, update)
+}
+
+func TestReduceResumesFromCheckpoint(t *testing.T) {
+	c := context.Background()
+	store := &failingScoreStore{MemoryStore: NewMemoryStore(), query: "golang", fail: true}
+	now := time.Now().Truncate(time.Second)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Page</title></head><body></body></html>`)
+	}))
+	defer pages.Close()
+	a := pages.URL + "/a"
+
+	//rust was reduced before checkpoints were kept, up to its score's LastActive.
+	store.UpdateScore(c, "rust", DefaultCanonicalizer.Canonical("http://r.com"), func(score *TweetScore, exists bool) error {
+		score.Title = "R"
+		score.Score = 2
+		score.LastActive = now.Add(-time.Hour)
+		score.TweetIDs = []int64{10}
+		return nil
+	})
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, a, "golang", now.Add(-time.Hour)),
+		testLinkTweet(10, "http://r.com", "rust", now.Add(-time.Hour)),
+		testLinkTweet(11, "http://r.com", "rust", now),
+	})
+
+	//The golang scores fail to be written, which is reported, but rust carries on
+	// without counting its old tweet again.
+	reducer := Reducer{c: c, store: store, scorer: DefaultScorer, cache: NewFeedCache()}
+	summary, err := reducer.reduce()
+	expected := ReduceSummary{Tweets: 1, Addresses: 1, Updated: 1, Failed: 1}
+	if err == nil || summary != expected {
+		t.Fatalf("Expected summary %+v and an error, got %+v, %v", expected, summary, err)
+	}
+	if checkpoint, _ := store.GetReduceCheckpoint(c, "golang"); checkpoint != nil {
+		t.Fatalf("Expected golang to be left without a checkpoint, got %+v", checkpoint)
+	}
+	router := NewRouter(testServices(store, nil))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/reduce", nil))
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("Expected /reduce to fail while golang can't be written, got %v", response.Code)
+	}
+
+	//The next run resumes golang from where it was left, and has nothing new for
+	// rust.
+	store.fail = false
+	another := testLinkTweet(2, a, "golang", now)
+	another.User.Id = 42
+	store.PutLinkTweets(c, LinkTweets{another})
+	summary = runReduce(t, router)
+	expected = ReduceSummary{Tweets: 2, Addresses: 1, Created: 1}
+	if summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	scores, _ := store.RecentScores(c, "golang", now.Add(-24*time.Hour))
+	if len(scores) != 1 || scores[0].Score != 4 || len(scores[0].TweetIDs) != 2 {
+		t.Errorf("Expected both golang tweets counted once, got %+v", scores)
+	}
+	if summary = runReduce(t, router); summary != (ReduceSummary{}) {
+		t.Errorf("Expected nothing left to reduce, got %+v", summary)
+	}
+}
+
+//runReduce runs /reduce through the router and returns its summary.
+func runReduce(t *testing.T, router http.Handler) ReduceSummary {
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/reduce", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v: %v", response.Code, response.Body.String())
+	}
+
+	var summary ReduceSummary
+	if err := json.Unmarshal(response.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	return summary
+}
+
+func TestReduceHonorsDomainPolicies(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Page</title></head><body></body></html>`)
+	}))
+	defer pages.Close()
+
+	store.PutDomainPolicy(c, &DomainPolicy{Name: "spam.example.com", Blocked: true})
+	store.PutDomainPolicy(c, &DomainPolicy{Name: "127.0.0.1", Boost: 1.5})
+	store.PutLinkTweets(c, LinkTweets{
+		testLinkTweet(1, pages.URL+"/a", "golang", now.Add(-time.Hour)),
+		testLinkTweet(2, "http://ads.spam.example.com/offer", "golang", now.Add(-time.Hour)),
+	})
+
+	router := NewRouter(testServices(store, nil))
+	summary := runReduce(t, router)
+	expected := ReduceSummary{Tweets: 2, Addresses: 1, Created: 1, Blocked: 1}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	scores, _ := store.RecentScores(c, "golang", now.Add(-24*time.Hour))
+	if len(scores) != 1 || scores[0].Score != 3 {
+		t.Fatalf("Expected only the boosted score of 2 * 1.5, got %+v", scores)
+	}
+
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(3, pages.URL+"/b", "golang", now)})
+	runReduce(t, router)
+
+	domains, _ := store.TopDomains(c, "golang", 10)
+	if len(domains) != 1 {
+		t.Fatalf("Expected only the unblocked domain, got %+v", domains)
+	}
+	domain := domains[0]
+	if domain.Name != "127.0.0.1" || domain.Score != 6 || domain.Links != 2 ||
+		!domain.FirstSeen.Equal(now.Add(-time.Hour)) || !domain.LastSeen.Equal(now) {
+		t.Errorf("Unexpected domain %+v", domain)
+	}
+}
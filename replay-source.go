@@ -0,0 +1,95 @@
+package tweetharvest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//ReplaySource is a TweetSource that serves search results recorded from the
+// Twitter API, so that harvests can run without a network and give the same
+// result every time.
+type ReplaySource struct {
+	pages map[string][]anaconda.SearchResponse
+}
+
+//NewReplaySource loads recorded search responses from the given JSON files.  A
+// file holds either one anaconda.SearchResponse or an array of them, one per page
+// in the order they were fetched.  Pages are filed under the query in their
+// search_metadata.
+func NewReplaySource(paths ...string) (*ReplaySource, error) {
+	source := &ReplaySource{pages: make(map[string][]anaconda.SearchResponse)}
+
+	for _, path := range paths {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var pages []anaconda.SearchResponse
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+			err = json.Unmarshal(raw, &pages)
+		} else {
+			pages = make([]anaconda.SearchResponse, 1)
+			err = json.Unmarshal(raw, &pages[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, page := range pages {
+			query, err := url.QueryUnescape(page.Metadata.Query)
+			if err != nil {
+				return nil, err
+			}
+			source.pages[query] = append(source.pages[query], page)
+		}
+	}
+	return source, nil
+}
+
+//Search returns the first recorded page for the query, or the page that follows
+// the one whose next_results asked for v's max_id.  Like the real API, tweets at
+// or below since_id and above max_id are left out.
+func (source *ReplaySource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	pages := source.pages[query]
+	if len(pages) == 0 {
+		return anaconda.SearchResponse{}, nil
+	}
+
+	page, found := pages[0], true
+	if maxID := v.Get("max_id"); maxID != "" {
+		found = false
+		for i := 1; i < len(pages); i++ {
+			next, err := nextPageValues(pages[i-1])
+			if err != nil {
+				return anaconda.SearchResponse{}, err
+			}
+			if next.Get("max_id") == maxID {
+				page, found = pages[i], true
+				break
+			}
+		}
+	}
+	if !found {
+		return anaconda.SearchResponse{}, nil
+	}
+
+	sinceID, _ := strconv.ParseInt(v.Get("since_id"), 10, 64)
+	maxID, _ := strconv.ParseInt(v.Get("max_id"), 10, 64)
+
+	out := page
+	out.Statuses = nil
+	for _, tweet := range page.Statuses {
+		if tweet.Id <= sinceID || (maxID > 0 && tweet.Id > maxID) {
+			continue
+		}
+		out.Statuses = append(out.Statuses, tweet)
+	}
+	return out, nil
+}
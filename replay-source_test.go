@@ -0,0 +1,54 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestReplaySourcePaging(t *testing.T) {
+	c := context.Background()
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	first, err := source.Search(c, "golang", nil)
+	if err != nil || len(first.Statuses) != 3 {
+		t.Fatalf("Expected 3 tweets on the first page, got %v, %v", len(first.Statuses), err)
+	}
+
+	v, _ := nextPageValues(first)
+	if v.Get("q") != "" {
+		t.Errorf("The query should not be part of the next page values")
+	}
+	second, _ := source.Search(c, "golang", v)
+	if len(second.Statuses) != 2 {
+		t.Fatalf("Expected 2 tweets on the second page, got %v", len(second.Statuses))
+	}
+
+	v, _ = nextPageValues(second)
+	if v != nil {
+		t.Errorf("Expected the second page to be the last, got %v", v)
+	}
+
+	empty, _ := source.Search(c, "rust", nil)
+	if len(empty.Statuses) != 0 {
+		t.Errorf("Expected no tweets for an unrecorded query")
+	}
+}
+
+func TestReplaySourceSinceID(t *testing.T) {
+	source, _ := NewReplaySource("testdata/search/golang.json")
+
+	v := url.Values{"since_id": {"665720000000000000"}}
+	result, _ := source.Search(context.Background(), "golang", v)
+	if len(result.Statuses) != 2 {
+		t.Fatalf("Expected 2 tweets newer than since_id, got %v", len(result.Statuses))
+	}
+	for _, tweet := range result.Statuses {
+		if tweet.Id <= 665720000000000000 {
+			t.Errorf("Tweet %v is not newer than since_id", tweet.Id)
+		}
+	}
+}
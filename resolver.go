@@ -0,0 +1,164 @@
+package tweetharvest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+//defaultMaxRedirects is the number of redirects a Resolver follows unless told
+// otherwise.
+const defaultMaxRedirects = 10
+
+//maxResolverCache is the number of resolutions a Resolver remembers before it
+// starts over.
+const maxResolverCache = 10000
+
+//maxCanonicalScan is the most of a page that is read looking for its canonical
+// link.
+const maxCanonicalScan = 256 * 1024
+
+var errTooManyRedirects = errors.New("Too many redirects.")
+
+//Resolver follows the redirects of shortened addresses, such as bit.ly links, to
+// the address of the article they point to.  Pages that name a canonical address
+// with <link rel="canonical"> resolve to that address.  Resolutions are cached
+// by the address that was resolved.
+type Resolver struct {
+	//MaxRedirects is the longest chain of redirects that is followed.
+	MaxRedirects int
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+//NewResolver returns a Resolver that follows up to defaultMaxRedirects redirects.
+func NewResolver() *Resolver {
+	return &Resolver{MaxRedirects: defaultMaxRedirects}
+}
+
+//Resolve returns the address that address finally leads to.  If it can't be
+// resolved, address is returned along with the error.
+func (resolver *Resolver) Resolve(c context.Context, address string) (string, error) {
+	if final, ok := resolver.cached(address); ok {
+		return final, nil
+	}
+
+	final, err := resolver.follow(c, address)
+	if err != nil {
+		return address, err
+	}
+	resolver.remember(address, final)
+	return final, nil
+}
+
+//follow requests the address, following redirects, and returns the address of the
+// page that was reached or the canonical address it gives.
+func (resolver *Resolver) follow(c context.Context, address string) (string, error) {
+	maxRedirects := resolver.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	client := *httpClient(c)
+	client.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return errTooManyRedirects
+		}
+		return nil
+	}
+
+	request, err := http.NewRequest("GET", address, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := client.Do(request.WithContext(c))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	final := response.Request.URL
+	if !isHTML(response.Header.Get("Content-Type")) {
+		return final.String(), nil
+	}
+	canonical := canonicalLink(io.LimitReader(response.Body, maxCanonicalScan))
+	if canonical == "" {
+		return final.String(), nil
+	}
+	ref, err := final.Parse(canonical)
+	if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return final.String(), nil
+	}
+	return ref.String(), nil
+}
+
+//cached returns the cached resolution of address.
+func (resolver *Resolver) cached(address string) (string, bool) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	final, ok := resolver.cache[address]
+	return final, ok
+}
+
+//remember caches the resolution of address.
+func (resolver *Resolver) remember(address string, final string) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if resolver.cache == nil || len(resolver.cache) >= maxResolverCache {
+		resolver.cache = make(map[string]string)
+	}
+	resolver.cache[address] = final
+}
+
+//isHTML reports whether the content type is an HTML page, whatever its charset.
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+//canonicalLink returns the href of the page's <link rel="canonical">, looking no
+// further than the start of the body.
+func canonicalLink(page io.Reader) string {
+	tokens := html.NewTokenizer(page)
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.EndTagToken:
+			if name, _ := tokens.TagName(); string(name) == "head" {
+				return ""
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokens.Token()
+			if token.Data == "body" {
+				return ""
+			}
+			if token.Data != "link" {
+				continue
+			}
+			var rel, href string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href = attr.Val
+				}
+			}
+			for _, value := range strings.Fields(strings.ToLower(rel)) {
+				if value == "canonical" && href != "" {
+					return strings.TrimSpace(href)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tweetharvest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+//testShortener serves a chain of redirects, /s/n redirecting to /s/n-1 and /s/0 to
+// /article, which names /canonical as its canonical address.  /plain is a page
+// without one, and /text is not HTML.
+func testShortener(requests *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/s/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		var n int
+		fmt.Sscanf(r.URL.Path, "/s/%d", &n)
+		if n == 0 {
+			http.Redirect(w, r, "/article?utm_source=bitly", http.StatusMovedPermanently)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/s/%d", n-1), http.StatusFound)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+		fmt.Fprint(w, `<html><head><title>Article</title>
+			<link rel="stylesheet" href="/style.css">
+			<link rel="Canonical" href="/canonical"></head><body></body></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head></head><body><link rel="canonical" href="/ignored"></body></html>`)
+	})
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, `<link rel="canonical" href="/ignored">`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestResolver(t *testing.T) {
+	c := context.Background()
+	var requests int32
+	server := testShortener(&requests)
+	defer server.Close()
+
+	resolver := NewResolver()
+	resolver.MaxRedirects = 3
+
+	final, err := resolver.Resolve(c, server.URL+"/s/2")
+	if err != nil || final != server.URL+"/canonical" {
+		t.Errorf("Expected the canonical address, got %v, %v", final, err)
+	}
+
+	//The second resolution comes from the cache.
+	final, _ = resolver.Resolve(c, server.URL+"/s/2")
+	if final != server.URL+"/canonical" || atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("Expected a cached resolution, got %v after %v requests", final, requests)
+	}
+
+	final, err = resolver.Resolve(c, server.URL+"/s/5")
+	if err == nil || final != server.URL+"/s/5" {
+		t.Errorf("Expected the redirect limit to stop resolution, got %v, %v", final, err)
+	}
+
+	for _, path := range []string{"/plain", "/text"} {
+		final, err = resolver.Resolve(c, server.URL+path)
+		if err != nil || final != server.URL+path {
+			t.Errorf("Expected %v to resolve to itself, got %v, %v", path, final, err)
+		}
+	}
+}
+
+func TestMapResolvesLinks(t *testing.T) {
+	c := context.Background()
+	var requests int32
+	server := testShortener(&requests)
+	defer server.Close()
+
+	mb := MapBuilder{c: c, query: "golang", store: NewMemoryStore(), resolver: NewResolver()}
+	tweets := LinkTweets{
+		{ShortAddress: server.URL + "/s/1"},
+		{ShortAddress: server.URL + "/s/0"},
+		{ShortAddress: server.URL + "/plain"},
+	}
+	for _, tweet := range tweets {
+		tweet.Id = 1
+	}
+
+	resolved := mb.resolveLinks(tweets)
+	if len(resolved) != 2 {
+		t.Fatalf("Expected the two links to the article to be merged, got %v LinkTweets", len(resolved))
+	}
+	if resolved[0].ShortAddress != server.URL+"/s/1" ||
+		resolved[0].OriginalAddress != server.URL+"/canonical" ||
+		resolved[0].Address != DefaultCanonicalizer.Canonical(server.URL+"/canonical") {
+		t.Errorf("Unexpected resolution %+v", resolved[0])
+	}
+	if resolved[1].OriginalAddress != server.URL+"/plain" {
+		t.Errorf("Expected /plain to resolve to itself, got %v", resolved[1].OriginalAddress)
+	}
+}
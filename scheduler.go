@@ -0,0 +1,126 @@
+package tweetharvest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+//Job is a task the Scheduler runs periodically, the equivalent of an entry in
+// cron.yaml.
+type Job struct {
+	Description string
+
+	//Every is the time between runs.
+	Every time.Duration
+
+	//Offset delays each run past the start of its period, e.g. an Every of one
+	// hour and an Offset of 15 minutes runs at a quarter past every hour.
+	Offset time.Duration
+
+	Run func(c context.Context) error
+}
+
+//nextRun returns the first time after now that falls Offset past a multiple of
+// Every.  Like a "synchronized" cron schedule, runs line up with the clock rather
+// than with the time the scheduler was started.
+func (job Job) nextRun(now time.Time) time.Time {
+	next := now.Truncate(job.Every).Add(job.Offset)
+	for !next.After(now) {
+		next = next.Add(job.Every)
+	}
+	return next
+}
+
+//Scheduler runs Jobs on their schedules.  It takes the place of cron.yaml when
+// running outside of App Engine.
+type Scheduler struct {
+	jobs []Job
+}
+
+//Add adds a job to the schedule.  Jobs must be added before Run is called.
+func (scheduler *Scheduler) Add(job Job) {
+	scheduler.jobs = append(scheduler.jobs, job)
+}
+
+//Run runs each job at its scheduled times until c is cancelled.  A job never
+// overlaps with itself; a run that takes longer than Every skips the runs it
+// missed.
+func (scheduler *Scheduler) Run(c context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range scheduler.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			for {
+				timer := time.NewTimer(time.Until(job.nextRun(time.Now())))
+				select {
+				case <-c.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				log.Infof(c, "Starting scheduled job: %v", job.Description)
+				if err := job.Run(c); err != nil {
+					log.Errorf(c, "Scheduled job %v failed: %v", job.Description, err.Error())
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+}
+
+//RequestJob returns a Job.Run function that sends a GET request for target to
+// handler in-process, the way App Engine cron calls a URL.  Responses with an
+// error status are returned as errors.
+func RequestJob(handler http.Handler, target string) func(c context.Context) error {
+	return func(c context.Context) error {
+		request, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			return err
+		}
+		request = request.WithContext(c)
+		request.Header.Set("X-Appengine-Cron", "true")
+
+		response := &jobResponse{header: make(http.Header)}
+		handler.ServeHTTP(response, request)
+		if response.status == 0 {
+			response.status = http.StatusOK
+		}
+		if response.status >= http.StatusBadRequest {
+			return fmt.Errorf("%v returned %v: %v", target, response.status,
+				strings.TrimSpace(response.body.String()))
+		}
+		return nil
+	}
+}
+
+//jobResponse is the http.ResponseWriter for requests made by RequestJob.
+type jobResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (response *jobResponse) Header() http.Header {
+	return response.header
+}
+
+func (response *jobResponse) Write(b []byte) (int, error) {
+	response.WriteHeader(http.StatusOK)
+	return response.body.Write(b)
+}
+
+//WriteHeader records the status.  Like net/http, only the first call counts.
+func (response *jobResponse) WriteHeader(status int) {
+	if response.status == 0 {
+		response.status = status
+	}
+}
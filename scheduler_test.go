@@ -0,0 +1,63 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJobNextRun(t *testing.T) {
+	job := Job{Every: time.Hour, Offset: 15 * time.Minute}
+	now := time.Date(2015, 11, 15, 10, 20, 0, 0, time.UTC)
+
+	next := job.nextRun(now)
+	if want := time.Date(2015, 11, 15, 11, 15, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Expected next run at %v, got %v", want, next)
+	}
+
+	next = job.nextRun(time.Date(2015, 11, 15, 10, 5, 0, 0, time.UTC))
+	if want := time.Date(2015, 11, 15, 10, 15, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Expected next run at %v, got %v", want, next)
+	}
+}
+
+func TestRequestJob(t *testing.T) {
+	var target string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+		if r.URL.Query().Get("q") == "" {
+			http.Error(w, "No query specified.", http.StatusBadRequest)
+		}
+	})
+
+	if err := RequestJob(handler, "/map?q=golang")(context.Background()); err != nil {
+		t.Errorf("Expected the job to succeed, got %v", err)
+	}
+	if target != "/map?q=golang" {
+		t.Errorf("Expected a request for /map?q=golang, got %v", target)
+	}
+
+	if err := RequestJob(handler, "/map")(context.Background()); err == nil {
+		t.Errorf("Expected an error status to fail the job")
+	}
+}
+
+func TestSchedulerStops(t *testing.T) {
+	c, cancel := context.WithCancel(context.Background())
+	var scheduler Scheduler
+	scheduler.Add(Job{Every: time.Hour, Run: func(c context.Context) error { return nil }})
+
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(c)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Scheduler did not stop when its context was cancelled")
+	}
+}
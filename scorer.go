@@ -0,0 +1,104 @@
+package tweetharvest
+
+import (
+	"sort"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//Scorer calculates the shares of an address from the new tweets that link to it.
+// The score of the address is the total of its shares, and a user's share only
+// counts again when a later reduce scores it higher.
+type Scorer interface {
+	Score(tweets LinkTweets) []Share
+}
+
+//Share is the score a user has given an address by sharing it.
+type Share struct {
+	User  int64
+	Score int
+}
+
+//shareTotal adds up the scores of the shares.
+func shareTotal(shares []Share) int {
+	total := 0
+	for _, share := range shares {
+		total += share.Score
+	}
+	return total
+}
+
+//WeightedScorer is a Scorer that values each share of a link by the kind of
+// tweet it was shared in, plus the favorites and retweets the tweet received.
+//
+//A retweet is folded back onto the tweet it retweets, since its favorites and
+// retweets are those of the original, and each user is only counted once per
+// link no matter how many times they post it.
+type WeightedScorer struct {
+	//Post, Quote and Reply are the weights of sharing a link in a plain tweet, a
+	// quote tweet and a reply.
+	Post  int
+	Quote int
+	Reply int
+
+	//Favorite and Retweet are the weights of each favorite and retweet a tweet
+	// sharing the link received.
+	Favorite int
+	Retweet  int
+}
+
+//DefaultScorer is the Scorer used by the reducer unless Services sets another.
+var DefaultScorer Scorer = WeightedScorer{
+	Post:     2,
+	Quote:    3,
+	Reply:    1,
+	Favorite: 1,
+	Retweet:  2,
+}
+
+//Score returns the best scoring tweet of each user that shared the link, ordered
+// by user.
+func (scorer WeightedScorer) Score(tweets LinkTweets) []Share {
+	//Fold retweets onto the original, keeping the most recent counts.
+	originals := make(map[int64]anaconda.Tweet)
+	for _, linkTweet := range tweets {
+		tweet := linkTweet.Tweet
+		if tweet.RetweetedStatus != nil {
+			tweet = *tweet.RetweetedStatus
+		}
+		if seen, ok := originals[tweet.Id]; !ok || scorer.engagement(tweet) > scorer.engagement(seen) {
+			originals[tweet.Id] = tweet
+		}
+	}
+
+	best := make(map[int64]int)
+	for _, tweet := range originals {
+		if score := scorer.share(tweet) + scorer.engagement(tweet); score > best[tweet.User.Id] {
+			best[tweet.User.Id] = score
+		}
+	}
+
+	shares := make([]Share, 0, len(best))
+	for user, score := range best {
+		shares = append(shares, Share{User: user, Score: score})
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].User < shares[j].User })
+	return shares
+}
+
+//share returns the weight of the tweet sharing the link at all.
+func (scorer WeightedScorer) share(tweet anaconda.Tweet) int {
+	switch {
+	case tweet.QuotedStatusID != 0 || tweet.QuotedStatus != nil:
+		return scorer.Quote
+	case tweet.InReplyToStatusID != 0:
+		return scorer.Reply
+	default:
+		return scorer.Post
+	}
+}
+
+//engagement returns the weight of the favorites and retweets the tweet received.
+func (scorer WeightedScorer) engagement(tweet anaconda.Tweet) int {
+	return tweet.FavoriteCount*scorer.Favorite + tweet.RetweetCount*scorer.Retweet
+}
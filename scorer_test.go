@@ -0,0 +1,48 @@
+package tweetharvest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+func TestWeightedScorer(t *testing.T) {
+	scorer := WeightedScorer{Post: 2, Quote: 3, Reply: 1, Favorite: 1, Retweet: 2}
+	now := time.Now()
+
+	share := func(id int64, user int64) *LinkTweet {
+		tweet := testLinkTweet(id, "http://a.com", "golang", now)
+		tweet.User.Id = user
+		return tweet
+	}
+
+	original := share(1, 10)
+	original.FavoriteCount = 3
+	original.RetweetCount = 1
+	if score := shareTotal(scorer.Score(LinkTweets{original})); score != 7 {
+		t.Errorf("Expected a post with 3 favorites and 1 retweet to score 7, got %v", score)
+	}
+
+	//A retweet carries the newer counts of the original and is folded onto it.
+	retweet := share(2, 20)
+	retweet.RetweetedStatus = &anaconda.Tweet{Id: 1, FavoriteCount: 5, RetweetCount: 2}
+	retweet.RetweetedStatus.User.Id = 10
+	if score := shareTotal(scorer.Score(LinkTweets{original, retweet})); score != 11 {
+		t.Errorf("Expected the retweet to fold onto the original for 11, got %v", score)
+	}
+
+	//The same user posting the same link again only counts once.
+	repost := share(3, 10)
+	if score := shareTotal(scorer.Score(LinkTweets{original, repost})); score != 7 {
+		t.Errorf("Expected the repost not to count, got %v", score)
+	}
+
+	quote := share(4, 30)
+	quote.QuotedStatusID = 1
+	reply := share(5, 40)
+	reply.InReplyToStatusID = 1
+	if score := shareTotal(scorer.Score(LinkTweets{quote, reply})); score != 4 {
+		t.Errorf("Expected a quote and a reply to score 4, got %v", score)
+	}
+}
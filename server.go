@@ -0,0 +1,94 @@
+package tweetharvest
+
+import "github.com/gorilla/mux"
+
+//Services holds the dependencies shared by the map, reduce and consume handlers.
+type Services struct {
+	Store      Store
+	Source     TweetSource
+	NewContext ContextFunc
+
+	//Scorer scores the tweets found by the reduce process.  DefaultScorer is used
+	// if it is nil.
+	Scorer Scorer
+
+	//Resolver follows the redirects of the addresses found by the map process.
+	// Addresses are stored as tweeted if it is nil.
+	Resolver *Resolver
+
+	//MaxPages is the most pages of search results a harvest fetches, bounding how
+	// far back the first harvest of a query goes.  defaultMaxPages is used if it
+	// is 0.
+	MaxPages int
+
+	//Embeds are the EmbedProviders topics choose from by name to render the
+	// tweets in their feeds.  DefaultEmbedProviders are used if it is nil.
+	Embeds map[string]EmbedProvider
+}
+
+//NewRouter returns a router that serves the /map, /reduce, /consume, /schedule,
+// /cycle, /jobs, /topics, /domains, /links and /status endpoints using the given
+// services.
+func NewRouter(services Services) *mux.Router {
+	th := &MapBuilder{
+		store:      services.Store,
+		source:     services.Source,
+		resolver:   services.Resolver,
+		maxPages:   services.MaxPages,
+		newContext: services.NewContext,
+	}
+	scorer := services.Scorer
+	if scorer == nil {
+		scorer = DefaultScorer
+	}
+	cache := NewFeedCache()
+	proc := &Reducer{
+		store:      services.Store,
+		scorer:     scorer,
+		cache:      cache,
+		newContext: services.NewContext,
+	}
+	embeds := services.Embeds
+	if embeds == nil {
+		embeds = DefaultEmbedProviders()
+	}
+	consume := &FeedProducer{
+		store:      services.Store,
+		cache:      cache,
+		embeds:     embeds,
+		newContext: services.NewContext,
+	}
+	schedule := &TopicScheduler{
+		store:      services.Store,
+		harvester:  *th,
+		newContext: services.NewContext,
+	}
+	jobs := &JobOrchestrator{
+		store:      services.Store,
+		scheduler:  *schedule,
+		reducer:    *proc,
+		newContext: services.NewContext,
+	}
+	topics := &TopicHandler{store: services.Store, newContext: services.NewContext}
+	domains := &DomainHandler{store: services.Store, newContext: services.NewContext}
+	links := &LinkHandler{store: services.Store, newContext: services.NewContext}
+	status := &StatusHandler{store: services.Store, newContext: services.NewContext}
+
+	plex := mux.NewRouter()
+	plex.Handle("/map", th)
+	plex.Handle("/reduce", proc)
+	plex.Handle("/consume", consume)
+	plex.Handle("/schedule", schedule)
+	plex.Handle("/cycle", jobs)
+	plex.Handle("/jobs", jobs)
+	plex.Handle("/jobs/{id}", jobs)
+	plex.Handle("/jobs/{id}/rerun", jobs)
+	plex.Handle("/topics", topics)
+	plex.Handle("/topics/{query}", topics)
+	plex.Handle("/domains", domains)
+	plex.Handle("/domains/{domain}", domains)
+	plex.Handle("/links", links)
+	plex.Handle("/status", status)
+
+	return plex
+}
@@ -0,0 +1,78 @@
+package tweetharvest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+//testServices returns Services for running the handlers in tests, with logging
+// discarded and tweets embedded without calling Twitter.
+func testServices(store Store, source TweetSource) Services {
+	return Services{
+		Store:      store,
+		Source:     source,
+		NewContext: StandaloneContext(log.NewStdLogger(ioutil.Discard), http.DefaultClient),
+		Embeds: map[string]EmbedProvider{
+			embedOEmbed:   StubEmbedProvider{},
+			embedTemplate: TemplateEmbedProvider{},
+			embedStub:     StubEmbedProvider{},
+		},
+	}
+}
+
+func TestConsumeFromMemoryStore(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	tweet := testLinkTweet(1, "http://a.com", "golang", now)
+	tweet.Text = "Read this http://a.com"
+	store.PutLinkTweets(c, LinkTweets{tweet})
+	store.UpdateScore(c, "golang", "http://a.com", func(score *TweetScore, exists bool) error {
+		score.Title = "An article"
+		score.Score = 3
+		score.LastActive = now
+		score.TweetIDs = []int64{1}
+		return nil
+	})
+
+	router := NewRouter(testServices(store, nil))
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/consume?q=golang", nil))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", response.Code)
+	}
+	body := response.Body.String()
+	if !strings.Contains(body, "An article") || !strings.Contains(body, "http://a.com") {
+		t.Errorf("Expected the scored address in the feed, got %v", body)
+	}
+
+	store.PutTopic(c, &Topic{Query: "golang", Title: "Gopher reading list"})
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "http://example.com/consume?q=golang", nil))
+	body = response.Body.String()
+	if !strings.Contains(body, "Gopher reading list") ||
+		!strings.Contains(body, "http://example.com/consume?q=golang") {
+		t.Errorf("Expected the feed title and link to come from the topic, got %v", body)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/consume", nil))
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a query, got %v", response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/consume?q=golang&sort=best", nil))
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown sort, got %v", response.Code)
+	}
+}
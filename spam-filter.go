@@ -0,0 +1,191 @@
+package tweetharvest
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//SpamRejection records why the SpamFilter dropped a tweet.
+type SpamRejection struct {
+	TweetID int64    `json:"tweet_id"`
+	User    string   `json:"user"`
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+//SpamFilter is a Filter that scores each tweet against signals of link sharing
+// bots and rejects tweets that reach the Threshold.  Each signal adds its weight
+// to the score:
+//
+//	new account          the account is younger than NewAccountAge
+//	tweets per day       the account averages more than MaxTweetsPerDay
+//	default image        the account still has the default profile image
+//	duplicate text       DuplicateAccounts or more accounts posted the same text
+//	                     in this harvest or stream batch, other than as retweets
+//	URL only             the tweet is nothing but links
+//	hashtags             the tweet has more than MaxHashtags hashtags
+//
+//The duplicate text signal remembers every tweet the filter sees, so a new
+// SpamFilter should be used for each harvest or batch of streamed tweets.
+type SpamFilter struct {
+	Threshold int
+
+	NewAccountAge    time.Duration
+	NewAccountWeight int
+
+	MaxTweetsPerDay    float64
+	TweetsPerDayWeight int
+
+	DefaultImageWeight int
+
+	DuplicateAccounts int
+	DuplicateWeight   int
+
+	URLOnlyWeight int
+
+	MaxHashtags    int
+	HashtagsWeight int
+
+	//Rejected, if set, is called with the reasons for each tweet the filter drops.
+	Rejected func(rejection SpamRejection)
+
+	mu    sync.Mutex
+	texts map[string]map[int64]bool
+}
+
+//defaultSpamThreshold is the Threshold of a SpamFilter from NewSpamFilter when
+// none is given.
+const defaultSpamThreshold = 3
+
+//NewSpamFilter returns a SpamFilter with the default weights that rejects tweets
+// scoring threshold or more, or defaultSpamThreshold if threshold is 0.
+func NewSpamFilter(threshold int) *SpamFilter {
+	if threshold == 0 {
+		threshold = defaultSpamThreshold
+	}
+	return &SpamFilter{
+		Threshold:          threshold,
+		NewAccountAge:      30 * 24 * time.Hour,
+		NewAccountWeight:   2,
+		MaxTweetsPerDay:    100,
+		TweetsPerDayWeight: 2,
+		DefaultImageWeight: 1,
+		DuplicateAccounts:  3,
+		DuplicateWeight:    3,
+		URLOnlyWeight:      1,
+		MaxHashtags:        5,
+		HashtagsWeight:     1,
+	}
+}
+
+//Filter returns true if the tweet scores below the Threshold.
+func (filter *SpamFilter) Filter(tweet *anaconda.Tweet) bool {
+	score, reasons := filter.Score(tweet)
+	if score < filter.Threshold {
+		return true
+	}
+	if filter.Rejected != nil {
+		filter.Rejected(SpamRejection{
+			TweetID: tweet.Id,
+			User:    tweet.User.ScreenName,
+			Score:   score,
+			Reasons: reasons,
+		})
+	}
+	return false
+}
+
+//Score adds up the weights of the signals the tweet shows, and returns them with
+// the reason for each.
+func (filter *SpamFilter) Score(tweet *anaconda.Tweet) (int, []string) {
+	score := 0
+	var reasons []string
+	add := func(weight int, reason string) {
+		if weight != 0 {
+			score += weight
+			reasons = append(reasons, reason)
+		}
+	}
+
+	//The account's age is measured at the time of the tweet, so that recorded
+	// searches score the same whenever they are replayed.
+	tweeted, err := tweet.CreatedAtTime()
+	if err != nil {
+		tweeted = time.Now()
+	}
+	if joined, err := time.Parse(time.RubyDate, tweet.User.CreatedAt); err == nil {
+		age := tweeted.Sub(joined)
+		if age < filter.NewAccountAge {
+			add(filter.NewAccountWeight, "account is "+age.Truncate(time.Hour).String()+" old")
+		}
+		days := age.Hours() / 24
+		if days < 1 {
+			days = 1
+		}
+		if perDay := float64(tweet.User.StatusesCount) / days; perDay > filter.MaxTweetsPerDay {
+			add(filter.TweetsPerDayWeight, "account averages "+
+				strconv.FormatFloat(perDay, 'f', 0, 64)+" tweets a day")
+		}
+	}
+
+	if tweet.User.DefaultProfileImage {
+		add(filter.DefaultImageWeight, "default profile image")
+	}
+
+	//Retweets share the text of the original by design, so they aren't counted
+	// as duplicates.
+	text := spamText(tweet)
+	if tweet.RetweetedStatus == nil && filter.DuplicateAccounts > 0 {
+		if accounts := filter.sawText(text, tweet.User.Id); accounts >= filter.DuplicateAccounts {
+			add(filter.DuplicateWeight, "text posted by "+strconv.Itoa(accounts)+" accounts")
+		}
+	}
+	if text == "" && len(tweet.Entities.Urls) > 0 {
+		add(filter.URLOnlyWeight, "tweet is only links")
+	}
+
+	if hashtags := len(tweet.Entities.Hashtags); hashtags > filter.MaxHashtags {
+		add(filter.HashtagsWeight, strconv.Itoa(hashtags)+" hashtags")
+	}
+
+	return score, reasons
+}
+
+//sawText records that the user posted text and returns how many accounts have
+// posted it so far.
+func (filter *SpamFilter) sawText(text string, user int64) int {
+	if text == "" {
+		return 0
+	}
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+
+	if filter.texts == nil {
+		filter.texts = make(map[string]map[int64]bool)
+	}
+	if filter.texts[text] == nil {
+		filter.texts[text] = make(map[int64]bool)
+	}
+	filter.texts[text][user] = true
+	return len(filter.texts[text])
+}
+
+//spamText returns the tweet's text without its links, lower cased and with its
+// whitespace collapsed, so that copies of a tweet shortened separately compare
+// equal.
+func spamText(tweet *anaconda.Tweet) string {
+	text := tweet.FullText
+	if text == "" {
+		text = tweet.Text
+	}
+	for _, link := range tweet.Entities.Urls {
+		if link.Url != "" {
+			text = strings.Replace(text, link.Url, " ", -1)
+		}
+	}
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
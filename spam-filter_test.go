@@ -0,0 +1,76 @@
+package tweetharvest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpamFilterSignals(t *testing.T) {
+	filter := NewSpamFilter(0)
+
+	human := filterTweet(t, `{"id": 1, "created_at": "Sun Nov 15 04:10:10 +0000 2015",
+		"text": "Go 1.5.1 fixes the compiler crash I hit https://t.co/aaaa",
+		"user": {"id": 1, "created_at": "Mon Mar 02 10:00:00 +0000 2009", "statuses_count": 4000},
+		"entities": {"urls": [{"url": "https://t.co/aaaa", "expanded_url": "https://blog.golang.org/go1.5.1"}]}}`)
+	if score, reasons := filter.Score(human); score != 0 {
+		t.Errorf("Expected no signals for an established account, got %v: %v", score, reasons)
+	}
+
+	//Ten days old with 5000 tweets, the default image and a tweet that is only a
+	// link with six hashtags.
+	bot := filterTweet(t, `{"id": 2, "created_at": "Sun Nov 15 04:10:10 +0000 2015",
+		"text": "https://t.co/bbbb #go #golang #dev #code #tech #news",
+		"user": {"id": 2, "screen_name": "golinkbot", "created_at": "Thu Nov 05 04:10:10 +0000 2015",
+			"statuses_count": 5000, "default_profile_image": true},
+		"entities": {"urls": [{"url": "https://t.co/bbbb", "expanded_url": "https://example.com/go"}],
+			"hashtags": [{"text": "go"}, {"text": "golang"}, {"text": "dev"},
+				{"text": "code"}, {"text": "tech"}, {"text": "news"}]}}`)
+	score, reasons := filter.Score(bot)
+	if score != 6 || len(reasons) != 4 {
+		t.Errorf("Expected new account, tweets per day, default image and hashtags, got %v: %v",
+			score, reasons)
+	}
+
+	score, reasons = filter.Score(filterTweet(t, `{"id": 3, "text": "https://t.co/cccc",
+		"entities": {"urls": [{"url": "https://t.co/cccc"}]}}`))
+	if score != 1 || reasons[0] != "tweet is only links" {
+		t.Errorf("Expected only the URL only signal, got %v: %v", score, reasons)
+	}
+}
+
+func TestSpamFilterDuplicates(t *testing.T) {
+	filter := NewSpamFilter(0)
+	var rejections []SpamRejection
+	filter.Rejected = func(rejection SpamRejection) {
+		rejections = append(rejections, rejection)
+	}
+
+	//The same text shortened separately by four accounts, and retweeted by a fifth.
+	tweets := []string{
+		`{"id": 1, "text": "Learn Go fast https://t.co/a1", "user": {"id": 1},
+			"entities": {"urls": [{"url": "https://t.co/a1"}]}}`,
+		`{"id": 2, "text": "learn go  FAST https://t.co/a2", "user": {"id": 2},
+			"entities": {"urls": [{"url": "https://t.co/a2"}]}}`,
+		`{"id": 3, "text": "Learn Go fast https://t.co/a3", "user": {"id": 3, "screen_name": "third"},
+			"entities": {"urls": [{"url": "https://t.co/a3"}]}}`,
+		`{"id": 4, "text": "Learn Go fast https://t.co/a4", "user": {"id": 4},
+			"entities": {"urls": [{"url": "https://t.co/a4"}]}}`,
+		`{"id": 5, "text": "Learn Go fast https://t.co/a1", "user": {"id": 5},
+			"retweeted_status": {"id": 1}, "entities": {"urls": [{"url": "https://t.co/a1"}]}}`,
+	}
+	var passed []int64
+	for _, raw := range tweets {
+		tweet := filterTweet(t, raw)
+		if filter.Filter(tweet) {
+			passed = append(passed, tweet.Id)
+		}
+	}
+
+	if len(passed) != 3 || passed[2] != 5 {
+		t.Errorf("Expected the first two copies and the retweet to pass, got %v", passed)
+	}
+	if len(rejections) != 2 || rejections[0].TweetID != 3 || rejections[0].User != "third" ||
+		rejections[0].Score != 3 || !strings.Contains(rejections[0].Reasons[0], "3 accounts") {
+		t.Errorf("Expected rejections recording the duplicate text, got %+v", rejections)
+	}
+}
@@ -0,0 +1,1048 @@
+// +build !appengine
+
+package tweetharvest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" //registers the sqlite3 driver
+)
+
+//sqliteMigrations are applied in order to bring a database up to the current
+// schema.  The index of the last applied migration + 1 is kept in the database's
+// user_version, so existing entries must never be edited; add a new one instead.
+var sqliteMigrations = []string{
+	//1: LinkTweet and TweetScore tables, with indexes matching index.yaml
+	`CREATE TABLE link_tweets (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		tweet_id     INTEGER NOT NULL,
+		address      TEXT NOT NULL,
+		query        TEXT NOT NULL,
+		created_time INTEGER NOT NULL,
+		tweet        BLOB NOT NULL
+	);
+	CREATE INDEX link_tweets_created_time ON link_tweets (created_time);
+	CREATE INDEX link_tweets_tweet_id ON link_tweets (tweet_id);
+
+	CREATE TABLE tweet_scores (
+		address     TEXT PRIMARY KEY,
+		query       TEXT NOT NULL,
+		score       INTEGER NOT NULL,
+		last_active INTEGER NOT NULL,
+		title       TEXT NOT NULL,
+		tweet_ids   TEXT NOT NULL
+	);
+	CREATE INDEX tweet_scores_last_active ON tweet_scores (last_active DESC);
+	CREATE INDEX tweet_scores_query_last_active ON tweet_scores (query, last_active DESC);`,
+
+	//2: Topics
+	`CREATE TABLE topics (
+		query        TEXT PRIMARY KEY,
+		title        TEXT NOT NULL,
+		interval     INTEGER NOT NULL,
+		enabled      INTEGER NOT NULL,
+		last_harvest INTEGER NOT NULL,
+		created      INTEGER NOT NULL
+	);`,
+
+	//3: When an address was first seen, for hot ranking
+	`ALTER TABLE tweet_scores ADD COLUMN first_seen INTEGER NOT NULL DEFAULT 0;`,
+
+	//4: Addresses as they were tweeted, before canonicalization
+	`ALTER TABLE link_tweets ADD COLUMN original_address TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN original_address TEXT NOT NULL DEFAULT '';`,
+
+	//5: Addresses as they were tweeted, before following redirects
+	`ALTER TABLE link_tweets ADD COLUMN short_address TEXT NOT NULL DEFAULT '';`,
+
+	//6: Page metadata
+	`ALTER TABLE tweet_scores ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN image TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN card TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN author TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN site_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE tweet_scores ADD COLUMN published INTEGER NOT NULL DEFAULT 0;`,
+
+	//7: Embed provider of each topic's feed
+	`ALTER TABLE topics ADD COLUMN embed TEXT NOT NULL DEFAULT '';`,
+
+	//8: Filters of each topic's tweets, as JSON
+	`ALTER TABLE topics ADD COLUMN filters TEXT NOT NULL DEFAULT '';`,
+
+	//9: Domains and their policies
+	`CREATE TABLE domains (
+		query      TEXT NOT NULL,
+		domain     TEXT NOT NULL,
+		score      INTEGER NOT NULL,
+		links      INTEGER NOT NULL,
+		first_seen INTEGER NOT NULL,
+		last_seen  INTEGER NOT NULL,
+		PRIMARY KEY (query, domain)
+	);
+	CREATE INDEX domains_query_score ON domains (query, score DESC);
+
+	CREATE TABLE domain_policies (
+		domain  TEXT PRIMARY KEY,
+		blocked INTEGER NOT NULL,
+		boost   REAL NOT NULL
+	);`,
+
+	//10: Newest tweet harvested for each query
+	`CREATE TABLE harvest_checkpoints (
+		query   TEXT PRIMARY KEY,
+		max_id  INTEGER NOT NULL,
+		updated INTEGER NOT NULL
+	);`,
+
+	//11: Rate limit of each Twitter API endpoint
+	`CREATE TABLE rate_limits (
+		endpoint     TEXT PRIMARY KEY,
+		lim          INTEGER NOT NULL,
+		remaining    INTEGER NOT NULL,
+		reset        INTEGER NOT NULL,
+		next_allowed INTEGER NOT NULL,
+		backoffs     INTEGER NOT NULL,
+		updated      INTEGER NOT NULL
+	);`,
+
+	//12: Runs of the map and reduce cycle, kept as JSON
+	`CREATE TABLE job_runs (
+		id      INTEGER PRIMARY KEY AUTOINCREMENT,
+		status  TEXT NOT NULL,
+		started INTEGER NOT NULL,
+		run     BLOB NOT NULL
+	);
+	CREATE INDEX job_runs_started ON job_runs (started);`,
+
+	//13: Tweets of each query in the order they were written, and how far the
+	// reducer has got with each query
+	`CREATE INDEX link_tweets_query_id ON link_tweets (query, id);
+
+	CREATE TABLE reduce_checkpoints (
+		query    TEXT PRIMARY KEY,
+		sequence INTEGER NOT NULL,
+		updated  INTEGER NOT NULL
+	);`,
+
+	//14: A score for each query an address is shared under, rather than one for
+	// the address.  SQLite can't change a primary key, so the table is rebuilt.
+	`CREATE TABLE tweet_scores_by_query (
+		address          TEXT NOT NULL,
+		query            TEXT NOT NULL,
+		score            INTEGER NOT NULL,
+		last_active      INTEGER NOT NULL,
+		title            TEXT NOT NULL,
+		tweet_ids        TEXT NOT NULL,
+		first_seen       INTEGER NOT NULL DEFAULT 0,
+		original_address TEXT NOT NULL DEFAULT '',
+		description      TEXT NOT NULL DEFAULT '',
+		image            TEXT NOT NULL DEFAULT '',
+		card             TEXT NOT NULL DEFAULT '',
+		author           TEXT NOT NULL DEFAULT '',
+		site_name        TEXT NOT NULL DEFAULT '',
+		published        INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (query, address)
+	);
+	INSERT INTO tweet_scores_by_query SELECT address, query, score, last_active, title, tweet_ids,
+		first_seen, original_address, description, image, card, author, site_name, published
+		FROM tweet_scores;
+	DROP TABLE tweet_scores;
+	ALTER TABLE tweet_scores_by_query RENAME TO tweet_scores;
+	CREATE INDEX tweet_scores_last_active ON tweet_scores (last_active DESC);
+	CREATE INDEX tweet_scores_query_last_active ON tweet_scores (query, last_active DESC);
+	CREATE INDEX tweet_scores_address ON tweet_scores (address, query);`,
+
+	//15: The users counted in each score, as JSON
+	`ALTER TABLE tweet_scores ADD COLUMN shares TEXT NOT NULL DEFAULT '';`,
+
+	//16: When each score was last written, for feed ETags
+	`ALTER TABLE tweet_scores ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX tweet_scores_query_updated ON tweet_scores (query, updated DESC);`,
+
+	//17: Failed harvests of each topic, for backing off
+	`ALTER TABLE topics ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE topics ADD COLUMN last_failure INTEGER NOT NULL DEFAULT 0;`,
+
+	//18: Where a harvest stopped at the page cap will resume
+	`ALTER TABLE harvest_checkpoints ADD COLUMN resume_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE harvest_checkpoints ADD COLUMN newest_id INTEGER NOT NULL DEFAULT 0;`,
+}
+
+//linkTweetColumns are the columns of link_tweets, in the order scanLinkTweet reads
+// them.
+const linkTweetColumns = "id, address, query, tweet, original_address, short_address"
+
+//tweetScoreColumns are the columns of tweet_scores, in the order scanTweetScore
+// reads them and tweetScoreValues writes them.
+const tweetScoreColumns = `address, query, score, last_active, title, tweet_ids, first_seen,
+	original_address, description, image, card, author, site_name, published, shares,
+	updated`
+
+//topicColumns are the columns of topics, in the order scanTopic reads them and
+// topicValues writes them.
+const topicColumns = `query, title, interval, enabled, last_harvest, created, embed, filters, failures,
+	last_failure`
+
+//domainColumns are the columns of domains, in the order scanDomain reads them.
+const domainColumns = "domain, query, score, links, first_seen, last_seen"
+
+//SQLiteStore is a Store backed by an embedded SQLite database, for running
+// outside of App Engine.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+//NewSQLiteStore opens (or creates) the SQLite database at path and migrates it
+// to the current schema.  Use ":memory:" for a throwaway database.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	//SQLite allows a single writer, and a ":memory:" database only exists on the
+	// connection that created it, so all access goes through one connection.
+	db.SetMaxOpenConns(1)
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+//Close closes the underlying database.
+func (store *SQLiteStore) Close() error {
+	return store.db.Close()
+}
+
+//migrate applies any migrations the database hasn't seen yet, each in its own
+// transaction.
+func (store *SQLiteStore) migrate() error {
+	var version int
+	if err := store.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite migration %v: %v", version+1, err)
+		}
+		//PRAGMA doesn't take bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//PutLinkTweets inserts the tweets in a single transaction.  Each tweet's Sequence
+// is its row id, which AUTOINCREMENT never reuses.
+func (store *SQLiteStore) PutLinkTweets(c context.Context, tweets LinkTweets) error {
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.PrepareContext(c, `INSERT INTO link_tweets
+		(tweet_id, address, query, created_time, tweet, original_address, short_address)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, tweet := range tweets {
+		raw, err := json.Marshal(tweet.Tweet)
+		if err != nil {
+			return err
+		}
+		created, _ := tweet.CreatedAtTime()
+		_, err = insert.ExecContext(c, tweet.Id, tweet.Address, tweet.Query, created.UnixNano(), raw,
+			tweet.OriginalAddress, tweet.ShortAddress)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//GetAllNewTweets returns the tweets created after since.
+func (store *SQLiteStore) GetAllNewTweets(c context.Context, since time.Time) (LinkTweets, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+linkTweetColumns+
+		" FROM link_tweets WHERE created_time > ? ORDER BY created_time", since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(LinkTweets, 0, 15)
+	for rows.Next() {
+		tweet, err := scanLinkTweet(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tweet)
+	}
+	return out, rows.Err()
+}
+
+//GetTweetsAfter returns up to limit of the query's tweets with a row id greater
+// than sequence.
+func (store *SQLiteStore) GetTweetsAfter(c context.Context, query string, sequence int64, limit int) (LinkTweets, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+linkTweetColumns+
+		" FROM link_tweets WHERE query = ? AND id > ? ORDER BY id LIMIT ?", query, sequence, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out LinkTweets
+	for rows.Next() {
+		tweet, err := scanLinkTweet(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tweet)
+	}
+	return out, rows.Err()
+}
+
+//TweetQueries returns the distinct queries of the stored tweets.
+func (store *SQLiteStore) TweetQueries(c context.Context) ([]string, error) {
+	rows, err := store.db.QueryContext(c, "SELECT DISTINCT query FROM link_tweets ORDER BY query")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var query string
+		if err := rows.Scan(&query); err != nil {
+			return nil, err
+		}
+		out = append(out, query)
+	}
+	return out, rows.Err()
+}
+
+//NewestTweet returns the creation time of the newest stored tweet.
+func (store *SQLiteStore) NewestTweet(c context.Context) (time.Time, error) {
+	var newest sql.NullInt64
+	err := store.db.QueryRowContext(c, "SELECT MAX(created_time) FROM link_tweets").Scan(&newest)
+	if err != nil || !newest.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(0, newest.Int64), nil
+}
+
+//LinkTweet returns the tweet with the given ID, or nil if it isn't stored.
+func (store *SQLiteStore) LinkTweet(c context.Context, tweetID int64) (*LinkTweet, error) {
+	row := store.db.QueryRowContext(c, "SELECT "+linkTweetColumns+
+		" FROM link_tweets WHERE tweet_id = ? LIMIT 1", tweetID)
+	tweet, err := scanLinkTweet(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return tweet, err
+}
+
+//QueryLinkTweet returns the tweet with the given ID stored under the query, or
+// nil if it isn't stored for the query.
+func (store *SQLiteStore) QueryLinkTweet(c context.Context, query string, tweetID int64) (*LinkTweet, error) {
+	row := store.db.QueryRowContext(c, "SELECT "+linkTweetColumns+
+		" FROM link_tweets WHERE tweet_id = ? AND query = ? LIMIT 1", tweetID, query)
+	tweet, err := scanLinkTweet(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return tweet, err
+}
+
+//GetHarvestCheckpoint returns the checkpoint for the query, or nil if there isn't
+// one.
+func (store *SQLiteStore) GetHarvestCheckpoint(c context.Context, query string) (*HarvestCheckpoint, error) {
+	checkpoint := &HarvestCheckpoint{}
+	var updated int64
+	err := store.db.QueryRowContext(c, `SELECT query, max_id, resume_id, newest_id, updated
+		FROM harvest_checkpoints WHERE query = ?`, query).Scan(&checkpoint.Query, &checkpoint.MaxID,
+		&checkpoint.ResumeID, &checkpoint.NewestID, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Updated = fromUnixNano(updated)
+	return checkpoint, nil
+}
+
+//PutHarvestCheckpoint inserts or replaces the checkpoint.
+func (store *SQLiteStore) PutHarvestCheckpoint(c context.Context, checkpoint *HarvestCheckpoint) error {
+	_, err := store.db.ExecContext(c, `INSERT OR REPLACE INTO harvest_checkpoints
+		(query, max_id, resume_id, newest_id, updated) VALUES (?, ?, ?, ?, ?)`,
+		checkpoint.Query, checkpoint.MaxID, checkpoint.ResumeID, checkpoint.NewestID,
+		unixNano(checkpoint.Updated))
+	return err
+}
+
+//rateLimitColumns are the columns of rate_limits, in the order scanRateLimit reads
+// them.
+const rateLimitColumns = "endpoint, lim, remaining, reset, next_allowed, backoffs, updated"
+
+//GetRateLimit returns the rate limit of the endpoint, or nil if there isn't one.
+func (store *SQLiteStore) GetRateLimit(c context.Context, endpoint string) (*RateLimit, error) {
+	limit, err := scanRateLimit(store.db.QueryRowContext(c,
+		"SELECT "+rateLimitColumns+" FROM rate_limits WHERE endpoint = ?", endpoint))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return limit, err
+}
+
+//GetRateLimits returns the rate limit of every endpoint, ordered by endpoint.
+func (store *SQLiteStore) GetRateLimits(c context.Context) ([]*RateLimit, error) {
+	rows, err := store.db.QueryContext(c,
+		"SELECT "+rateLimitColumns+" FROM rate_limits ORDER BY endpoint")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*RateLimit
+	for rows.Next() {
+		limit, err := scanRateLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, limit)
+	}
+	return out, rows.Err()
+}
+
+//PutRateLimit inserts or replaces the rate limit.
+func (store *SQLiteStore) PutRateLimit(c context.Context, limit *RateLimit) error {
+	_, err := store.db.ExecContext(c, "INSERT OR REPLACE INTO rate_limits ("+rateLimitColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		limit.Endpoint, limit.Limit, limit.Remaining, unixNano(limit.Reset),
+		unixNano(limit.NextAllowed), limit.Backoffs, unixNano(limit.Updated))
+	return err
+}
+
+//UpdateScore reads, updates and writes the query's score for the address inside
+// one transaction.
+func (store *SQLiteStore) UpdateScore(c context.Context, query string, address string,
+	update func(score *TweetScore, exists bool) error) error {
+
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateScoreTx(c, tx, query, address, update); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//UpdateScores checks the stored checkpoint is still at from, then reads, updates
+// and writes the checkpoint query's score for each address and writes the
+// checkpoint, inside one transaction.
+func (store *SQLiteStore) UpdateScores(c context.Context, addresses []string, from int64, checkpoint *ReduceCheckpoint,
+	update func(score *TweetScore, exists bool) error) error {
+
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored int64
+	err = tx.QueryRowContext(c, "SELECT sequence FROM reduce_checkpoints WHERE query = ?",
+		checkpoint.Query).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if stored != from {
+		return errCheckpointMoved
+	}
+
+	for _, address := range addresses {
+		if err := updateScoreTx(c, tx, checkpoint.Query, address, update); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(c, `INSERT OR REPLACE INTO reduce_checkpoints
+		(query, sequence, updated) VALUES (?, ?, ?)`,
+		checkpoint.Query, checkpoint.Sequence, unixNano(checkpoint.Updated))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//updateScoreTx reads, updates and writes the query's score for the address
+// within tx.
+func updateScoreTx(c context.Context, tx *sql.Tx, query string, address string,
+	update func(score *TweetScore, exists bool) error) error {
+
+	row := tx.QueryRowContext(c, "SELECT "+tweetScoreColumns+
+		" FROM tweet_scores WHERE query = ? AND address = ?", query, address)
+	score, err := scanTweetScore(row)
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		score = &TweetScore{Query: query, Address: address}
+	} else if err != nil {
+		return err
+	}
+
+	if err := update(score, exists); err != nil {
+		return err
+	}
+
+	score.Query = query
+	score.Address = address
+	score.Updated = time.Now()
+	values, err := tweetScoreValues(score)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(c, "INSERT OR REPLACE INTO tweet_scores ("+tweetScoreColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", values...)
+	return err
+}
+
+//AddressScores returns the address's scores under every query, ordered by query.
+func (store *SQLiteStore) AddressScores(c context.Context, address string) ([]*TweetScore, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+tweetScoreColumns+
+		" FROM tweet_scores WHERE address = ? ORDER BY query", address)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*TweetScore
+	for rows.Next() {
+		score, err := scanTweetScore(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, score)
+	}
+	return out, rows.Err()
+}
+
+//GetReduceCheckpoint returns the reduce checkpoint for the query, or nil if there
+// isn't one.
+func (store *SQLiteStore) GetReduceCheckpoint(c context.Context, query string) (*ReduceCheckpoint, error) {
+	checkpoint := &ReduceCheckpoint{}
+	var updated int64
+	err := store.db.QueryRowContext(c, `SELECT query, sequence, updated FROM reduce_checkpoints
+		WHERE query = ?`, query).Scan(&checkpoint.Query, &checkpoint.Sequence, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.Updated = fromUnixNano(updated)
+	return checkpoint, nil
+}
+
+//RecentScores returns the query's scores active since the given time.
+func (store *SQLiteStore) RecentScores(c context.Context, query string, since time.Time) ([]*TweetScore, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+tweetScoreColumns+
+		" FROM tweet_scores WHERE query = ? AND last_active >= ? ORDER BY last_active DESC",
+		query, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*TweetScore
+	for rows.Next() {
+		score, err := scanTweetScore(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, score)
+	}
+	return out, rows.Err()
+}
+
+//LastScoreActivity returns the newest LastActive of any stored score.
+func (store *SQLiteStore) LastScoreActivity(c context.Context) (time.Time, error) {
+	var last sql.NullInt64
+	err := store.db.QueryRowContext(c, "SELECT MAX(last_active) FROM tweet_scores").Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(0, last.Int64), nil
+}
+
+//LastQueryActivity returns the newest LastActive of the query's scores.
+func (store *SQLiteStore) LastQueryActivity(c context.Context, query string) (time.Time, error) {
+	var last sql.NullInt64
+	err := store.db.QueryRowContext(c, "SELECT MAX(last_active) FROM tweet_scores WHERE query = ?",
+		query).Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(0, last.Int64), nil
+}
+
+//LastQueryUpdate returns the newest Updated of the query's scores.
+func (store *SQLiteStore) LastQueryUpdate(c context.Context, query string) (time.Time, error) {
+	var last sql.NullInt64
+	err := store.db.QueryRowContext(c, "SELECT MAX(updated) FROM tweet_scores WHERE query = ?",
+		query).Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, err
+	}
+	return fromUnixNano(last.Int64), nil
+}
+
+//PutTopic inserts or replaces the topic.
+func (store *SQLiteStore) PutTopic(c context.Context, topic *Topic) error {
+	values, err := topicValues(topic)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.ExecContext(c, "INSERT OR REPLACE INTO topics ("+topicColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", values...)
+	return err
+}
+
+//UpdateTopic reads, updates and writes the topic inside one transaction.
+func (store *SQLiteStore) UpdateTopic(c context.Context, query string,
+	update func(topic *Topic, exists bool) error) error {
+
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	topic, err := scanTopic(tx.QueryRowContext(c, "SELECT "+topicColumns+" FROM topics WHERE query = ?", query))
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		topic = &Topic{Query: query}
+	} else if err != nil {
+		return err
+	}
+
+	if err := update(topic, exists); err != nil {
+		return err
+	}
+
+	topic.Query = query
+	values, err := topicValues(topic)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(c, "INSERT OR REPLACE INTO topics ("+topicColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", values...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//GetTopic returns the topic for the query, or nil if there isn't one.
+func (store *SQLiteStore) GetTopic(c context.Context, query string) (*Topic, error) {
+	row := store.db.QueryRowContext(c, "SELECT "+topicColumns+" FROM topics WHERE query = ?", query)
+	topic, err := scanTopic(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return topic, err
+}
+
+//GetTopics returns all of the topics.
+func (store *SQLiteStore) GetTopics(c context.Context) ([]*Topic, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+topicColumns+" FROM topics ORDER BY query")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Topic
+	for rows.Next() {
+		topic, err := scanTopic(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, topic)
+	}
+	return out, rows.Err()
+}
+
+//DeleteTopic deletes the topic for the query.
+func (store *SQLiteStore) DeleteTopic(c context.Context, query string) error {
+	_, err := store.db.ExecContext(c, "DELETE FROM topics WHERE query = ?", query)
+	return err
+}
+
+//UpdateDomain reads, updates and writes the domain inside one transaction.
+func (store *SQLiteStore) UpdateDomain(c context.Context, query string, name string,
+	update func(domain *Domain, exists bool) error) error {
+
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(c, "SELECT "+domainColumns+
+		" FROM domains WHERE query = ? AND domain = ?", query, name)
+	domain, err := scanDomain(row)
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		domain = &Domain{Name: name, Query: query}
+	} else if err != nil {
+		return err
+	}
+
+	if err := update(domain, exists); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(c, "INSERT OR REPLACE INTO domains ("+domainColumns+
+		") VALUES (?, ?, ?, ?, ?, ?)", name, query, domain.Score, domain.Links,
+		unixNano(domain.FirstSeen), unixNano(domain.LastSeen))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//TopDomains returns the query's highest scoring domains.
+func (store *SQLiteStore) TopDomains(c context.Context, query string, limit int) ([]*Domain, error) {
+	rows, err := store.db.QueryContext(c, "SELECT "+domainColumns+
+		" FROM domains WHERE query = ? ORDER BY score DESC, domain LIMIT ?", query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Domain
+	for rows.Next() {
+		domain, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, domain)
+	}
+	return out, rows.Err()
+}
+
+//PutDomainPolicy inserts or replaces the policy.
+func (store *SQLiteStore) PutDomainPolicy(c context.Context, policy *DomainPolicy) error {
+	_, err := store.db.ExecContext(c, `INSERT OR REPLACE INTO domain_policies
+		(domain, blocked, boost) VALUES (?, ?, ?)`, policy.Name, policy.Blocked, policy.Boost)
+	return err
+}
+
+//GetDomainPolicy returns the policy for the domain, or nil if there isn't one.
+func (store *SQLiteStore) GetDomainPolicy(c context.Context, name string) (*DomainPolicy, error) {
+	policy := &DomainPolicy{}
+	err := store.db.QueryRowContext(c, `SELECT domain, blocked, boost FROM domain_policies
+		WHERE domain = ?`, name).Scan(&policy.Name, &policy.Blocked, &policy.Boost)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+//GetDomainPolicies returns all of the policies.
+func (store *SQLiteStore) GetDomainPolicies(c context.Context) (DomainPolicies, error) {
+	rows, err := store.db.QueryContext(c,
+		"SELECT domain, blocked, boost FROM domain_policies ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out DomainPolicies
+	for rows.Next() {
+		policy := &DomainPolicy{}
+		if err := rows.Scan(&policy.Name, &policy.Blocked, &policy.Boost); err != nil {
+			return nil, err
+		}
+		out = append(out, policy)
+	}
+	return out, rows.Err()
+}
+
+//DeleteDomainPolicy deletes the policy for the domain.
+func (store *SQLiteStore) DeleteDomainPolicy(c context.Context, name string) error {
+	_, err := store.db.ExecContext(c, "DELETE FROM domain_policies WHERE domain = ?", name)
+	return err
+}
+
+//PutJobRun inserts a new run, setting its ID, or updates the run with its ID.
+// The run is kept as JSON, with the columns runs are listed by beside it.
+func (store *SQLiteStore) PutJobRun(c context.Context, run *JobRun) error {
+	raw, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	if run.ID != 0 {
+		_, err = store.db.ExecContext(c, "UPDATE job_runs SET status = ?, started = ?, run = ? WHERE id = ?",
+			run.Status, unixNano(run.Started), raw, run.ID)
+		return err
+	}
+
+	result, err := store.db.ExecContext(c, "INSERT INTO job_runs (status, started, run) VALUES (?, ?, ?)",
+		run.Status, unixNano(run.Started), raw)
+	if err != nil {
+		return err
+	}
+	run.ID, err = result.LastInsertId()
+	return err
+}
+
+//GetJobRun returns the run with the ID, or nil if there isn't one.
+func (store *SQLiteStore) GetJobRun(c context.Context, id int64) (*JobRun, error) {
+	run, err := scanJobRun(store.db.QueryRowContext(c, "SELECT id, run FROM job_runs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return run, err
+}
+
+//GetJobRuns returns up to limit runs with the status, newest first.
+func (store *SQLiteStore) GetJobRuns(c context.Context, status string, limit int) ([]*JobRun, error) {
+	rows, err := store.db.QueryContext(c, `SELECT id, run FROM job_runs WHERE ? = '' OR status = ?
+		ORDER BY started DESC, id DESC LIMIT ?`, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*JobRun
+	for rows.Next() {
+		run, err := scanJobRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, run)
+	}
+	return out, rows.Err()
+}
+
+//UpdateJobRun reads, updates and writes the run with the ID inside one transaction.
+func (store *SQLiteStore) UpdateJobRun(c context.Context, id int64,
+	update func(run *JobRun, exists bool) error) error {
+
+	tx, err := store.db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	run, err := scanJobRun(tx.QueryRowContext(c, "SELECT id, run FROM job_runs WHERE id = ?", id))
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		run = &JobRun{ID: id}
+	} else if err != nil {
+		return err
+	}
+
+	if err := update(run, exists); err != nil || !exists {
+		return err
+	}
+
+	run.ID = id
+	raw, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(c, "UPDATE job_runs SET status = ?, started = ?, run = ? WHERE id = ?",
+		run.Status, unixNano(run.Started), raw, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//scanJobRun reads the id and run of a row.
+func scanJobRun(row sqlScanner) (*JobRun, error) {
+	run := &JobRun{}
+	var id int64
+	var raw []byte
+	if err := row.Scan(&id, &raw); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, run); err != nil {
+		return nil, err
+	}
+	run.ID = id
+	return run, nil
+}
+
+//scanRateLimit reads the rateLimitColumns of a row.
+func scanRateLimit(row sqlScanner) (*RateLimit, error) {
+	limit := &RateLimit{}
+	var reset, nextAllowed, updated int64
+	err := row.Scan(&limit.Endpoint, &limit.Limit, &limit.Remaining, &reset,
+		&nextAllowed, &limit.Backoffs, &updated)
+	if err != nil {
+		return nil, err
+	}
+	limit.Reset = fromUnixNano(reset)
+	limit.NextAllowed = fromUnixNano(nextAllowed)
+	limit.Updated = fromUnixNano(updated)
+	return limit, nil
+}
+
+//sqlScanner is satisfied by both *sql.Row and *sql.Rows.
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanLinkTweet reads a row of linkTweetColumns into a LinkTweet.
+func scanLinkTweet(row sqlScanner) (*LinkTweet, error) {
+	tweet := &LinkTweet{}
+	var raw []byte
+	err := row.Scan(&tweet.Sequence, &tweet.Address, &tweet.Query, &raw, &tweet.OriginalAddress,
+		&tweet.ShortAddress)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &tweet.Tweet); err != nil {
+		return nil, err
+	}
+	return tweet, nil
+}
+
+//scanTweetScore reads a full tweet_scores row into a TweetScore.
+func scanTweetScore(row sqlScanner) (*TweetScore, error) {
+	score := &TweetScore{}
+	var lastActive, firstSeen, published, updated int64
+	var tweetIDs, shares string
+	err := row.Scan(&score.Address, &score.Query, &score.Score, &lastActive, &score.Title, &tweetIDs,
+		&firstSeen, &score.OriginalAddress, &score.Description, &score.Image, &score.Card,
+		&score.Author, &score.SiteName, &published, &shares, &updated)
+	if err != nil {
+		return nil, err
+	}
+	score.LastActive = time.Unix(0, lastActive)
+	score.FirstSeen = fromUnixNano(firstSeen)
+	score.Published = fromUnixNano(published)
+	score.Updated = fromUnixNano(updated)
+	if err := json.Unmarshal([]byte(tweetIDs), &score.TweetIDs); err != nil {
+		return nil, err
+	}
+	if shares != "" {
+		if err := json.Unmarshal([]byte(shares), &score.Shares); err != nil {
+			return nil, err
+		}
+	}
+	return score, nil
+}
+
+//tweetScoreValues returns the values of a tweet_scores row for the score.
+func tweetScoreValues(score *TweetScore) ([]interface{}, error) {
+	tweetIDs, err := json.Marshal(score.TweetIDs)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := json.Marshal(score.Shares)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		score.Address, score.Query, score.Score, score.LastActive.UnixNano(), score.Title,
+		string(tweetIDs), unixNano(score.FirstSeen), score.OriginalAddress, score.Description,
+		score.Image, score.Card, score.Author, score.SiteName, unixNano(score.Published), string(shares),
+		unixNano(score.Updated),
+	}, nil
+}
+
+//scanTopic reads a full topics row into a Topic.
+func scanTopic(row sqlScanner) (*Topic, error) {
+	topic := &Topic{}
+	var interval, lastHarvest, created, lastFailure int64
+	var filters string
+	err := row.Scan(&topic.Query, &topic.Title, &interval, &topic.Enabled, &lastHarvest, &created,
+		&topic.Embed, &filters, &topic.Failures, &lastFailure)
+	if err != nil {
+		return nil, err
+	}
+	topic.Interval = time.Duration(interval)
+	topic.LastHarvest = fromUnixNano(lastHarvest)
+	topic.Created = fromUnixNano(created)
+	topic.LastFailure = fromUnixNano(lastFailure)
+	if filters != "" {
+		topic.Filters = &FilterConfig{}
+		if err := json.Unmarshal([]byte(filters), topic.Filters); err != nil {
+			return nil, err
+		}
+	}
+	return topic, nil
+}
+
+//topicValues returns the values of a topics row for the topic.
+func topicValues(topic *Topic) ([]interface{}, error) {
+	var filters []byte
+	if topic.Filters != nil {
+		var err error
+		if filters, err = json.Marshal(topic.Filters); err != nil {
+			return nil, err
+		}
+	}
+	return []interface{}{
+		topic.Query, topic.Title, int64(topic.Interval), topic.Enabled,
+		unixNano(topic.LastHarvest), unixNano(topic.Created), topic.Embed, string(filters),
+		topic.Failures, unixNano(topic.LastFailure),
+	}, nil
+}
+
+//scanDomain reads a full domains row into a Domain.
+func scanDomain(row sqlScanner) (*Domain, error) {
+	domain := &Domain{}
+	var firstSeen, lastSeen int64
+	err := row.Scan(&domain.Name, &domain.Query, &domain.Score, &domain.Links, &firstSeen, &lastSeen)
+	if err != nil {
+		return nil, err
+	}
+	domain.FirstSeen = fromUnixNano(firstSeen)
+	domain.LastSeen = fromUnixNano(lastSeen)
+	return domain, nil
+}
+
+//unixNano converts t for storage, keeping the zero time as 0.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+//fromUnixNano converts a stored time back, the inverse of unixNano.
+func fromUnixNano(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
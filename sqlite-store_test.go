@@ -0,0 +1,185 @@
+// +build !appengine
+
+package tweetharvest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite store: %v", err)
+	}
+	return store
+}
+
+func TestSQLiteStoreTweets(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreTweets(t, store)
+}
+
+func TestSQLiteStoreScores(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreScores(t, store)
+}
+
+func TestSQLiteStoreTopics(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreTopics(t, store)
+}
+
+func TestSQLiteStoreDomains(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreDomains(t, store)
+}
+
+func TestSQLiteStoreHarvests(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreHarvests(t, store)
+}
+
+func TestSQLiteStoreJobs(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreJobs(t, store)
+}
+
+func TestSQLiteStoreOverlappingReduces(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	defer store.Close()
+	testStoreOverlappingReduces(t, store)
+}
+
+func TestSQLiteStoreReopen(t *testing.T) {
+	c := context.Background()
+	path := filepath.Join(t.TempDir(), "harvest.db")
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite store: %v", err)
+	}
+	store.PutLinkTweets(c, LinkTweets{testLinkTweet(1, "http://a.com", "golang", time.Now())})
+	store.Close()
+
+	//Opening the same file again must not re-run the migrations.
+	store, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite store: %v", err)
+	}
+	defer store.Close()
+
+	tweet, err := store.LinkTweet(c, 1)
+	if err != nil || tweet == nil {
+		t.Fatalf("Expected tweet to survive reopening, got %v, %v", tweet, err)
+	}
+}
+
+//newSQLiteFixture creates a database at path as it was at user_version version,
+// from the migrations up to it, which are never edited, and runs rows in it.
+func newSQLiteFixture(t *testing.T, path string, version int, rows ...string) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture: %v", err)
+	}
+	defer db.Close()
+
+	for i, migration := range sqliteMigrations[:version] {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("Failed to apply migration %v to fixture: %v", i+1, err)
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		t.Fatalf("Failed to set fixture version: %v", err)
+	}
+	for _, row := range rows {
+		if _, err := db.Exec(row); err != nil {
+			t.Fatalf("Failed to insert fixture row: %v", err)
+		}
+	}
+}
+
+func TestSQLiteStoreMigratesScoresByQuery(t *testing.T) {
+	c := context.Background()
+	path := filepath.Join(t.TempDir(), "harvest.db")
+	firstSeen := time.Date(2015, time.November, 11, 15, 4, 5, 0, time.UTC)
+
+	//Before migration 14 a score was kept per address, under the query that
+	// first shared it, though the address was tweeted under other queries too.
+	newSQLiteFixture(t, path, 13,
+		fmt.Sprintf(`INSERT INTO tweet_scores (address, query, score, last_active, title, tweet_ids,
+			first_seen, original_address, description, site_name)
+			VALUES ('https://blog.golang.org/go1.5.1', 'golang', 7, %v, 'Go 1.5.1 is released', '[1,2]',
+			%v, 'http://blog.golang.org/go1.5.1', 'A bug fix release', 'The Go Blog')`,
+			firstSeen.UnixNano(), firstSeen.UnixNano()),
+		fmt.Sprintf(`INSERT INTO tweet_scores (address, query, score, last_active, title, tweet_ids)
+			VALUES ('https://www.rust-lang.org/', 'rust', 3, %v, 'Rust', '[3]')`, firstSeen.UnixNano()),
+		fmt.Sprintf(`INSERT INTO link_tweets (tweet_id, address, query, created_time, tweet)
+			VALUES (1, 'https://blog.golang.org/go1.5.1', 'golang', %v, '{}'),
+			(4, 'https://blog.golang.org/go1.5.1', 'rust', %v, '{}')`,
+			firstSeen.UnixNano(), firstSeen.UnixNano()),
+	)
+
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate fixture: %v", err)
+	}
+	defer store.Close()
+	var version int
+	store.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != len(sqliteMigrations) {
+		t.Errorf("Expected user_version %v, got %v", len(sqliteMigrations), version)
+	}
+
+	scores, err := store.AddressScores(c, "https://blog.golang.org/go1.5.1")
+	if err != nil || len(scores) != 1 || scores[0].Query != "golang" {
+		t.Fatalf("Expected the golang score to survive the migration, got %v, %v", len(scores), err)
+	}
+	score := scores[0]
+	if score.Score != 7 || score.Title != "Go 1.5.1 is released" || len(score.TweetIDs) != 2 ||
+		!score.FirstSeen.Equal(firstSeen) || score.OriginalAddress != "http://blog.golang.org/go1.5.1" ||
+		score.Description != "A bug fix release" || score.SiteName != "The Go Blog" {
+		t.Errorf("Expected the golang score's columns to be kept, got %+v", score)
+	}
+	scores, _ = store.AddressScores(c, "https://www.rust-lang.org/")
+	if len(scores) != 1 || scores[0].Query != "rust" || scores[0].Score != 3 {
+		t.Errorf("Expected the rust score to survive the migration, got %v", len(scores))
+	}
+
+	if tweet, err := store.QueryLinkTweet(c, "rust", 4); err != nil || tweet == nil {
+		t.Errorf("Expected the rust tweet of the address to survive the migration, got %v, %v", tweet, err)
+	}
+
+	//The address tweeted under rust now gets a score of its own, rather than
+	// adding to the golang one.
+	err = store.UpdateScore(c, "rust", "https://blog.golang.org/go1.5.1", func(score *TweetScore, exists bool) error {
+		if exists {
+			return fmt.Errorf("expected no rust score for the address yet, got %+v", score)
+		}
+		score.Score = 2
+		score.TweetIDs = []int64{4}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to add a rust score for the address: %v", err)
+	}
+
+	scores, err = store.AddressScores(c, "https://blog.golang.org/go1.5.1")
+	if err != nil || len(scores) != 2 {
+		t.Fatalf("Expected a score for each query, got %v, %v", len(scores), err)
+	}
+	if scores[0].Query != "golang" || scores[0].Score != 7 || scores[1].Query != "rust" || scores[1].Score != 2 {
+		t.Errorf("Expected golang 7 and rust 2, got %v %v and %v %v",
+			scores[0].Query, scores[0].Score, scores[1].Query, scores[1].Score)
+	}
+}
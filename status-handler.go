@@ -0,0 +1,48 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+//StatusHandler serves the /status endpoint, which reports the quota left on each
+// Twitter API endpoint and when harvests deferred by it can run again.
+type StatusHandler struct {
+	c          context.Context
+	store      Store
+	newContext ContextFunc
+}
+
+//rateLimitReport is a RateLimit with whether the endpoint can be called now.
+type rateLimitReport struct {
+	*RateLimit
+	Allowed bool `json:"allowed"`
+}
+
+//ServeHTTP responds with the RateLimit of every endpoint that has been called.
+func (sh StatusHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	sh.c = sh.newContext(request)
+	if request.Method != "GET" {
+		http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits, err := sh.store.GetRateLimits(sh.c)
+	if err != nil {
+		log.Errorf(sh.c, "Failed to get rate limits. %v", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	reports := []rateLimitReport{}
+	for _, limit := range limits {
+		reports = append(reports, rateLimitReport{RateLimit: limit, Allowed: limit.allowed(now) == nil})
+	}
+	writeJSON(writer, http.StatusOK, struct {
+		RateLimits []rateLimitReport `json:"rate_limits"`
+	}{reports})
+}
@@ -0,0 +1,462 @@
+package tweetharvest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//StreamHarvester is a long running alternative to the MapBuilder that gets tweets
+// as they are posted rather than searching for them.  It tracks the queries of
+// every enabled Topic on one stream, routes each tweet to the queries it matches
+// and writes the LinkTweets in small batches.  The topics' filters are built
+// afresh after each batch is written, so their SpamFilters only remember the
+// tweets of one batch.  Topics are reloaded every RefreshEvery, and the stream
+// reopened if the queries tracked have changed.
+type StreamHarvester struct {
+	Store    Store
+	Source   StreamSource
+	Resolver *Resolver
+
+	//BatchSize is the number of tweets held before they are written, and
+	// FlushEvery the longest they are held for.  defaultStreamBatchSize and
+	// defaultStreamFlush are used if they are 0.
+	BatchSize  int
+	FlushEvery time.Duration
+
+	//RefreshEvery is the time between reloads of the topics.
+	// defaultStreamRefresh is used if it is 0.
+	RefreshEvery time.Duration
+}
+
+const (
+	defaultStreamBatchSize = 100
+	defaultStreamFlush     = 5 * time.Second
+	defaultStreamRefresh   = 5 * time.Minute
+
+	//streamStallTimeout is how long the stream may go without a message or a
+	// keep-alive, which Twitter sends every 30 seconds, before it is reopened.
+	streamStallTimeout = 90 * time.Second
+
+	//maxStreamLine is the longest message read from the stream.
+	maxStreamLine = 1 << 20
+)
+
+//The first waits of Twitter's reconnect schedule for network errors, HTTP errors
+// and rate limiting.  Tests shorten them.
+var (
+	streamNetworkBackoff   = 250 * time.Millisecond
+	streamHTTPBackoff      = 5 * time.Second
+	streamRateLimitBackoff = time.Minute
+)
+
+//errTopicsChanged ends a stream when the queries to track have changed.
+var errTopicsChanged = errors.New("Topics changed.")
+
+//errStreamStalled ends a stream that has gone quiet for streamStallTimeout.
+var errStreamStalled = errors.New("Stream stalled.")
+
+//streamBackoff returns the wait before reconnecting after failures attempts in a
+// row have failed with err, the last of them.  Network errors back off linearly
+// up to 16 seconds, HTTP errors exponentially from 5 seconds up to 320 and rate
+// limiting exponentially from a minute.
+func streamBackoff(err error, failures int) time.Duration {
+	statusErr, ok := err.(*StreamStatusError)
+	var wait, max time.Duration
+	switch {
+	case ok && (statusErr.StatusCode == 420 || statusErr.StatusCode == http.StatusTooManyRequests):
+		wait, max = streamRateLimitBackoff<<uint(failures-1), 16*streamRateLimitBackoff
+	case ok:
+		wait, max = streamHTTPBackoff<<uint(failures-1), 64*streamHTTPBackoff
+	default:
+		wait, max = streamNetworkBackoff*time.Duration(failures), 64*streamNetworkBackoff
+	}
+	if wait > max || wait <= 0 {
+		wait = max
+	}
+	return wait
+}
+
+//streamTopic is an enabled Topic as tracked by the StreamHarvester.
+type streamTopic struct {
+	query  string
+	terms  []string
+	topic  *Topic
+	filter Filter
+}
+
+//resetFilter builds the topic's filter afresh from its configuration.
+func (topic *streamTopic) resetFilter(c context.Context) error {
+	query := topic.query
+	filter, err := topic.topic.filter(func(rejection SpamRejection) {
+		log.Infof(c, "Spam filter rejected tweet %v by @%v for %v with score %v: %v", rejection.TweetID,
+			rejection.User, query, rejection.Score, strings.Join(rejection.Reasons, ", "))
+	})
+	if err != nil {
+		return err
+	}
+	topic.filter = filter
+	return nil
+}
+
+//matches reports whether the tweet matches the topic's query the way Twitter's
+// track parameter does: every word of it appears in the text, a link or a
+// hashtag, ignoring case.
+func (topic *streamTopic) matches(tweet *anaconda.Tweet) bool {
+	var haystack []string
+	haystack = append(haystack, tweet.Text)
+	for _, url := range tweet.Entities.Urls {
+		haystack = append(haystack, url.Expanded_url)
+	}
+	for _, hashtag := range tweet.Entities.Hashtags {
+		haystack = append(haystack, hashtag.Text)
+	}
+	text := strings.ToLower(strings.Join(haystack, " "))
+
+	for _, term := range topic.terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return len(topic.terms) > 0
+}
+
+//streamBatch holds the LinkTweets routed since the last write, and the tweets
+// they came from so that none is routed to a query twice.
+type streamBatch struct {
+	tweets map[string]LinkTweets
+	size   int
+	seen   map[tweetQuery]bool
+}
+
+//tweetQuery identifies a tweet routed to a query.
+type tweetQuery struct {
+	id    int64
+	query string
+}
+
+//Run tracks the enabled topics until c is cancelled, reconnecting whenever the
+// stream ends.  A stream that drops is reopened straight away, but failed
+// attempts to open one back off on Twitter's schedule.
+func (sh *StreamHarvester) Run(c context.Context) {
+	failures := 0
+	for c.Err() == nil {
+		topics, err := sh.loadTopics(c)
+		if err != nil {
+			log.Errorf(c, "Stream failed to load topics. %v", err.Error())
+			sleep(c, sh.refreshEvery())
+			continue
+		}
+		if len(topics) == 0 {
+			sleep(c, sh.refreshEvery())
+			continue
+		}
+
+		track := make([]string, len(topics))
+		for i, topic := range topics {
+			track[i] = topic.query
+		}
+		body, err := sh.Source.Stream(c, track)
+		if err != nil {
+			if c.Err() != nil {
+				return
+			}
+			failures++
+			wait := streamBackoff(err, failures)
+			log.Errorf(c, "Failed to open stream, retrying in %v. %v", wait, err.Error())
+			sleep(c, wait)
+			continue
+		}
+		failures = 0
+
+		log.Infof(c, "Streaming tweets for %v", strings.Join(track, ", "))
+		err = sh.consume(c, body, topics)
+		body.Close()
+		if err != nil && c.Err() == nil && err != errTopicsChanged {
+			log.Errorf(c, "Stream ended, reconnecting. %v", err.Error())
+		}
+	}
+}
+
+//loadTopics returns the enabled topics with the filters to apply to them.
+func (sh *StreamHarvester) loadTopics(c context.Context) ([]*streamTopic, error) {
+	topics, err := sh.Store.GetTopics(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*streamTopic
+	for _, topic := range topics {
+		if !topic.Enabled {
+			continue
+		}
+		tracked := &streamTopic{
+			query: topic.Query,
+			terms: strings.Fields(strings.ToLower(topic.Query)),
+			topic: topic,
+		}
+		if err := tracked.resetFilter(c); err != nil {
+			return nil, err
+		}
+		out = append(out, tracked)
+	}
+	return out, nil
+}
+
+//consume routes the tweets read from body until the stream ends, stalls or the
+// topics change, writing a batch whenever it is full or FlushEvery has passed.
+func (sh *StreamHarvester) consume(c context.Context, body io.Reader, topics []*streamTopic) error {
+	lines := make(chan []byte)
+	ended := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), maxStreamLine)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-done:
+				return
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		ended <- err
+	}()
+
+	batchSize := sh.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultStreamBatchSize
+	}
+	flushEvery := sh.FlushEvery
+	if flushEvery == 0 {
+		flushEvery = defaultStreamFlush
+	}
+	flush := time.NewTicker(flushEvery)
+	defer flush.Stop()
+	stall := time.NewTicker(streamStallTimeout / 3)
+	defer stall.Stop()
+	lastMessage := time.Now()
+	refresh := time.NewTicker(sh.refreshEvery())
+	defer refresh.Stop()
+
+	batch := &streamBatch{tweets: make(map[string]LinkTweets), seen: make(map[tweetQuery]bool)}
+	defer sh.write(detachedContext{c}, batch)
+
+	for {
+		select {
+		case <-c.Done():
+			return c.Err()
+		case err := <-ended:
+			return err
+		case <-stall.C:
+			if time.Since(lastMessage) > streamStallTimeout {
+				return errStreamStalled
+			}
+		case <-flush.C:
+			sh.flush(c, batch, topics)
+		case <-refresh.C:
+			if changed, err := sh.topicsChanged(c, topics); err == nil && changed {
+				return errTopicsChanged
+			}
+		case line := <-lines:
+			lastMessage = time.Now()
+			sh.route(c, line, topics, batch)
+			if batch.size >= batchSize {
+				sh.flush(c, batch, topics)
+			}
+		}
+	}
+}
+
+//topicsChanged reports whether the queries of the enabled topics are no longer
+// the ones tracked.
+func (sh *StreamHarvester) topicsChanged(c context.Context, tracked []*streamTopic) (bool, error) {
+	topics, err := sh.loadTopics(c)
+	if err != nil {
+		return false, err
+	}
+	if len(topics) != len(tracked) {
+		return true, nil
+	}
+	for i := range topics {
+		if topics[i].query != tracked[i].query {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//streamMessage holds the fields used to tell tweets from the other messages sent
+// on a stream.
+type streamMessage struct {
+	ID         int64 `json:"id"`
+	Disconnect *struct {
+		Code   int    `json:"code"`
+		Reason string `json:"reason"`
+	} `json:"disconnect"`
+	Warning *struct {
+		Message string `json:"message"`
+	} `json:"warning"`
+}
+
+//route adds a LinkTweet to the batch for each query the tweet on the line
+// matches and whose filter it passes.  Blank keep-alive lines and messages other
+// than tweets are skipped.
+func (sh *StreamHarvester) route(c context.Context, line []byte, topics []*streamTopic, batch *streamBatch) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var message streamMessage
+	if err := json.Unmarshal(line, &message); err != nil {
+		log.Errorf(c, "Failed to read stream message. %v", err.Error())
+		return
+	}
+	switch {
+	case message.Disconnect != nil:
+		log.Infof(c, "Stream disconnect %v: %v", message.Disconnect.Code, message.Disconnect.Reason)
+		return
+	case message.Warning != nil:
+		log.Infof(c, "Stream warning: %v", message.Warning.Message)
+		return
+	case message.ID == 0:
+		return
+	}
+
+	var tweet anaconda.Tweet
+	if err := json.Unmarshal(line, &tweet); err != nil {
+		log.Errorf(c, "Failed to read tweet from stream. %v", err.Error())
+		return
+	}
+	if !(URLFilter{}).Filter(&tweet) {
+		return
+	}
+
+	for _, topic := range topics {
+		key := tweetQuery{id: tweet.Id, query: topic.query}
+		if batch.seen[key] || !topic.matches(&tweet) {
+			continue
+		}
+		if topic.filter != nil && !topic.filter.Filter(&tweet) {
+			continue
+		}
+		batch.seen[key] = true
+
+		linkTweets := LinkTweetsFrom(tweet)
+		for _, linkTweet := range linkTweets {
+			linkTweet.Query = topic.query
+		}
+		batch.tweets[topic.query] = append(batch.tweets[topic.query], linkTweets...)
+		batch.size++
+	}
+}
+
+//flush writes the batch and then builds the topics' filters afresh, so that the
+// duplicate texts their SpamFilters remember don't grow for as long as the
+// stream stays open.  A topic whose filter can't be rebuilt keeps its old one.
+func (sh *StreamHarvester) flush(c context.Context, batch *streamBatch, topics []*streamTopic) {
+	sh.write(c, batch)
+	for _, topic := range topics {
+		if err := topic.resetFilter(c); err != nil {
+			log.Errorf(c, "Failed to rebuild the filter for %v. %v", topic.query, err.Error())
+		}
+	}
+}
+
+//write resolves the addresses of the batch's LinkTweets and writes them to the
+// store, one query at a time, then empties the batch.  Tweets already stored
+// for the same query, e.g. by a search harvest, are left out, as are tweets that
+// can't be looked up.  The tweets of a query that fail to be written are kept in
+// the batch to be written with the next one.
+func (sh *StreamHarvester) write(c context.Context, batch *streamBatch) {
+	failed := make(map[string]LinkTweets)
+	for query, tweets := range batch.tweets {
+		var values LinkTweets
+		for _, tweet := range tweets {
+			existing, err := sh.Store.QueryLinkTweet(c, query, tweet.Id)
+			if err != nil {
+				log.Errorf(c, "Failed to look up tweet %v. %v", tweet.Id, err.Error())
+				continue
+			}
+			if existing == nil {
+				values = append(values, tweet)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		mb := MapBuilder{c: c, query: query, store: sh.Store, resolver: sh.Resolver}
+		values = mb.resolveLinks(values)
+		if err := sh.Store.PutLinkTweets(c, values); err != nil {
+			log.Errorf(c, "Failed to write streamed tweets for %v, keeping them for the next batch. %v",
+				query, err.Error())
+			failed[query] = values
+			continue
+		}
+		log.Infof(c, "Stored %v streamed LinkTweets for %v", len(values), query)
+	}
+
+	batch.tweets = failed
+	batch.seen = make(map[tweetQuery]bool)
+	batch.size = 0
+	for query, tweets := range failed {
+		for _, tweet := range tweets {
+			key := tweetQuery{id: tweet.Id, query: query}
+			if !batch.seen[key] {
+				batch.seen[key] = true
+				batch.size++
+			}
+		}
+	}
+}
+
+func (sh *StreamHarvester) refreshEvery() time.Duration {
+	if sh.RefreshEvery == 0 {
+		return defaultStreamRefresh
+	}
+	return sh.RefreshEvery
+}
+
+//sleep waits for d or until c is cancelled.
+func sleep(c context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.Done():
+	case <-timer.C:
+	}
+}
+
+//detachedContext keeps the values of a context, such as its logger, but not its
+// cancellation, so that the last batch is still written when the stream is shut
+// down.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package tweetharvest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//streamTweet returns a tweet as a line of a stream, linking to address if it
+// isn't empty.
+func streamTweet(id int64, text string, address string) string {
+	urls := "[]"
+	if address != "" {
+		urls = fmt.Sprintf(`[{"expanded_url": %q}]`, address)
+	}
+	return fmt.Sprintf(`{"id": %v, "id_str": "%v", "text": %q, "created_at": "Wed Nov 11 15:04:05 +0000 2015", `+
+		`"user": {"screen_name": "gopherdaily", "followers_count": 100}, "entities": {"urls": %v}}`,
+		id, id, text, urls) + "\r\n"
+}
+
+func TestStreamHarvester(t *testing.T) {
+	defer func(network, status time.Duration) {
+		streamNetworkBackoff, streamHTTPBackoff = network, status
+	}(streamNetworkBackoff, streamHTTPBackoff)
+	streamNetworkBackoff, streamHTTPBackoff = time.Millisecond, 10*time.Millisecond
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewMemoryStore()
+	store.PutTopic(c, &Topic{Query: "golang", Enabled: true})
+	store.PutTopic(c, &Topic{Query: "rust lang", Enabled: true})
+	store.PutTopic(c, &Topic{Query: "python", Enabled: false})
+
+	//The first stream drops after a few messages, the next attempt fails and the
+	// third stream stays open until the harvester is stopped.
+	var mu sync.Mutex
+	var tracks []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mu.Lock()
+		tracks = append(tracks, request.FormValue("track"))
+		attempt := len(tracks)
+		mu.Unlock()
+
+		switch attempt {
+		case 1:
+			fmt.Fprint(writer, streamTweet(1, "Go 1.5.1 is released #golang", "https://blog.golang.org/go1.5.1"))
+			fmt.Fprint(writer, `{"limit": {"track": 12}}`+"\r\n\r\n")
+			fmt.Fprint(writer, streamTweet(2, "Rust lang or golang?", "https://example.com/compared"))
+		case 2:
+			http.Error(writer, "Service Unavailable", http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(writer, streamTweet(3, "The Rust lang book", "https://doc.rust-lang.org/book/"))
+			fmt.Fprint(writer, streamTweet(4, "No link about golang", ""))
+			fmt.Fprint(writer, streamTweet(5, "Python 3 is out", "https://python.org/"))
+			fmt.Fprint(writer, `{"disconnect": {"code": 7, "reason": "shutting down"}}`+"\r\n")
+			writer.(http.Flusher).Flush()
+			<-request.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	harvester := &StreamHarvester{
+		Store:      store,
+		Source:     &HTTPStreamSource{URL: server.URL},
+		BatchSize:  2,
+		FlushEvery: 10 * time.Millisecond,
+	}
+	stopped := make(chan struct{})
+	go func() {
+		harvester.Run(c)
+		close(stopped)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tweets, _ := store.GetAllNewTweets(c, time.Time{})
+		if len(tweets) >= 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	tweets, _ := store.GetAllNewTweets(context.Background(), time.Time{})
+	routed := make(map[string]int)
+	for _, tweet := range tweets {
+		routed[fmt.Sprintf("%v %v", tweet.Id, tweet.Query)]++
+	}
+	expected := map[string]int{"1 golang": 1, "2 golang": 1, "2 rust lang": 1, "3 rust lang": 1}
+	if fmt.Sprint(routed) != fmt.Sprint(expected) {
+		t.Errorf("Expected tweets routed to %v, got %v", expected, routed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tracks) < 3 || tracks[0] != "golang,rust lang" {
+		t.Errorf("Expected 3 connections tracking the enabled topics, got %q", tracks)
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	tests := []struct {
+		err      error
+		failures int
+		wait     time.Duration
+	}{
+		{fmt.Errorf("connection reset"), 1, 250 * time.Millisecond},
+		{fmt.Errorf("connection reset"), 3, 750 * time.Millisecond},
+		{fmt.Errorf("connection reset"), 100, 16 * time.Second},
+		{&StreamStatusError{StatusCode: 503}, 1, 5 * time.Second},
+		{&StreamStatusError{StatusCode: 503}, 3, 20 * time.Second},
+		{&StreamStatusError{StatusCode: 503}, 10, 320 * time.Second},
+		{&StreamStatusError{StatusCode: 420}, 1, time.Minute},
+		{&StreamStatusError{StatusCode: 429}, 3, 4 * time.Minute},
+		{&StreamStatusError{StatusCode: 420}, 80, 16 * time.Minute},
+	}
+	for _, test := range tests {
+		if wait := streamBackoff(test.err, test.failures); wait != test.wait {
+			t.Errorf("streamBackoff(%v, %v) = %v, expected %v", test.err, test.failures, wait, test.wait)
+		}
+	}
+}
+
+//failingTweetStore is a MemoryStore whose PutLinkTweets fails until fail is
+// cleared.
+type failingTweetStore struct {
+	*MemoryStore
+	fail bool
+}
+
+func (store *failingTweetStore) PutLinkTweets(c context.Context, tweets LinkTweets) error {
+	if store.fail {
+		return fmt.Errorf("store unavailable")
+	}
+	return store.MemoryStore.PutLinkTweets(c, tweets)
+}
+
+func TestStreamWriteKeepsFailedBatch(t *testing.T) {
+	c := context.Background()
+	store := &failingTweetStore{MemoryStore: NewMemoryStore(), fail: true}
+	harvester := &StreamHarvester{Store: store}
+	created := time.Now()
+	batch := &streamBatch{
+		tweets: map[string]LinkTweets{"golang": {
+			testLinkTweet(1, "https://golang.org/", "golang", created),
+			testLinkTweet(2, "https://blog.golang.org/", "golang", created),
+		}},
+		seen: map[tweetQuery]bool{{id: 1, query: "golang"}: true, {id: 2, query: "golang"}: true},
+		size: 2,
+	}
+
+	harvester.write(c, batch)
+	if batch.size != 2 || len(batch.tweets["golang"]) != 2 {
+		t.Fatalf("Expected the failed batch to be kept, got %v tweets", batch.size)
+	}
+
+	store.fail = false
+	harvester.write(c, batch)
+	if batch.size != 0 || len(batch.tweets) != 0 {
+		t.Errorf("Expected the batch to be emptied once written, got %v tweets", batch.size)
+	}
+	tweets, _ := store.GetAllNewTweets(c, time.Time{})
+	if len(tweets) != 2 {
+		t.Errorf("Expected the kept tweets to be stored, got %v", len(tweets))
+	}
+}
+
+func TestStreamFlushResetsSpamFilters(t *testing.T) {
+	c := context.Background()
+	store := NewMemoryStore()
+	store.PutTopic(c, &Topic{Query: "golang", Enabled: true, Filters: &FilterConfig{Spam: &SpamConfig{}}})
+	harvester := &StreamHarvester{Store: store}
+	topics, err := harvester.loadTopics(c)
+	if err != nil || len(topics) != 1 {
+		t.Fatalf("Expected one topic, got %v, %v", len(topics), err)
+	}
+
+	//The default SpamFilter rejects a text once three accounts have posted it.
+	batch := &streamBatch{tweets: make(map[string]LinkTweets), seen: make(map[tweetQuery]bool)}
+	route := func(id int64) {
+		line := fmt.Sprintf(`{"id": %v, "id_str": "%v", "text": "Read this golang post", `+
+			`"created_at": "Wed Nov 11 15:04:05 +0000 2015", "user": {"id": %v, "screen_name": "gopher%v"}, `+
+			`"entities": {"urls": [{"expanded_url": "https://example.com/%v"}]}}`, id, id, id, id, id)
+		harvester.route(c, []byte(line), topics, batch)
+	}
+
+	route(1)
+	route(2)
+	harvester.flush(c, batch, topics)
+	route(3)
+	route(4)
+	if batch.size != 2 {
+		t.Errorf("Expected the texts of the last batch to be forgotten, got %v tweets routed", batch.size)
+	}
+	route(5)
+	if batch.size != 2 {
+		t.Errorf("Expected the third copy in a batch to be rejected, got %v tweets routed", batch.size)
+	}
+}
@@ -0,0 +1,90 @@
+package tweetharvest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/garyburd/go-oauth/oauth"
+)
+
+//StreamSource is where the StreamHarvester gets its tweets from.
+type StreamSource interface {
+	//Stream opens a stream of the tweets that match any of the track phrases, one
+	// JSON message per line.  The stream ends when it is closed or c is cancelled.
+	Stream(c context.Context, track []string) (io.ReadCloser, error)
+}
+
+//StreamStatusError is returned by a StreamSource when the stream endpoint
+// answers with an HTTP error rather than a stream.
+type StreamStatusError struct {
+	StatusCode int
+}
+
+func (err *StreamStatusError) Error() string {
+	return fmt.Sprintf("Stream endpoint returned status %v.", err.StatusCode)
+}
+
+//twitterFilterStreamURL is the address of Twitter's filter stream.
+const twitterFilterStreamURL = "https://stream.twitter.com/1.1/statuses/filter.json"
+
+//HTTPStreamSource is a StreamSource that POSTs the track phrases to URL, the
+// way Twitter's filter stream expects them, and reads the response as the stream.
+type HTTPStreamSource struct {
+	URL string
+
+	//Client makes the request.  It must not have a Timeout, which would cut the
+	// stream off; a Client without one is used if it is nil.
+	Client *http.Client
+
+	//Authorize signs each request with its form, if it is set.
+	Authorize func(request *http.Request, form url.Values) error
+}
+
+//NewTwitterStreamSource returns an HTTPStreamSource for Twitter's filter stream
+// that signs its requests with the given credentials.
+func NewTwitterStreamSource(consumerKey string, consumerSecret string,
+	accessToken string, accessTokenSecret string) *HTTPStreamSource {
+
+	client := &oauth.Client{Credentials: oauth.Credentials{Token: consumerKey, Secret: consumerSecret}}
+	token := &oauth.Credentials{Token: accessToken, Secret: accessTokenSecret}
+	return &HTTPStreamSource{
+		URL: twitterFilterStreamURL,
+		Authorize: func(request *http.Request, form url.Values) error {
+			return client.SetAuthorizationHeader(request.Header, token, request.Method, request.URL, form)
+		},
+	}
+}
+
+//Stream opens the stream for the track phrases.
+func (source *HTTPStreamSource) Stream(c context.Context, track []string) (io.ReadCloser, error) {
+	form := url.Values{"track": {strings.Join(track, ",")}}
+	request, err := http.NewRequest("POST", source.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if source.Authorize != nil {
+		if err := source.Authorize(request, form); err != nil {
+			return nil, err
+		}
+	}
+
+	client := source.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, &StreamStatusError{StatusCode: response.StatusCode}
+	}
+	return response.Body, nil
+}
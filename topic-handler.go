@@ -0,0 +1,170 @@
+package tweetharvest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+	"github.com/gorilla/mux"
+)
+
+//TopicHandler serves the /topics endpoints used to manage Topics:
+//
+//	GET    /topics          lists all topics
+//	POST   /topics          creates a topic
+//	GET    /topics/{query}  returns one topic
+//	PUT    /topics/{query}  creates or updates a topic
+//	DELETE /topics/{query}  deletes a topic
+//
+//Topics are sent and received as JSON.
+type TopicHandler struct {
+	c          context.Context
+	store      Store
+	newContext ContextFunc
+}
+
+//ServeHTTP dispatches on the method and whether a query is in the path.
+func (th TopicHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	th.c = th.newContext(request)
+	query, one := mux.Vars(request)["query"]
+
+	switch {
+	case !one && request.Method == "GET":
+		th.list(writer)
+	case !one && request.Method == "POST":
+		th.create(writer, request)
+	case one && request.Method == "GET":
+		th.get(writer, query)
+	case one && request.Method == "PUT":
+		th.update(writer, request, query)
+	case one && request.Method == "DELETE":
+		th.delete(writer, query)
+	default:
+		http.Error(writer, "Method not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+func (th TopicHandler) list(writer http.ResponseWriter) {
+	topics, err := th.store.GetTopics(th.c)
+	if err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	if topics == nil {
+		topics = []*Topic{}
+	}
+	writeJSON(writer, http.StatusOK, topics)
+}
+
+func (th TopicHandler) get(writer http.ResponseWriter, query string) {
+	topic, err := th.store.GetTopic(th.c, query)
+	if err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	if topic == nil {
+		http.Error(writer, "No such topic.", http.StatusNotFound)
+		return
+	}
+	writeJSON(writer, http.StatusOK, topic)
+}
+
+func (th TopicHandler) create(writer http.ResponseWriter, request *http.Request) {
+	topic, ok := th.readTopic(writer, request)
+	if !ok {
+		return
+	}
+
+	existing, err := th.store.GetTopic(th.c, topic.Query)
+	if err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	if existing != nil {
+		http.Error(writer, "Topic already exists.", http.StatusConflict)
+		return
+	}
+
+	topic.Created = time.Now()
+	topic.LastHarvest = time.Time{}
+	topic.Failures = 0
+	topic.LastFailure = time.Time{}
+	if err := th.store.PutTopic(th.c, topic); err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	log.Infof(th.c, "Created topic: %v", topic.Query)
+	writeJSON(writer, http.StatusCreated, topic)
+}
+
+func (th TopicHandler) update(writer http.ResponseWriter, request *http.Request, query string) {
+	topic, ok := th.readTopic(writer, request)
+	if !ok {
+		return
+	}
+	if topic.Query != query {
+		http.Error(writer, "Topic query does not match the URL.", http.StatusBadRequest)
+		return
+	}
+
+	//The harvest history belongs to the server, not the client, and is read in
+	// the same transaction so that a harvest finishing meanwhile isn't undone.
+	status := http.StatusOK
+	err := th.store.UpdateTopic(th.c, query, func(stored *Topic, exists bool) error {
+		topic.Created = stored.Created
+		topic.LastHarvest = stored.LastHarvest
+		topic.Failures = stored.Failures
+		topic.LastFailure = stored.LastFailure
+		status = http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+			topic.Created = time.Now()
+		}
+		*stored = *topic
+		return nil
+	})
+	if err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	log.Infof(th.c, "Updated topic: %v", topic.Query)
+	writeJSON(writer, status, topic)
+}
+
+func (th TopicHandler) delete(writer http.ResponseWriter, query string) {
+	if err := th.store.DeleteTopic(th.c, query); err != nil {
+		th.serverError(writer, err)
+		return
+	}
+	log.Infof(th.c, "Deleted topic: %v", query)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+//readTopic decodes and validates the Topic in the request body.  If it is not
+// valid an error response is written and ok is false.
+func (th TopicHandler) readTopic(writer http.ResponseWriter, request *http.Request) (topic *Topic, ok bool) {
+	topic = &Topic{}
+	if err := json.NewDecoder(request.Body).Decode(topic); err != nil {
+		http.Error(writer, "Invalid topic: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := topic.validate(); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return topic, true
+}
+
+func (th TopicHandler) serverError(writer http.ResponseWriter, err error) {
+	log.Errorf(th.c, "Topic store error. %v", err.Error())
+	http.Error(writer, err.Error(), http.StatusInternalServerError)
+}
+
+//writeJSON writes value as a JSON response with the given status.
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
@@ -0,0 +1,57 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTopicHandlerCRUD(t *testing.T) {
+	store := NewMemoryStore()
+	router := NewRouter(testServices(store, nil))
+
+	send := func(method string, target string, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return response
+	}
+
+	response := send("POST", "/topics", `{"query": "golang", "title": "Go", "enabled": true}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 creating a topic, got %v: %v", response.Code, response.Body)
+	}
+	if response = send("POST", "/topics", `{"query": "golang"}`); response.Code != http.StatusConflict {
+		t.Errorf("Expected 409 creating a duplicate topic, got %v", response.Code)
+	}
+	if response = send("POST", "/topics", `{"title": "No query"}`); response.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 creating a topic without a query, got %v", response.Code)
+	}
+
+	response = send("PUT", "/topics/golang", `{"query": "golang", "title": "Gophers", "interval": "2h"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected 200 updating a topic, got %v: %v", response.Code, response.Body)
+	}
+
+	response = send("GET", "/topics/golang", "")
+	var topic Topic
+	json.NewDecoder(response.Body).Decode(&topic)
+	if topic.Title != "Gophers" || topic.Enabled || topic.Created.IsZero() {
+		t.Errorf("Expected the updated topic with its creation time kept, got %+v", topic)
+	}
+
+	response = send("GET", "/topics", "")
+	var topics []*Topic
+	json.NewDecoder(response.Body).Decode(&topics)
+	if len(topics) != 1 {
+		t.Errorf("Expected 1 topic in the list, got %v", len(topics))
+	}
+
+	if response = send("DELETE", "/topics/golang", ""); response.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting a topic, got %v", response.Code)
+	}
+	if response = send("GET", "/topics/golang", ""); response.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted topic, got %v", response.Code)
+	}
+}
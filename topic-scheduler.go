@@ -0,0 +1,155 @@
+package tweetharvest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/AndyNortrup/TweetHarvest/log"
+)
+
+//TopicScheduler is a Handler for the /schedule endpoint.  Cron calls it every few
+// minutes and it harvests each enabled Topic whose Interval has passed since it
+// was last harvested.
+type TopicScheduler struct {
+	c          context.Context
+	store      Store
+	harvester  MapBuilder
+	newContext ContextFunc
+}
+
+//ServeHTTP harvests the topics that are due and responds with the list of
+// queries that were harvested and those deferred by the rate limit.
+func (ts TopicScheduler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ts.c = ts.newContext(request)
+
+	harvested, deferred, err := ts.harvestDue(time.Now())
+	if err != nil {
+		log.Errorf(ts.c, "Failed to schedule topics. %v", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, struct {
+		Harvested []string `json:"harvested"`
+		Deferred  []string `json:"deferred"`
+	}{harvested, deferred})
+}
+
+//errTopicDeleted is returned when a topic is deleted while it is harvested.
+var errTopicDeleted = errors.New("Topic was deleted.")
+
+//Outcomes of harvesting a topic.
+const (
+	harvestPending  = "pending"
+	harvestDone     = "harvested"
+	harvestDeferred = "deferred"
+	harvestFailed   = "failed"
+)
+
+//TopicHarvest records the outcome of harvesting one topic: pending while the
+// harvests of a JobRun are under way, then harvested, deferred by the rate limit
+// or failed with Error.
+type TopicHarvest struct {
+	Query   string         `json:"query"`
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Summary HarvestSummary `json:"summary"`
+}
+
+//harvestDue harvests each topic that is due at the given time.
+func (ts TopicScheduler) harvestDue(now time.Time) ([]string, []string, error) {
+	topics, err := ts.store.GetTopics(ts.c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	harvested := []string{}
+	deferred := []string{}
+	for _, result := range ts.harvestTopics(dueTopics(topics, now), now) {
+		switch result.Status {
+		case harvestDone:
+			harvested = append(harvested, result.Query)
+		case harvestDeferred:
+			deferred = append(deferred, result.Query)
+		}
+	}
+	return harvested, deferred, nil
+}
+
+//dueTopics returns the topics that are due at the given time.
+func dueTopics(topics []*Topic, now time.Time) []*Topic {
+	var due []*Topic
+	for _, topic := range topics {
+		if topic.due(now) {
+			due = append(due, topic)
+		}
+	}
+	return due
+}
+
+//harvestTopics runs the MapBuilder for each topic, one after another, and
+// records when it was harvested, or that its harvest failed so that it is backed
+// off.  Once the search endpoint's rate limit is reached, the rest of the topics
+// are deferred: they are left due, to be harvested by a later run once the
+// endpoint allows it.
+func (ts TopicScheduler) harvestTopics(topics []*Topic, now time.Time) []TopicHarvest {
+	results := []TopicHarvest{}
+	limited := false
+	for _, topic := range topics {
+		result := TopicHarvest{Query: topic.Query, Status: harvestDeferred}
+		if limited {
+			results = append(results, result)
+			continue
+		}
+
+		log.Infof(ts.c, "Harvesting topic: %v", topic.Query)
+		mb := ts.harvester
+		mb.c = ts.c
+		summary, err := mb.harvest(topic.Query)
+		result.Summary = summary
+		if _, ok := err.(*RateLimitError); ok {
+			log.Infof(ts.c, "Deferring %v. %v", topic.Query, err.Error())
+			limited = true
+			results = append(results, result)
+			continue
+		}
+		if err != nil {
+			log.Errorf(ts.c, "Failed to harvest %v. %v", topic.Query, err.Error())
+			result.Status = harvestFailed
+			result.Error = err.Error()
+			results = append(results, result)
+			ts.recordHarvest(topic.Query, func(stored *Topic) {
+				stored.Failures++
+				stored.LastFailure = now
+			})
+			continue
+		}
+
+		ts.recordHarvest(topic.Query, func(stored *Topic) {
+			stored.LastHarvest = now
+			stored.Failures = 0
+			stored.LastFailure = time.Time{}
+		})
+		result.Status = harvestDone
+		results = append(results, result)
+	}
+	return results
+}
+
+//recordHarvest applies record to the stored topic for the query.  The topic is
+// read again rather than written from the copy that was harvested, so that edits
+// made through /topics during the harvest are kept.
+func (ts TopicScheduler) recordHarvest(query string, record func(stored *Topic)) {
+	err := ts.store.UpdateTopic(ts.c, query, func(stored *Topic, exists bool) error {
+		if !exists {
+			return errTopicDeleted
+		}
+		record(stored)
+		return nil
+	})
+	if err != nil {
+		log.Errorf(ts.c, "Failed to record harvest of %v. %v", query, err.Error())
+	}
+}
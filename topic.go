@@ -0,0 +1,226 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+//Topic is a query that is harvested on a schedule and has a feed of its own.
+type Topic struct {
+	Query       string
+	Title       string
+	Interval    time.Duration
+	Enabled     bool
+	LastHarvest time.Time
+	Created     time.Time
+
+	//Failures counts the harvests that have failed in a row, the last of them at
+	// LastFailure.  A topic whose harvests fail is backed off, see failureBackoff.
+	Failures    int
+	LastFailure time.Time
+
+	//Embed names the EmbedProvider that renders the tweets in the feed,
+	// defaultEmbed if it is empty.
+	Embed string
+
+	//Filters are applied to the topic's tweets in the map stage, after tweets
+	// without links are dropped.  Every tweet with a link is kept if it is nil.
+	Filters *FilterConfig `datastore:"-"`
+}
+
+const topicKind string = "Topic"
+
+//defaultInterval is used for topics that don't set an Interval, and matches the
+// hourly harvest in cron.yaml.
+const defaultInterval = time.Hour
+
+//minInterval keeps a topic from using up the Twitter rate limit on its own.
+const minInterval = 5 * time.Minute
+
+//maxFailureBackoff is the longest a topic whose harvests keep failing waits to be
+// tried again, unless its interval is longer.
+const maxFailureBackoff = 24 * time.Hour
+
+//scheduleSlack lets a topic run slightly early, so that a cron schedule that
+// fires a few seconds late doesn't push every harvest back a whole cron period.
+const scheduleSlack = time.Minute
+
+//validate checks that the topic can be stored.
+func (topic *Topic) validate() error {
+	if topic.Query == "" {
+		return errors.New("Topic has no query.")
+	}
+	if topic.Interval != 0 && topic.Interval < minInterval {
+		return errors.New("Topic interval must be at least " + minInterval.String() + ".")
+	}
+	if topic.Embed != "" && !validEmbed(topic.Embed) {
+		return errors.New("Topic embed must be one of oembed, template or stub.")
+	}
+	if _, err := topic.filter(nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+//interval returns how often the topic is harvested.
+func (topic *Topic) interval() time.Duration {
+	if topic.Interval == 0 {
+		return defaultInterval
+	}
+	return topic.Interval
+}
+
+//due reports whether the topic should be harvested at the given time.
+func (topic *Topic) due(now time.Time) bool {
+	if !topic.Enabled {
+		return false
+	}
+	if topic.Failures > 0 {
+		return now.Add(scheduleSlack).Sub(topic.LastFailure) >= topic.failureBackoff()
+	}
+	return now.Add(scheduleSlack).Sub(topic.LastHarvest) >= topic.interval()
+}
+
+//failureBackoff returns how long the topic waits after its last failed harvest
+// before it is tried again: its interval, doubled for each failure after the
+// first, up to maxFailureBackoff.
+func (topic *Topic) failureBackoff() time.Duration {
+	limit := maxFailureBackoff
+	if topic.interval() > limit {
+		limit = topic.interval()
+	}
+	backoff := topic.interval()
+	for i := 1; i < topic.Failures && backoff < limit; i++ {
+		backoff *= 2
+	}
+	if backoff > limit {
+		backoff = limit
+	}
+	return backoff
+}
+
+//embed returns the name of the EmbedProvider for the topic's feed.
+func (topic *Topic) embed() string {
+	if topic.Embed == "" {
+		return defaultEmbed
+	}
+	return topic.Embed
+}
+
+//filter returns the Filter for the topic's tweets, or nil if it has no Filters.
+// rejected, if it isn't nil, is called for each tweet its SpamFilters drop.
+func (topic *Topic) filter(rejected func(rejection SpamRejection)) (Filter, error) {
+	if topic.Filters == nil {
+		return nil, nil
+	}
+	return topic.Filters.build(rejected, false)
+}
+
+//feedTitle returns the title for the topic's feed.
+func (topic *Topic) feedTitle() string {
+	if topic.Title != "" {
+		return topic.Title
+	}
+	return defaultFeedTitle(topic.Query)
+}
+
+//defaultFeedTitle is the title of the feed for a query without a Topic title.
+func defaultFeedTitle(query string) string {
+	return "Top articles from twitter about: " + query
+}
+
+//topicJSON is the representation of a Topic used by the /topics endpoints.
+type topicJSON struct {
+	Query       string        `json:"query"`
+	Title       string        `json:"title"`
+	Interval    string        `json:"interval"`
+	Enabled     bool          `json:"enabled"`
+	LastHarvest time.Time     `json:"last_harvest"`
+	Failures    int           `json:"failures"`
+	LastFailure time.Time     `json:"last_failure"`
+	Created     time.Time     `json:"created"`
+	Embed       string        `json:"embed"`
+	Filters     *FilterConfig `json:"filters,omitempty"`
+}
+
+//MarshalJSON writes the topic with its Interval as a duration string, e.g. "1h0m0s".
+func (topic Topic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topicJSON{
+		Query:       topic.Query,
+		Title:       topic.Title,
+		Interval:    topic.interval().String(),
+		Enabled:     topic.Enabled,
+		LastHarvest: topic.LastHarvest,
+		Failures:    topic.Failures,
+		LastFailure: topic.LastFailure,
+		Created:     topic.Created,
+		Embed:       topic.embed(),
+		Filters:     topic.Filters,
+	})
+}
+
+//UnmarshalJSON reads a topic written by MarshalJSON.  The Interval may be left
+// out to use the default.
+func (topic *Topic) UnmarshalJSON(raw []byte) error {
+	var in topicJSON
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	if in.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(in.Interval); err != nil {
+			return err
+		}
+	}
+
+	*topic = Topic{
+		Query:       in.Query,
+		Title:       in.Title,
+		Interval:    interval,
+		Enabled:     in.Enabled,
+		LastHarvest: in.LastHarvest,
+		Failures:    in.Failures,
+		LastFailure: in.LastFailure,
+		Created:     in.Created,
+		Embed:       in.Embed,
+		Filters:     in.Filters,
+	}
+	return nil
+}
+
+//Load fulfills the PropertyLoadSaver interface.  The Filters are restored from
+// the JSON copy written by Save.
+func (topic *Topic) Load(properties []datastore.Property) error {
+	var rest []datastore.Property
+	for _, property := range properties {
+		if property.Name != "Filters" {
+			rest = append(rest, property)
+			continue
+		}
+		raw, _ := property.Value.([]byte)
+		topic.Filters = &FilterConfig{}
+		if err := json.Unmarshal(raw, topic.Filters); err != nil {
+			return err
+		}
+	}
+	return datastore.LoadStruct(topic, rest)
+}
+
+//Save fulfills the PropertyLoadSaver interface.  A FilterConfig nests itself, which
+// the datastore can't store, so it is kept as unindexed JSON.
+func (topic *Topic) Save() ([]datastore.Property, error) {
+	properties, err := datastore.SaveStruct(topic)
+	if err != nil || topic.Filters == nil {
+		return properties, err
+	}
+	raw, err := json.Marshal(topic.Filters)
+	if err != nil {
+		return nil, err
+	}
+	return append(properties, datastore.Property{Name: "Filters", Value: raw, NoIndex: true}), nil
+}
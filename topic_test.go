@@ -0,0 +1,81 @@
+package tweetharvest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTopicDue(t *testing.T) {
+	now := time.Date(2015, 11, 15, 10, 0, 0, 0, time.UTC)
+	topic := &Topic{Query: "golang", Enabled: true}
+
+	if !topic.due(now) {
+		t.Errorf("A topic that was never harvested should be due")
+	}
+
+	topic.LastHarvest = now.Add(-30 * time.Minute)
+	if topic.due(now) {
+		t.Errorf("A topic harvested 30 minutes ago should not be due with the default interval")
+	}
+
+	//cron firing a few seconds early should not skip a whole period
+	topic.LastHarvest = now.Add(-time.Hour + 10*time.Second)
+	if !topic.due(now) {
+		t.Errorf("A topic should be due within the schedule slack")
+	}
+
+	//A failing topic waits an interval after its last failure, doubled for each
+	// failure after the first.
+	topic.Failures = 1
+	topic.LastFailure = now.Add(-30 * time.Minute)
+	if topic.due(now) {
+		t.Errorf("A topic that failed 30 minutes ago should be backed off")
+	}
+	topic.LastFailure = now.Add(-time.Hour)
+	if !topic.due(now) {
+		t.Errorf("A topic that failed an hour ago should be due again")
+	}
+	topic.Failures = 3
+	if topic.due(now) || topic.failureBackoff() != 4*time.Hour {
+		t.Errorf("Expected a 4h backoff after 3 failures, got %v", topic.failureBackoff())
+	}
+	if topic.Failures = 20; topic.failureBackoff() != maxFailureBackoff {
+		t.Errorf("Expected the backoff to be capped at %v, got %v", maxFailureBackoff, topic.failureBackoff())
+	}
+
+	topic.Enabled = false
+	if topic.due(now) {
+		t.Errorf("A disabled topic should never be due")
+	}
+}
+
+func TestTopicJSON(t *testing.T) {
+	var topic Topic
+	err := json.Unmarshal([]byte(`{"query": "golang", "interval": "30m", "enabled": true}`), &topic)
+	if err != nil {
+		t.Fatalf("Failed to decode topic: %v", err)
+	}
+	if topic.Interval != 30*time.Minute || !topic.Enabled {
+		t.Errorf("Decoded topic is wrong: %+v", topic)
+	}
+
+	if err := json.Unmarshal([]byte(`{"query": "golang", "interval": "soon"}`), &topic); err == nil {
+		t.Errorf("Expected an invalid interval to fail")
+	}
+
+	topic = Topic{Query: "golang", Interval: time.Minute}
+	if err := topic.validate(); err == nil {
+		t.Errorf("Expected an interval below the minimum to be invalid")
+	}
+
+	topic = Topic{Query: "golang", Embed: "iframe"}
+	if err := topic.validate(); err == nil {
+		t.Errorf("Expected an unknown embed provider to be invalid")
+	}
+
+	topic = Topic{Query: "golang", Filters: &FilterConfig{Or: []*FilterConfig{}}}
+	if err := topic.validate(); err == nil {
+		t.Errorf("Expected invalid filters to make the topic invalid")
+	}
+}
@@ -0,0 +1,134 @@
+package tweetharvest
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//MinFollowersFilter passes tweets from users with at least Min followers.
+type MinFollowersFilter struct {
+	Min int
+}
+
+//Filter returns true if the author has enough followers.
+func (filter MinFollowersFilter) Filter(tweet *anaconda.Tweet) bool {
+	return tweet.User.FollowersCount >= filter.Min
+}
+
+//LanguageFilter passes tweets that Twitter detected as one of the Languages,
+// given as BCP 47 codes, e.g. en.
+type LanguageFilter struct {
+	Languages []string
+}
+
+//Filter returns true if the tweet's language is one of the Languages.
+func (filter LanguageFilter) Filter(tweet *anaconda.Tweet) bool {
+	for _, language := range filter.Languages {
+		if strings.EqualFold(tweet.Lang, language) {
+			return true
+		}
+	}
+	return false
+}
+
+//VerifiedFilter passes tweets from verified users.
+type VerifiedFilter struct{}
+
+//Filter returns true if the author is verified.
+func (VerifiedFilter) Filter(tweet *anaconda.Tweet) bool {
+	return tweet.User.Verified
+}
+
+//RetweetFilter rejects retweets, keeping tweets that share a link themselves.
+type RetweetFilter struct{}
+
+//Filter returns true if the tweet is not a retweet.
+func (RetweetFilter) Filter(tweet *anaconda.Tweet) bool {
+	return tweet.RetweetedStatus == nil
+}
+
+//DomainFilter passes tweets that link to at least one address whose domain is
+// in Allow, if it is set, and not in Deny.  A domain also matches its
+// subdomains.  Addresses are checked as tweeted, before shortened links are
+// resolved.
+type DomainFilter struct {
+	Allow []string
+	Deny  []string
+}
+
+//Filter returns true if one of the tweet's links is on an accepted domain.
+func (filter DomainFilter) Filter(tweet *anaconda.Tweet) bool {
+	for _, link := range tweet.Entities.Urls {
+		u, err := url.Parse(link.Expanded_url)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		if len(filter.Allow) > 0 && !matchDomain(host, filter.Allow) {
+			continue
+		}
+		if !matchDomain(host, filter.Deny) {
+			return true
+		}
+	}
+	return false
+}
+
+//matchDomain reports whether host is one of the domains or a subdomain of one.
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(domain), "www.")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+//UserBlockFilter rejects tweets from the users with the given ScreenNames.
+type UserBlockFilter struct {
+	ScreenNames []string
+}
+
+//Filter returns true if the author is not blocked.
+func (filter UserBlockFilter) Filter(tweet *anaconda.Tweet) bool {
+	for _, name := range filter.ScreenNames {
+		if strings.EqualFold(tweet.User.ScreenName, strings.TrimPrefix(name, "@")) {
+			return false
+		}
+	}
+	return true
+}
+
+//KeywordFilter rejects tweets whose text contains any of the Keywords, ignoring
+// case.
+type KeywordFilter struct {
+	Keywords []string
+}
+
+//Filter returns true if the tweet contains none of the keywords.
+func (filter KeywordFilter) Filter(tweet *anaconda.Tweet) bool {
+	text := tweet.FullText
+	if text == "" {
+		text = tweet.Text
+	}
+	text = strings.ToLower(text)
+	for _, keyword := range filter.Keywords {
+		if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+	return true
+}
+
+//MaxHashtagsFilter rejects tweets with more than Max hashtags.
+type MaxHashtagsFilter struct {
+	Max int
+}
+
+//Filter returns true if the tweet has at most Max hashtags.
+func (filter MaxHashtagsFilter) Filter(tweet *anaconda.Tweet) bool {
+	return len(tweet.Entities.Hashtags) <= filter.Max
+}
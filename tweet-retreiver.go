@@ -1,69 +1,116 @@
 package tweetharvest
 
 import (
+	"context"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/AndyNortrup/TweetHarvest/log"
 	"github.com/ChimeraCoder/anaconda"
-	"golang.org/x/net/context"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 )
 
-//TweetRetriever is responsible for getting a list of Tweets from the Twitter API
+//defaultMaxPages is the number of pages of search results a harvest fetches
+// unless Services sets another.  It bounds how far back the first harvest of a
+// query goes, and how much of a burst a later one catches up on at a time.
+const defaultMaxPages = 10
+
+//searchPageSize is the number of tweets asked for on each page, the most the
+// search API returns.
+const searchPageSize = 100
+
+//TweetRetriever is responsible for getting a list of Tweets from a TweetSource
 type TweetRetriever struct {
-	context context.Context
-	out     chan<- anaconda.Tweet
+	context  context.Context
+	out      chan<- anaconda.Tweet
+	source   TweetSource
+	maxPages int
+
+	//limit is the rate limit of the search endpoint, updated after each page.
+	limit *RateLimit
+
+	//maxID is the newest tweet retrieved, resumeID the max_id of the next page if
+	// the retriever stopped at maxPages, and err the error that stopped it, if
+	// any.  All are set before out is closed.
+	maxID    int64
+	resumeID int64
+	err      error
 }
 
-//getTweets gets all tweets from twitter with the speified keyword
-func (tr TweetRetriever) getTweets(query string,
-	cutoff time.Time,
+//getTweets gets the tweets from the source with the specified keyword that are
+// newer than sinceID, newest first, following the pages of results back to
+// sinceID or until maxPages have been fetched.  If resumeID is set the first
+// page is the one at that max_id.  It stops early if the rate limit runs out.
+func (tr *TweetRetriever) getTweets(query string,
+	sinceID int64,
+	resumeID int64,
 	wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	defer close(tr.out)
 
-	log.Infof(tr.context, "Downloading Tweets.")
-	anaconda.SetConsumerKey(consumerKey)
-	anaconda.SetConsumerSecret(consumerSecretKey)
+	log.Infof(tr.context, "Downloading Tweets since %v.", sinceID)
 
-	api := anaconda.NewTwitterApi(accessToken, accessTokenSecret)
+	v := url.Values{}
+	if resumeID > 0 {
+		log.Infof(tr.context, "Resuming from %v.", resumeID)
+		v.Set("max_id", strconv.FormatInt(resumeID, 10))
+	}
+	for page := 0; page < tr.maxPages && v != nil; page++ {
+		v.Set("count", strconv.Itoa(searchPageSize))
+		if sinceID > 0 {
+			v.Set("since_id", strconv.FormatInt(sinceID, 10))
+		}
 
-	api.HttpClient.Transport = &urlfetch.Transport{Context: tr.context}
+		if err := tr.limit.allowed(time.Now()); err != nil {
+			log.Infof(tr.context, "Harvester- getTweets: %v", err.Error())
+			tr.err = err
+			return
+		}
 
-	result, err := api.GetSearch(query, nil)
+		result, headers, err := tr.search(query, v)
+		if limited, headers := rateLimited(err); limited {
+			tr.err = tr.limit.backoff(headers, time.Now())
+			log.Errorf(tr.context, "Harvester- getTweets: %v", tr.err.Error())
+			return
+		}
+		if err != nil {
+			log.Errorf(tr.context, "Harvester- getTweets: %v", err.Error())
+			tr.err = err
+			return
+		}
+		tr.limit.observe(headers, time.Now())
+		if len(result.Statuses) == 0 {
+			return
+		}
 
-	if err != nil {
-		log.Errorf(tr.context, "Harvester- getTweets: %v", err.Error())
-		return
-	}
-	cont := true
-
-	for cont {
-		cont = tr.addIfNewerThan(cutoff, result)
-		cont = false
-		if cont {
-			result, err = result.GetNext(api)
-			//log.Infof(c, "Getting more tweets!")
-			if err != nil {
-				log.Errorf(tr.context, "Harvester- getTweets: %v", err.Error())
+		for _, tweet := range result.Statuses {
+			if tweet.Id > tr.maxID {
+				tr.maxID = tweet.Id
 			}
+			tr.out <- tweet
+		}
+
+		if v, err = nextPageValues(result); err != nil {
+			log.Errorf(tr.context, "Harvester- getTweets: %v", err.Error())
+			tr.err = err
+			return
 		}
 	}
-	close(tr.out)
+	if v != nil {
+		tr.resumeID, _ = strconv.ParseInt(v.Get("max_id"), 10, 64)
+		log.Infof(tr.context, "Stopped after %v pages of %v, the next is at %v.",
+			tr.maxPages, query, tr.resumeID)
+	}
 }
 
-func (tr TweetRetriever) addIfNewerThan(cutoff time.Time,
-	result anaconda.SearchResponse) bool {
-
-	cont := true
-	for _, tweet := range result.Statuses {
-		if time, _ := tweet.CreatedAtTime(); time.After(cutoff) {
-			tr.out <- tweet
-		} else {
-			cont = false
-			break
-		}
+//search gets a page of results from the source, with its rate limit headers if
+// the source is a LimitedSource.
+func (tr *TweetRetriever) search(query string, v url.Values) (anaconda.SearchResponse, *RateLimitHeaders, error) {
+	if limited, ok := tr.source.(LimitedSource); ok {
+		return limited.LimitedSearch(tr.context, query, v)
 	}
-	return cont
+	result, err := tr.source.Search(tr.context, query, v)
+	return result, nil, err
 }
@@ -1,15 +1,15 @@
 package tweetharvest
 
 import (
+	"context"
 	"time"
 
-	"golang.org/x/net/context"
-
 	"github.com/gorilla/feeds"
 )
 
 //TweetScore is a struct that shows the relative score of an address based on
-// it's populatrity
+// it's populatrity.  Address is canonical; OriginalAddress is the first form of it
+// that was tweeted, and is the one linked to from feeds.
 type TweetScore struct {
 	Address    string
 	Score      int
@@ -17,15 +17,112 @@ type TweetScore struct {
 	TweetIDs   []int64
 	Query      string
 	Title      string
+	FirstSeen  time.Time
+
+	OriginalAddress string
+
+	//Metadata of the page at the address, see PageMetadata.
+	Description string `datastore:",noindex"`
+	Image       string `datastore:",noindex"`
+	Card        string `datastore:",noindex"`
+	Author      string `datastore:",noindex"`
+	SiteName    string `datastore:",noindex"`
+	Published   time.Time
+
+	//Updated is when the score was last written.  It is set by the Store, and
+	// changes with every write, unlike LastActive.
+	Updated time.Time
+
+	//Shares are the users counted in Score, each with the best score they've
+	// given the address so far.
+	Shares []Share `datastore:",noindex"`
 }
 
 //GetFeedItem returns a feeds.Item to be inserted into an RSS or Atom feed
 func (score TweetScore) GetFeedItem(id string, c context.Context) *feeds.Item {
 	entry := &feeds.Item{
-		Link:    &feeds.Link{Href: score.Address},
+		Link:    &feeds.Link{Href: score.link()},
 		Created: score.LastActive,
 		Id:      id,
 	}
 
 	return entry
 }
+
+//link returns the address to link to when displaying the score.
+func (score TweetScore) link() string {
+	if score.OriginalAddress != "" {
+		return score.OriginalAddress
+	}
+	return score.Address
+}
+
+//merge adds the score calculated from new tweets to this one.  Each new share
+// adds only what it scores above the user's share so far, so that a user posting
+// the link again, or a retweet carrying newer counts of a tweet already scored,
+// isn't counted twice; an update without shares is added as it is.  The TweetIDs
+// are joined without repeats, and LastActive and FirstSeen widened to cover both.
+// Fields the score already has are otherwise kept, so that the page metadata and
+// the first address tweeted are not lost.
+func (score *TweetScore) merge(update *TweetScore) {
+	if score.Address == "" {
+		score.Address = update.Address
+	}
+	if score.Query == "" {
+		score.Query = update.Query
+	}
+	if score.OriginalAddress == "" {
+		score.OriginalAddress = update.OriginalAddress
+	}
+
+	if len(update.Shares) == 0 {
+		score.Score += update.Score
+	}
+	for _, share := range update.Shares {
+		score.addShare(share)
+	}
+	if update.LastActive.After(score.LastActive) {
+		score.LastActive = update.LastActive
+	}
+	if score.FirstSeen.IsZero() ||
+		(!update.FirstSeen.IsZero() && update.FirstSeen.Before(score.FirstSeen)) {
+		score.FirstSeen = update.FirstSeen
+	}
+
+	seen := make(map[int64]bool, len(score.TweetIDs))
+	for _, id := range score.TweetIDs {
+		seen[id] = true
+	}
+	for _, id := range update.TweetIDs {
+		if !seen[id] {
+			seen[id] = true
+			score.TweetIDs = append(score.TweetIDs, id)
+		}
+	}
+}
+
+//addShare adds what the share scores above the user's current share to the score.
+func (score *TweetScore) addShare(share Share) {
+	for i, counted := range score.Shares {
+		if counted.User == share.User {
+			if share.Score > counted.Score {
+				score.Score += share.Score - counted.Score
+				score.Shares[i].Score = share.Score
+			}
+			return
+		}
+	}
+	score.Score += share.Score
+	score.Shares = append(score.Shares, share)
+}
+
+//setMetadata copies the metadata of the page at the address onto the score.
+func (score *TweetScore) setMetadata(metadata PageMetadata) {
+	score.Title = metadata.Title
+	score.Description = metadata.Description
+	score.Image = metadata.Image
+	score.Card = metadata.Card
+	score.Author = metadata.Author
+	score.SiteName = metadata.SiteName
+	score.Published = metadata.Published
+}
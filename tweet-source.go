@@ -0,0 +1,98 @@
+package tweetharvest
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/ChimeraCoder/anaconda"
+)
+
+//TweetSource is where the TweetRetriever gets its search results from.
+type TweetSource interface {
+	//Search returns a page of search results for the query.  v holds any extra
+	// search parameters, such as the max_id of the next page.
+	Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error)
+}
+
+//LimitedSource is a TweetSource that reports the rate limit headers of each
+// search, so that the TweetRetriever can stay within them.  Sources that aren't
+// LimitedSources, such as ReplaySource, are treated as unlimited.
+type LimitedSource interface {
+	TweetSource
+
+	//LimitedSearch is Search that also returns the rate limit headers of the
+	// response, or nil if it had none.
+	LimitedSearch(c context.Context, query string, v url.Values) (anaconda.SearchResponse, *RateLimitHeaders, error)
+}
+
+//TwitterSource is a TweetSource that calls the Twitter search API with the
+// given credentials.
+type TwitterSource struct {
+	ConsumerKey       string
+	ConsumerSecret    string
+	AccessToken       string
+	AccessTokenSecret string
+}
+
+//Search runs the query against the Twitter search API.
+func (source TwitterSource) Search(c context.Context, query string, v url.Values) (anaconda.SearchResponse, error) {
+	result, _, err := source.LimitedSearch(c, query, v)
+	return result, err
+}
+
+//LimitedSearch runs the query against the Twitter search API, keeping the rate
+// limit headers of the response.
+func (source TwitterSource) LimitedSearch(c context.Context, query string,
+	v url.Values) (anaconda.SearchResponse, *RateLimitHeaders, error) {
+
+	anaconda.SetConsumerKey(source.ConsumerKey)
+	anaconda.SetConsumerSecret(source.ConsumerSecret)
+
+	api := anaconda.NewTwitterApi(source.AccessToken, source.AccessTokenSecret)
+	defer api.Close()
+
+	client := *httpClient(c)
+	recorder := &headerRecorder{next: client.Transport}
+	client.Transport = recorder
+	api.HttpClient = &client
+
+	result, err := api.GetSearch(query, v)
+	return result, parseRateLimitHeaders(recorder.header), err
+}
+
+//headerRecorder is an http.RoundTripper that keeps the headers of the last
+// response.
+type headerRecorder struct {
+	next   http.RoundTripper
+	header http.Header
+}
+
+func (recorder *headerRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	next := recorder.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	response, err := next.RoundTrip(request)
+	if err == nil {
+		recorder.header = response.Header
+	}
+	return response, err
+}
+
+//nextPageValues returns the search parameters for the page after result, or nil
+// if result is the last page.
+func nextPageValues(result anaconda.SearchResponse) (url.Values, error) {
+	if result.Metadata.NextResults == "" {
+		return nil, nil
+	}
+	next, err := url.Parse(result.Metadata.NextResults)
+	if err != nil {
+		return nil, err
+	}
+
+	//The query is passed to Search separately.
+	v := next.Query()
+	v.Del("q")
+	return v, nil
+}
@@ -6,6 +6,24 @@ import (
 	"github.com/ChimeraCoder/anaconda"
 )
 
+//fixtureTweet finds a tweet by ID in the recorded golang search results.
+func fixtureTweet(t *testing.T, id int64) anaconda.Tweet {
+	source, err := NewReplaySource("testdata/search/golang.json")
+	if err != nil {
+		t.Fatalf("Unable to load search fixtures. \nError:%v", err)
+	}
+
+	for _, page := range source.pages["golang"] {
+		for _, tweet := range page.Statuses {
+			if tweet.Id == id {
+				return tweet
+			}
+		}
+	}
+	t.Fatalf("Tweet %v is not in the search fixtures", id)
+	return anaconda.Tweet{}
+}
+
 func TestURLFIlter(t *testing.T) {
 	//known quantity tweets from the author's feed
 	var noLink int64 = 665323700086923264
@@ -13,24 +31,13 @@ func TestURLFIlter(t *testing.T) {
 
 	out := make(chan *anaconda.Tweet, 2)
 
-	anaconda.SetConsumerKey(consumerKey)
-	anaconda.SetConsumerSecret(consumerSecretKey)
-	api := anaconda.NewTwitterApi(accessToken, accessTokenSecret)
-	tweetNoLink, err := api.GetTweet(noLink, nil)
+	tweetNoLink := fixtureTweet(t, noLink)
 
 	var filter URLFilter
 
-	if err != nil {
-		t.Errorf("Unable to retrieve Tweet ID from Twitter.  \nError:%v", err)
-	}
-
 	FilterTweet(&tweetNoLink, filter, out)
 
-	tweetWithLink, err := api.GetTweet(withLink, nil)
-
-	if err != nil {
-		t.Errorf("Unable to retrieve Tweet ID from Twitter.  \nError:%v", err)
-	}
+	tweetWithLink := fixtureTweet(t, withLink)
 	FilterTweet(&tweetWithLink, filter, out)
 	output := <-out
 